@@ -0,0 +1,74 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	cryptox509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+type ChallengeType string
+
+const (
+	HTTP01    ChallengeType = "http-01"
+	TLSALPN01 ChallengeType = "tls-alpn-01"
+)
+
+// HTTP01PathPrefix where the certificate authority fetches HTTP-01 key authorizations.
+const HTTP01PathPrefix = "/.well-known/acme-challenge/"
+
+// ALPNProtocol the protocol a TLS-ALPN-01 validation negotiates, RFC 8737.
+const ALPNProtocol = "acme-tls/1"
+
+var acmeIdentifierOid = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// tlsAlpn01Certificate the self-signed certificate answering a TLS-ALPN-01 challenge:
+// it holds the hostname and the digest of the key authorization in a critical
+// acmeIdentifier extension.
+func tlsAlpn01Certificate(hostname, keyAuthorization string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	extensionValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &cryptox509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{
+			Id:       acmeIdentifierOid,
+			Critical: true,
+			Value:    extensionValue,
+		}},
+	}
+	certificateBytes, err := cryptox509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" tlsAlpn01Certificate: %s", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{certificateBytes},
+		PrivateKey:  key,
+	}, nil
+}
+
+// isTLSALPN01Hello reports whether the client hello is a TLS-ALPN-01 validation.
+func isTLSALPN01Hello(clientHello *tls.ClientHelloInfo) bool {
+	return len(clientHello.SupportedProtos) == 1 && clientHello.SupportedProtos[0] == ALPNProtocol
+}
@@ -0,0 +1,413 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusValid      = "valid"
+	StatusInvalid    = "invalid"
+
+	badNonceProblemType = "urn:ietf:params:acme:error:badNonce"
+
+	maxResponseBytes = 1 << 20
+)
+
+// Problem an RFC 7807 problem document returned by the certificate authority.
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf(TRACE+" Problem %d %s: %s", p.Status, p.Type, p.Detail)
+}
+
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type Order struct {
+	Url            string       `json:"-"`
+	Status         string       `json:"status"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+}
+
+type Authorization struct {
+	Url        string      `json:"-"`
+	Status     string      `json:"status"`
+	Identifier Identifier  `json:"identifier"`
+	Challenges []Challenge `json:"challenges"`
+}
+
+// Challenge finds the challenge of the given type among those offered.
+func (a *Authorization) Challenge(challengeType ChallengeType) (Challenge, bool) {
+	for _, challenge := range a.Challenges {
+		if challenge.Type == challengeType {
+			return challenge, true
+		}
+	}
+	return Challenge{}, false
+}
+
+type Challenge struct {
+	Type   ChallengeType `json:"type"`
+	Url    string        `json:"url"`
+	Token  string        `json:"token"`
+	Status string        `json:"status"`
+	Error  *Problem      `json:"error,omitempty"`
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+// Client speaks RFC 8555 to a certificate authority on behalf of one account.
+type Client struct {
+	DirectoryUrl string
+	HTTPClient   *http.Client
+	// PollInterval between two polls of a pending order or authorization, when the
+	// certificate authority does not send a Retry-After.
+	PollInterval time.Duration
+
+	key *ecdsa.PrivateKey
+	jwk jwk
+
+	mutex     sync.Mutex
+	directory *directory
+	kid       string
+	nonces    []string
+}
+
+func NewClient(directoryUrl string, accountKey *ecdsa.PrivateKey) *Client {
+	return &Client{
+		DirectoryUrl: directoryUrl,
+		HTTPClient:   http.DefaultClient,
+		PollInterval: time.Second,
+		key:          accountKey,
+		jwk:          newJwk(&accountKey.PublicKey),
+	}
+}
+
+// KeyAuthorization the response to the challenge token, for this account.
+func (c *Client) KeyAuthorization(token string) string {
+	return token + "." + c.jwk.thumbprint()
+}
+
+// Register creates the account of the key, or finds it if it already exists.
+func (c *Client) Register(ctx context.Context, contacts []string) error {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"termsOfServiceAgreed": true,
+	}
+	if len(contacts) > 0 {
+		payload["contact"] = contacts
+	}
+	header, _, err := c.post(ctx, d.NewAccount, payload, nil)
+	if err != nil {
+		return fmt.Errorf(TRACE+" Client Register: %s", err)
+	}
+	kid := header.Get("Location")
+	if kid == "" {
+		return fmt.Errorf(TRACE + " Client Register: accountLocationMissing")
+	}
+
+	c.mutex.Lock()
+	c.kid = kid
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *Client) NewOrder(ctx context.Context, hostnames []string) (*Order, error) {
+	d, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	identifiers := make([]Identifier, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		identifiers = append(identifiers, Identifier{Type: "dns", Value: hostname})
+	}
+	order := &Order{}
+	header, _, err := c.post(ctx, d.NewOrder, map[string]interface{}{"identifiers": identifiers}, order)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Client NewOrder: %s", err)
+	}
+	order.Url = header.Get("Location")
+	return order, nil
+}
+
+func (c *Client) GetOrder(ctx context.Context, orderUrl string) (*Order, error) {
+	order := &Order{Url: orderUrl}
+	if _, _, err := c.post(ctx, orderUrl, nil, order); err != nil {
+		return nil, fmt.Errorf(TRACE+" Client GetOrder: %s", err)
+	}
+	return order, nil
+}
+
+func (c *Client) GetAuthorization(ctx context.Context, authorizationUrl string) (*Authorization, error) {
+	authorization := &Authorization{Url: authorizationUrl}
+	if _, _, err := c.post(ctx, authorizationUrl, nil, authorization); err != nil {
+		return nil, fmt.Errorf(TRACE+" Client GetAuthorization: %s", err)
+	}
+	return authorization, nil
+}
+
+// AcceptChallenge tells the certificate authority the challenge response is in place.
+func (c *Client) AcceptChallenge(ctx context.Context, challenge Challenge) error {
+	if _, _, err := c.post(ctx, challenge.Url, struct{}{}, nil); err != nil {
+		return fmt.Errorf(TRACE+" Client AcceptChallenge: %s", err)
+	}
+	return nil
+}
+
+// WaitAuthorization polls the authorization until it is valid, or fails when it is not.
+func (c *Client) WaitAuthorization(ctx context.Context, authorizationUrl string) (*Authorization, error) {
+	for {
+		authorization := &Authorization{Url: authorizationUrl}
+		header, _, err := c.post(ctx, authorizationUrl, nil, authorization)
+		if err != nil {
+			return nil, fmt.Errorf(TRACE+" Client WaitAuthorization: %s", err)
+		}
+		switch authorization.Status {
+		case StatusValid:
+			return authorization, nil
+		case StatusPending, StatusProcessing:
+		default:
+			for _, challenge := range authorization.Challenges {
+				if challenge.Error != nil {
+					return nil, fmt.Errorf(TRACE+" Client WaitAuthorization %s: %s", authorization.Identifier.Value, challenge.Error)
+				}
+			}
+			return nil, fmt.Errorf(TRACE+" Client WaitAuthorization %s: status \"%s\"", authorization.Identifier.Value, authorization.Status)
+		}
+		if err := c.sleep(ctx, header); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// FinalizeOrder submits the DER certificate signing request and polls the order until
+// its certificate is issued.
+func (c *Client) FinalizeOrder(ctx context.Context, order *Order, csr []byte) (*Order, error) {
+	finalized := &Order{Url: order.Url}
+	header, _, err := c.post(ctx, order.Finalize, map[string]string{"csr": base64Url(csr)}, finalized)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Client FinalizeOrder: %s", err)
+	}
+	for {
+		switch finalized.Status {
+		case StatusValid:
+			return finalized, nil
+		case StatusPending, StatusReady, StatusProcessing:
+		default:
+			if finalized.Error != nil {
+				return nil, fmt.Errorf(TRACE+" Client FinalizeOrder: %s", finalized.Error)
+			}
+			return nil, fmt.Errorf(TRACE+" Client FinalizeOrder: status \"%s\"", finalized.Status)
+		}
+		if err := c.sleep(ctx, header); err != nil {
+			return nil, err
+		}
+		finalized = &Order{Url: order.Url}
+		if header, _, err = c.post(ctx, order.Url, nil, finalized); err != nil {
+			return nil, fmt.Errorf(TRACE+" Client FinalizeOrder: %s", err)
+		}
+	}
+}
+
+// FetchCertificate downloads the PEM certificate chain of a valid order.
+func (c *Client) FetchCertificate(ctx context.Context, certificateUrl string) ([]byte, error) {
+	_, body, err := c.post(ctx, certificateUrl, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Client FetchCertificate: %s", err)
+	}
+	return body, nil
+}
+
+func (c *Client) discover(ctx context.Context) (*directory, error) {
+	c.mutex.Lock()
+	d := c.directory
+	c.mutex.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DirectoryUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Client discover: %s", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(TRACE+" Client discover: status %d", response.StatusCode)
+	}
+	d = &directory{}
+	if err := json.NewDecoder(io.LimitReader(response.Body, maxResponseBytes)).Decode(d); err != nil {
+		return nil, fmt.Errorf(TRACE+" Client discover: %s", err)
+	}
+	if d.NewNonce == "" || d.NewAccount == "" || d.NewOrder == "" {
+		return nil, fmt.Errorf(TRACE + " Client discover: incompleteDirectory")
+	}
+
+	c.mutex.Lock()
+	c.directory = d
+	c.mutex.Unlock()
+	return d, nil
+}
+
+func (c *Client) nonce(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	if noncesLen := len(c.nonces); noncesLen > 0 {
+		nonce := c.nonces[noncesLen-1]
+		c.nonces = c.nonces[:noncesLen-1]
+		c.mutex.Unlock()
+		return nonce, nil
+	}
+	c.mutex.Unlock()
+
+	d, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, d.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf(TRACE+" Client nonce: %s", err)
+	}
+	response.Body.Close()
+	nonce := response.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf(TRACE + " Client nonce: replayNonceMissing")
+	}
+	return nonce, nil
+}
+
+func (c *Client) saveNonce(header http.Header) {
+	if nonce := header.Get("Replay-Nonce"); nonce != "" {
+		c.mutex.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mutex.Unlock()
+	}
+}
+
+// post sends a JWS signed request, and decodes the JSON response into result when it
+// is not nil. A nil payload makes a POST-as-GET. A rejected nonce is retried once.
+func (c *Client) post(ctx context.Context, url string, payload interface{}, result interface{}) (http.Header, []byte, error) {
+	var payloadBytes []byte
+	if payload != nil {
+		var err error
+		if payloadBytes, err = json.Marshal(payload); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		header, body, err := c.postOnce(ctx, url, payloadBytes)
+		var problem *Problem
+		if errors.As(err, &problem) && problem.Type == badNonceProblemType && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if result != nil {
+			if err := json.Unmarshal(body, result); err != nil {
+				return nil, nil, err
+			}
+		}
+		return header, body, nil
+	}
+}
+
+func (c *Client) postOnce(ctx context.Context, url string, payload []byte) (http.Header, []byte, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := jwsProtectedHeader{
+		Nonce: nonce,
+		Url:   url,
+	}
+	c.mutex.Lock()
+	if c.kid != "" {
+		header.Kid = c.kid
+	} else {
+		header.Jwk = &c.jwk
+	}
+	c.mutex.Unlock()
+
+	body, err := signJws(c.key, header, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	request.Header.Set("Content-Type", "application/jose+json")
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	c.saveNonce(response.Header)
+
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, maxResponseBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		problem := &Problem{Status: response.StatusCode}
+		if err := json.Unmarshal(responseBody, problem); err != nil || problem.Type == "" {
+			problem.Detail = string(responseBody)
+		}
+		return nil, nil, problem
+	}
+	return response.Header, responseBody, nil
+}
+
+// sleep waits for the Retry-After of the response, or the PollInterval.
+func (c *Client) sleep(ctx context.Context, header http.Header) error {
+	delay := c.PollInterval
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
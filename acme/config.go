@@ -0,0 +1,79 @@
+package acme
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/acme"
+
+const (
+	LetsEncryptDirectoryUrl        = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStagingDirectoryUrl = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+	defaultRenewBefore   = 30 * 24 * time.Hour
+	defaultCheckInterval = 12 * time.Hour
+)
+
+type AcmeConfig struct {
+	// DirectoryUrl the RFC 8555 directory of the certificate authority.
+	DirectoryUrl *string `json:"directoryUrl"`
+	// Email the account contact, optional.
+	Email *string `json:"email,omitempty"`
+	// StorageDir where the account key and the certificates are kept. They are only
+	// kept in memory when it is not set.
+	StorageDir *string `json:"storageDir,omitempty"`
+	// RenewBefore seconds before expiration a certificate is renewed, 30 days by default.
+	RenewBefore *int `json:"renewBefore,omitempty"`
+	// CheckInterval seconds between two checks for missing or expiring certificates,
+	// 12 hours by default.
+	CheckInterval *int `json:"checkInterval,omitempty"`
+	// InsecureSkipVerify does not verify the certificate of the directory, for local
+	// test certificate authorities only.
+	InsecureSkipVerify *bool `json:"insecureSkipVerify,omitempty"`
+}
+
+func (c AcmeConfig) String() string {
+	return util.ToJson(c)
+}
+
+func (c AcmeConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c AcmeConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("directoryUrl", c.DirectoryUrl != nil) {
+		u, err := url.Parse(*c.DirectoryUrl)
+		if err != nil {
+			v.Field("directoryUrl").Fail("mustBeValidUrl")
+		} else if u.Scheme != "https" && u.Scheme != "http" {
+			v.Field("directoryUrl").Fail("mustBeHttpOrHttpsUrl")
+		}
+	}
+	if c.StorageDir != nil && *c.StorageDir == "" {
+		v.Field("storageDir").Fail("mustNotBeEmpty")
+	}
+	if c.RenewBefore != nil && *c.RenewBefore <= 0 {
+		v.Field("renewBefore").Fail("mustBePositive")
+	}
+	if c.CheckInterval != nil && *c.CheckInterval <= 0 {
+		v.Field("checkInterval").Fail("mustBePositive")
+	}
+}
+
+func (c AcmeConfig) renewBefore() time.Duration {
+	if c.RenewBefore == nil {
+		return defaultRenewBefore
+	}
+	return time.Duration(*c.RenewBefore) * time.Second
+}
+
+func (c AcmeConfig) checkInterval() time.Duration {
+	if c.CheckInterval == nil {
+		return defaultCheckInterval
+	}
+	return time.Duration(*c.CheckInterval) * time.Second
+}
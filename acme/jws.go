@@ -0,0 +1,81 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// jwk the JSON Web Key of a P-256 public key, with its members in the lexicographic
+// order RFC 7638 requires for thumbprints.
+type jwk struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJwk(publicKey *ecdsa.PublicKey) jwk {
+	return jwk{
+		Crv: "P-256",
+		Kty: "EC",
+		X:   base64Url(publicKey.X.FillBytes(make([]byte, 32))),
+		Y:   base64Url(publicKey.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// thumbprint the RFC 7638 thumbprint of the key, used in key authorizations.
+func (k jwk) thumbprint() string {
+	jwkBytes, _ := json.Marshal(k)
+	digest := sha256.Sum256(jwkBytes)
+	return base64Url(digest[:])
+}
+
+type jwsProtectedHeader struct {
+	Alg   string `json:"alg"`
+	Nonce string `json:"nonce"`
+	Url   string `json:"url"`
+	Jwk   *jwk   `json:"jwk,omitempty"`
+	Kid   string `json:"kid,omitempty"`
+}
+
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// signJws signs the payload with ES256 in the flattened JSON serialization. A nil
+// payload is sent as the empty string of a POST-as-GET.
+func signJws(key *ecdsa.PrivateKey, header jwsProtectedHeader, payload []byte) ([]byte, error) {
+	header.Alg = "ES256"
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	message := jws{
+		Protected: base64Url(headerBytes),
+		Payload:   base64Url(payload),
+	}
+
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(message.Protected + "." + message.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" signJws: %s", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	message.Signature = base64Url(signature)
+
+	return json.Marshal(message)
+}
+
+func base64Url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,453 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	cryptox509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+const (
+	accountKeyStoreKey = "account.key"
+
+	minRetryBackoff = time.Minute
+)
+
+// HostnamesFunc the hostnames a Manager holds certificates for, and the challenge
+// each one is validated with.
+type HostnamesFunc func() map[string]ChallengeType
+
+// Manager issues a certificate for every hostname of its HostnamesFunc and renews it
+// before it expires.
+type Manager struct {
+	Config    AcmeConfig
+	Client    *Client
+	Store     Store
+	Hostnames HostnamesFunc
+
+	// certificateIndex the *x509.CertificateIndex of the issued certificates.
+	certificateIndex atomic.Value
+	refresh          chan struct{}
+
+	mutex                 sync.Mutex
+	registered            bool
+	certificates          map[string]*x509.Certificate
+	statuses              map[string]*HostnameStatus
+	http01Tokens          map[string]string
+	tlsAlpn01Certificates map[string]*tls.Certificate
+}
+
+// HostnameStatus the certificate of a managed hostname, and the last issuance failure.
+type HostnameStatus struct {
+	Hostname      string        `json:"hostname"`
+	ChallengeType ChallengeType `json:"challengeType"`
+	NotAfter      *time.Time    `json:"notAfter,omitempty"`
+	LastError     string        `json:"lastError,omitempty"`
+	LastAttempt   *time.Time    `json:"lastAttempt,omitempty"`
+
+	nextAttempt time.Time
+	backoff     time.Duration
+}
+
+type HostnameStatuses []HostnameStatus
+
+func (s HostnameStatuses) String() string {
+	return util.ToJson(s)
+}
+
+func NewManager(config AcmeConfig, hostnames HostnamesFunc) (*Manager, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	var store Store = NewMemoryStore()
+	if config.StorageDir != nil {
+		dirStore, err := NewDirStore(*config.StorageDir)
+		if err != nil {
+			return nil, err
+		}
+		store = dirStore
+	}
+
+	accountKey, err := loadOrCreateKey(store, accountKeyStoreKey)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" NewManager account key: %s", err)
+	}
+
+	client := NewClient(*config.DirectoryUrl, accountKey)
+	if config.InsecureSkipVerify != nil && *config.InsecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.HTTPClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	}
+
+	m := &Manager{
+		Config:                config,
+		Client:                client,
+		Store:                 store,
+		Hostnames:             hostnames,
+		refresh:               make(chan struct{}, 1),
+		certificates:          make(map[string]*x509.Certificate),
+		statuses:              make(map[string]*HostnameStatus),
+		http01Tokens:          make(map[string]string),
+		tlsAlpn01Certificates: make(map[string]*tls.Certificate),
+	}
+	m.certificateIndex.Store(x509.NewCertificateIndex())
+	return m, nil
+}
+
+// CertificateIndex the issued certificates, by hostname.
+func (m *Manager) CertificateIndex() *x509.CertificateIndex {
+	return m.certificateIndex.Load().(*x509.CertificateIndex)
+}
+
+// Refresh makes Run check the hostnames now, as they changed.
+func (m *Manager) Refresh() {
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the hostnames until ctx is done, every CheckInterval and on Refresh.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Config.checkInterval())
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.refresh:
+		}
+	}
+}
+
+// Check issues the certificates that are missing or expire within RenewBefore, and
+// forgets those of the hostnames that are no longer managed.
+func (m *Manager) Check(ctx context.Context) {
+	hostnames := m.Hostnames()
+	sortedHostnames := make([]string, 0, len(hostnames))
+	for hostname := range hostnames {
+		sortedHostnames = append(sortedHostnames, hostname)
+	}
+	sort.Strings(sortedHostnames)
+
+	m.mutex.Lock()
+	for hostname := range m.statuses {
+		if _, found := hostnames[hostname]; !found {
+			delete(m.statuses, hostname)
+			delete(m.certificates, hostname)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, hostname := range sortedHostnames {
+		if ctx.Err() != nil {
+			break
+		}
+		m.check(ctx, hostname, hostnames[hostname])
+	}
+	m.publish()
+}
+
+func (m *Manager) check(ctx context.Context, hostname string, challengeType ChallengeType) {
+	now := time.Now()
+
+	m.mutex.Lock()
+	status, found := m.statuses[hostname]
+	if !found {
+		status = &HostnameStatus{Hostname: hostname}
+		m.statuses[hostname] = status
+	}
+	status.ChallengeType = challengeType
+	certificate := m.certificates[hostname]
+	m.mutex.Unlock()
+
+	if certificate == nil {
+		if stored, err := m.loadCertificate(hostname); err == nil {
+			certificate = stored
+		} else if !errors.Is(err, ErrNotFound) {
+			// A broken stored certificate is issued again, the error is kept until then.
+			m.mutex.Lock()
+			status.LastError = fmt.Sprintf(TRACE+" Manager check stored certificate: %s", err)
+			m.mutex.Unlock()
+		}
+	}
+	if certificate == nil || certificate.NotAfter.Sub(now) < m.Config.renewBefore() {
+		m.mutex.Lock()
+		retry := now.After(status.nextAttempt)
+		m.mutex.Unlock()
+		if retry {
+			issued, err := m.Issue(ctx, hostname, challengeType)
+			m.mutex.Lock()
+			status.LastAttempt = &now
+			if err != nil {
+				status.LastError = err.Error()
+				status.backoff = nextBackoff(status.backoff, m.Config.checkInterval())
+				status.nextAttempt = now.Add(status.backoff)
+			} else {
+				status.LastError = ""
+				status.backoff = 0
+				status.nextAttempt = time.Time{}
+				certificate = issued
+			}
+			m.mutex.Unlock()
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if certificate != nil {
+		m.certificates[hostname] = certificate
+		status.NotAfter = &certificate.NotAfter
+	}
+}
+
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	if backoff < minRetryBackoff {
+		return minRetryBackoff
+	}
+	if backoff *= 2; backoff > max {
+		return max
+	}
+	return backoff
+}
+
+func (m *Manager) publish() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	index := x509.NewCertificateIndex()
+	for hostname, certificate := range m.certificates {
+		if err := index.Add(certificate); err != nil {
+			if status, found := m.statuses[hostname]; found {
+				status.LastError = fmt.Sprintf(TRACE+" Manager publish: %s", err)
+			}
+		}
+	}
+	m.certificateIndex.Store(index)
+}
+
+// Statuses the managed hostnames, sorted.
+func (m *Manager) Statuses() HostnameStatuses {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	statuses := make(HostnameStatuses, 0, len(m.statuses))
+	for _, status := range m.statuses {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Hostname < statuses[j].Hostname
+	})
+	return statuses
+}
+
+// Issue orders a certificate for the hostname, validates it with the challenge type and
+// stores it.
+func (m *Manager) Issue(ctx context.Context, hostname string, challengeType ChallengeType) (*x509.Certificate, error) {
+	if err := m.register(ctx); err != nil {
+		return nil, err
+	}
+
+	order, err := m.Client.NewOrder(ctx, []string{hostname})
+	if err != nil {
+		return nil, err
+	}
+	for _, authorizationUrl := range order.Authorizations {
+		if err := m.authorize(ctx, authorizationUrl, challengeType); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := cryptox509.CreateCertificateRequest(rand.Reader, &cryptox509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: hostname},
+		DNSNames: []string{hostname},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Manager Issue: %s", err)
+	}
+	if order, err = m.Client.FinalizeOrder(ctx, order, csr); err != nil {
+		return nil, err
+	}
+	certificateChainBytes, err := m.Client.FetchCertificate(ctx, order.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := cryptox509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	privateKeyBytes := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	certificate, err := x509.ParseCertificate(privateKeyBytes, certificateChainBytes)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Manager Issue: %s", err)
+	}
+
+	if err := m.Store.Put(hostname+".key", privateKeyBytes); err != nil {
+		return nil, fmt.Errorf(TRACE+" Manager Issue store: %s", err)
+	}
+	if err := m.Store.Put(hostname+".crt", certificateChainBytes); err != nil {
+		return nil, fmt.Errorf(TRACE+" Manager Issue store: %s", err)
+	}
+	return certificate, nil
+}
+
+func (m *Manager) register(ctx context.Context) error {
+	m.mutex.Lock()
+	registered := m.registered
+	m.mutex.Unlock()
+	if registered {
+		return nil
+	}
+
+	contacts := make([]string, 0, 1)
+	if m.Config.Email != nil && *m.Config.Email != "" {
+		contacts = append(contacts, "mailto:"+*m.Config.Email)
+	}
+	if err := m.Client.Register(ctx, contacts); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.registered = true
+	m.mutex.Unlock()
+	return nil
+}
+
+// authorize answers the challenge of an authorization, until the certificate authority
+// validated it.
+func (m *Manager) authorize(ctx context.Context, authorizationUrl string, challengeType ChallengeType) error {
+	authorization, err := m.Client.GetAuthorization(ctx, authorizationUrl)
+	if err != nil {
+		return err
+	}
+	if authorization.Status == StatusValid {
+		return nil
+	}
+	challenge, found := authorization.Challenge(challengeType)
+	if !found {
+		return fmt.Errorf(TRACE+" Manager authorize %s: challengeTypeNotOffered \"%s\"", authorization.Identifier.Value, challengeType)
+	}
+
+	keyAuthorization := m.Client.KeyAuthorization(challenge.Token)
+	hostname := authorization.Identifier.Value
+	switch challengeType {
+	case HTTP01:
+		m.mutex.Lock()
+		m.http01Tokens[challenge.Token] = keyAuthorization
+		m.mutex.Unlock()
+		defer func() {
+			m.mutex.Lock()
+			delete(m.http01Tokens, challenge.Token)
+			m.mutex.Unlock()
+		}()
+	case TLSALPN01:
+		certificate, err := tlsAlpn01Certificate(hostname, keyAuthorization)
+		if err != nil {
+			return err
+		}
+		m.mutex.Lock()
+		m.tlsAlpn01Certificates[hostname] = certificate
+		m.mutex.Unlock()
+		defer func() {
+			m.mutex.Lock()
+			delete(m.tlsAlpn01Certificates, hostname)
+			m.mutex.Unlock()
+		}()
+	default:
+		return fmt.Errorf(TRACE+" Manager authorize challengeType: unsupported \"%s\"", challengeType)
+	}
+
+	if err := m.Client.AcceptChallenge(ctx, challenge); err != nil {
+		return err
+	}
+	_, err = m.Client.WaitAuthorization(ctx, authorizationUrl)
+	return err
+}
+
+// ServeHTTP01 answers the HTTP-01 validation requests, and reports whether r was one.
+func (m *Manager) ServeHTTP01(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, HTTP01PathPrefix) {
+		return false
+	}
+	m.mutex.Lock()
+	keyAuthorization, found := m.http01Tokens[strings.TrimPrefix(r.URL.Path, HTTP01PathPrefix)]
+	m.mutex.Unlock()
+	if !found {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuthorization))
+	return true
+}
+
+// GetTLSALPN01Certificate the challenge certificate of a TLS-ALPN-01 validation hello.
+func (m *Manager) GetTLSALPN01Certificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+	if !isTLSALPN01Hello(clientHello) {
+		return nil, false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	certificate, found := m.tlsAlpn01Certificates[strings.ToLower(clientHello.ServerName)]
+	return certificate, found
+}
+
+func (m *Manager) loadCertificate(hostname string) (*x509.Certificate, error) {
+	privateKeyBytes, err := m.Store.Get(hostname + ".key")
+	if err != nil {
+		return nil, err
+	}
+	certificateChainBytes, err := m.Store.Get(hostname + ".crt")
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(privateKeyBytes, certificateChainBytes)
+}
+
+func loadOrCreateKey(store Store, storeKey string) (*ecdsa.PrivateKey, error) {
+	keyBytes, err := store.Get(storeKey)
+	if err == nil {
+		block, _ := pem.Decode(keyBytes)
+		if block == nil {
+			return nil, fmt.Errorf(TRACE + " loadOrCreateKey: invalidPem")
+		}
+		return cryptox509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	derBytes, err := cryptox509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Put(storeKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: derBytes})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
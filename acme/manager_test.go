@@ -0,0 +1,373 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	cryptox509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCA a certificate authority in the manner of Pebble: it speaks enough RFC 8555
+// for a Manager, and really validates the HTTP-01 and TLS-ALPN-01 challenges against
+// the addresses it is given.
+type testCA struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	cert   *cryptox509.Certificate
+	// http01Addr and tlsAlpn01Addr where the challenges are validated.
+	http01Addr    string
+	tlsAlpn01Addr string
+
+	mutex          sync.Mutex
+	accountKey     *ecdsa.PublicKey
+	thumbprint     string
+	nonces         int
+	orders         map[string]map[string]interface{}
+	authorizations map[string]map[string]interface{}
+	certificates   map[string][]byte
+	issued         int
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &cryptox509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              cryptox509.KeyUsageCertSign,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+	}
+	certificateBytes, err := cryptox509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := cryptox509.ParseCertificate(certificateBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{
+		t:              t,
+		key:            key,
+		cert:           cert,
+		orders:         make(map[string]map[string]interface{}),
+		authorizations: make(map[string]map[string]interface{}),
+		certificates:   make(map[string][]byte),
+	}
+	ca.server = httptest.NewServer(ca)
+	t.Cleanup(ca.server.Close)
+	return ca
+}
+
+func (ca *testCA) directoryUrl() *string {
+	directoryUrl := ca.server.URL + "/directory"
+	return &directoryUrl
+}
+
+// verify checks the JWS of a request and returns its payload.
+func (ca *testCA) verify(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	var message struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		ca.t.Errorf("%s: %s", r.URL.Path, err)
+		return nil, false
+	}
+	headerBytes, _ := base64.RawURLEncoding.DecodeString(message.Protected)
+	var header struct {
+		Alg, Nonce, Url, Kid string
+		Jwk                  *struct{ Crv, Kty, X, Y string }
+	}
+	json.Unmarshal(headerBytes, &header)
+	if header.Url != ca.server.URL+r.URL.Path || header.Nonce == "" || header.Alg != "ES256" {
+		ca.t.Errorf("%s: invalid header %s", r.URL.Path, headerBytes)
+	}
+
+	ca.mutex.Lock()
+	if header.Jwk != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(header.Jwk.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.Jwk.Y)
+		ca.accountKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		jwkBytes, _ := json.Marshal(map[string]string{"crv": header.Jwk.Crv, "kty": header.Jwk.Kty, "x": header.Jwk.X, "y": header.Jwk.Y})
+		digest := sha256.Sum256(jwkBytes)
+		ca.thumbprint = base64.RawURLEncoding.EncodeToString(digest[:])
+	} else if header.Kid != ca.server.URL+"/account/1" {
+		ca.t.Errorf("%s: invalid kid %s", r.URL.Path, header.Kid)
+	}
+	accountKey := ca.accountKey
+	ca.nonces++
+	w.Header().Set("Replay-Nonce", fmt.Sprint("nonce-", ca.nonces))
+	ca.mutex.Unlock()
+
+	signature, _ := base64.RawURLEncoding.DecodeString(message.Signature)
+	digest := sha256.Sum256([]byte(message.Protected + "." + message.Payload))
+	if accountKey == nil || len(signature) != 64 || !ecdsa.Verify(accountKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		ca.t.Errorf("%s: invalid signature", r.URL.Path)
+		return nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(message.Payload)
+	return payload, true
+}
+
+func (ca *testCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	baseUrl := ca.server.URL
+	switch r.URL.Path {
+	case "/directory":
+		json.NewEncoder(w).Encode(map[string]string{"newNonce": baseUrl + "/nonce", "newAccount": baseUrl + "/account", "newOrder": baseUrl + "/order"})
+		return
+	case "/nonce":
+		w.Header().Set("Replay-Nonce", "nonce-0")
+		return
+	}
+	payload, ok := ca.verify(w, r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	switch kind, hostname, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/"); kind {
+	case "account":
+		w.Header().Set("Location", baseUrl+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case "order":
+		var request struct {
+			Identifiers []struct{ Type, Value string }
+		}
+		json.Unmarshal(payload, &request)
+		hostname := request.Identifiers[0].Value
+		challenges := make([]map[string]string, 0)
+		for _, challengeType := range []ChallengeType{HTTP01, TLSALPN01} {
+			challenges = append(challenges, map[string]string{
+				"type": string(challengeType), "url": baseUrl + "/challenge/" + hostname + "/" + string(challengeType), "token": "token-" + hostname, "status": "pending"})
+		}
+		ca.authorizations[hostname] = map[string]interface{}{"status": "pending", "identifier": map[string]string{"type": "dns", "value": hostname}, "challenges": challenges}
+		ca.orders[hostname] = map[string]interface{}{"status": "pending", "identifiers": request.Identifiers, "authorizations": []string{baseUrl + "/authorization/" + hostname}, "finalize": baseUrl + "/finalize/" + hostname}
+		w.Header().Set("Location", baseUrl+"/orders/"+hostname)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ca.orders[hostname])
+	case "authorization":
+		json.NewEncoder(w).Encode(ca.authorizations[hostname])
+	case "orders":
+		json.NewEncoder(w).Encode(ca.orders[hostname])
+	case "challenge":
+		hostname, challengeType, _ := strings.Cut(hostname, "/")
+		keyAuthorization := "token-" + hostname + "." + ca.thumbprint
+		ca.mutex.Unlock()
+		err := ca.validate(ChallengeType(challengeType), hostname, keyAuthorization)
+		ca.mutex.Lock()
+		if err != nil {
+			ca.t.Errorf("%s %s: %s", challengeType, hostname, err)
+			ca.authorizations[hostname]["status"] = "invalid"
+		} else {
+			ca.authorizations[hostname]["status"] = "valid"
+		}
+		w.Write([]byte(`{}`))
+	case "finalize":
+		var request struct{ Csr string }
+		json.Unmarshal(payload, &request)
+		csrBytes, _ := base64.RawURLEncoding.DecodeString(request.Csr)
+		csr, err := cryptox509.ParseCertificateRequest(csrBytes)
+		if err != nil {
+			ca.t.Errorf("finalize %s: %s", hostname, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ca.issued++
+		template := &cryptox509.Certificate{
+			SerialNumber: big.NewInt(int64(ca.issued + 1)),
+			Subject:      pkix.Name{CommonName: hostname},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		certificateBytes, _ := cryptox509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+		ca.certificates[hostname] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+		ca.orders[hostname]["status"] = "valid"
+		ca.orders[hostname]["certificate"] = baseUrl + "/certificate/" + hostname
+		json.NewEncoder(w).Encode(ca.orders[hostname])
+	case "certificate":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.certificates[hostname])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (ca *testCA) validate(challengeType ChallengeType, hostname, keyAuthorization string) error {
+	if challengeType == HTTP01 {
+		request, _ := http.NewRequest(http.MethodGet, "http://"+ca.http01Addr+HTTP01PathPrefix+"token-"+hostname, nil)
+		request.Host = hostname
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		if string(body) != keyAuthorization {
+			return fmt.Errorf("key authorization %q, expected %q", body, keyAuthorization)
+		}
+		return nil
+	}
+
+	conn, err := tls.Dial("tcp", ca.tlsAlpn01Addr, &tls.Config{ServerName: hostname, NextProtos: []string{ALPNProtocol}, InsecureSkipVerify: true})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != ALPNProtocol {
+		return fmt.Errorf("negotiated %q", state.NegotiatedProtocol)
+	}
+	certificate := state.PeerCertificates[0]
+	digest := sha256.Sum256([]byte(keyAuthorization))
+	for _, extension := range certificate.Extensions {
+		var value []byte
+		if extension.Id.Equal(acmeIdentifierOid) && extension.Critical {
+			if _, err := asn1.Unmarshal(extension.Value, &value); err == nil && string(value) == string(digest[:]) && certificate.DNSNames[0] == hostname {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("acmeIdentifier missing")
+}
+
+func (ca *testCA) issuedCount() int {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	return ca.issued
+}
+
+// serveChallenges answers the challenges of m the way a connector does: HTTP-01 on
+// a plaintext listener, TLS-ALPN-01 on a TLS one that offers acme-tls/1 to
+// validations only.
+func serveChallenges(t *testing.T, ca *testCA, m *Manager) {
+	t.Helper()
+	http01Server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.ServeHTTP01(w, r) {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(http01Server.Close)
+	ca.http01Addr = http01Server.Listener.Addr().String()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetConfigForClient: func(clientHello *tls.ClientHelloInfo) (*tls.Config, error) {
+			certificate, found := m.GetTLSALPN01Certificate(clientHello)
+			if !found {
+				return nil, fmt.Errorf("not a validation")
+			}
+			return &tls.Config{Certificates: []tls.Certificate{*certificate}, NextProtos: []string{ALPNProtocol}}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}(conn)
+		}
+	}()
+	ca.tlsAlpn01Addr = listener.Addr().String()
+}
+
+func TestManagerIssuesCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	storageDir := t.TempDir()
+	config := AcmeConfig{DirectoryUrl: ca.directoryUrl(), StorageDir: &storageDir}
+	hostnames := map[string]ChallengeType{"plain.test": HTTP01, "secure.test": TLSALPN01}
+	m, err := NewManager(config, func() map[string]ChallengeType { return hostnames })
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveChallenges(t, ca, m)
+
+	m.Check(context.Background())
+	for _, status := range m.Statuses() {
+		if status.NotAfter == nil || status.LastError != "" {
+			t.Fatalf("%s not issued: %s", status.Hostname, status.LastError)
+		}
+	}
+	roots := cryptox509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for hostname := range hostnames {
+		certificate, found := m.CertificateIndex().GetExact(hostname)
+		if !found {
+			t.Fatalf("%s not published", hostname)
+		}
+		if _, err := certificate.TLSCertificate.Leaf.Verify(cryptox509.VerifyOptions{DNSName: hostname, Roots: roots}); err != nil {
+			t.Fatalf("%s: %s", hostname, err)
+		}
+	}
+
+	// The certificates are stored: another manager does not issue them again.
+	restarted, err := NewManager(config, func() map[string]ChallengeType { return hostnames })
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := ca.issuedCount()
+	restarted.Check(context.Background())
+	if ca.issuedCount() != issued || len(restarted.CertificateIndex().Certificates()) != len(hostnames) {
+		t.Fatalf("stored certificates issued again: %s", restarted.Statuses())
+	}
+
+	// Hostnames no longer managed are forgotten.
+	delete(hostnames, "plain.test")
+	m.Check(context.Background())
+	if _, found := m.CertificateIndex().GetExact("plain.test"); found || len(m.Statuses()) != 1 {
+		t.Fatalf("plain.test not forgotten: %s", m.Statuses())
+	}
+}
+
+func TestManagerRunStopsWithContext(t *testing.T) {
+	ca := newTestCA(t)
+	m, err := NewManager(AcmeConfig{DirectoryUrl: ca.directoryUrl()}, func() map[string]ChallengeType { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(stopped)
+	}()
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop")
+	}
+}
@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/util"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/audit"
+
+// Actions recorded in an Entry.
+const (
+	Create  = "create"
+	Replace = "replace"
+	Delete  = "delete"
+	// Authenticate a call rejected for its caller not being a principal.
+	Authenticate = "authenticate"
+)
+
+// Outcomes recorded in an Entry.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// RedactedValue replaces the redacted members of the documents of an Entry, as
+// multitenancy.Redacted replaces resolved secrets.
+const RedactedValue = "[REDACTED]"
+
+// Entry a management operation: who did what to which resource, the resource before
+// and after, and how it went.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Caller   string    `json:"caller"`
+	SourceIp string    `json:"sourceIp"`
+	// Resource the path of the resource, such as "tenants/a" or "connectors/main".
+	Resource string `json:"resource"`
+	// Action Create, Replace, Delete, or the operation a POST performs, such as
+	// "rollback".
+	Action  string          `json:"action"`
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+	Outcome string          `json:"outcome"`
+	Status  int             `json:"status"`
+	// Error the response of a failed operation.
+	Error string `json:"error,omitempty"`
+}
+
+func (e Entry) String() string {
+	return util.ToJson(e)
+}
+
+// Entries the result of a query, oldest first.
+type Entries []Entry
+
+func (e Entries) String() string {
+	return util.ToJson(e)
+}
+
+// Filter selects entries. Zero fields select everything.
+type Filter struct {
+	Since time.Time
+	Until time.Time
+	// Resource selects the resource and the resources below it: "tenants" selects
+	// "tenants/a", "tenants/a/state" and so on.
+	Resource string
+	// Scope when not nil, restricts the entries to these resources and the ones below
+	// them, on top of Resource.
+	Scope  []string
+	Action string
+	Caller string
+	// Limit keeps the most recent entries.
+	Limit int
+}
+
+func (f Filter) Matches(entry Entry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	if f.Resource != "" && !within(entry.Resource, f.Resource) {
+		return false
+	}
+	if f.Scope != nil {
+		inScope := false
+		for _, resource := range f.Scope {
+			inScope = inScope || within(entry.Resource, resource)
+		}
+		if !inScope {
+			return false
+		}
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Caller != "" && entry.Caller != f.Caller {
+		return false
+	}
+	return true
+}
+
+// within whether resource is parent or below it.
+func within(resource string, parent string) bool {
+	return resource == parent || strings.HasPrefix(resource, strings.TrimSuffix(parent, "/")+"/")
+}
+
+// limit the last Limit entries.
+func (f Filter) limit(entries Entries) Entries {
+	if f.Limit > 0 && len(entries) > f.Limit {
+		return entries[len(entries)-f.Limit:]
+	}
+	return entries
+}
+
+// Sink the destination of the entries. Sinks only ever append.
+type Sink interface {
+	Append(entry Entry) error
+	Close() error
+}
+
+// Querier a Sink that reads its entries back.
+type Querier interface {
+	Query(filter Filter) (Entries, error)
+}
+
+// Log appends every entry to all its sinks, and is queried through the first one that
+// is a Querier.
+type Log struct {
+	mutex sync.Mutex
+	sinks []Sink
+}
+
+func NewLog(sinks ...Sink) *Log {
+	return &Log{sinks: sinks}
+}
+
+// Append the entry to every sink, even when one of them fails.
+func (l *Log) Append(entry Entry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var failures []string
+	for _, sink := range l.sinks {
+		if err := sink.Append(entry); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf(TRACE+" Log Append: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Query the entries of the first Querier sink, false when there is none.
+func (l *Log) Query(filter Filter) (Entries, bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, sink := range l.sinks {
+		if querier, ok := sink.(Querier); ok {
+			entries, err := querier.Query(filter)
+			return entries, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var failures []string
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf(TRACE+" Log Close: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Redact the JSON of document, with the value of every member named in members
+// replaced by RedactedValue, at any depth.
+func Redact(document interface{}, members ...string) (json.RawMessage, error) {
+	documentBytes, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Redact: %s", err)
+	}
+	var node interface{}
+	if err := json.Unmarshal(documentBytes, &node); err != nil {
+		return nil, fmt.Errorf(TRACE+" Redact: %s", err)
+	}
+	redacted := make(map[string]bool, len(members))
+	for _, member := range members {
+		redacted[member] = true
+	}
+	redactedBytes, err := json.Marshal(redactNode(node, redacted))
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Redact: %s", err)
+	}
+	return redactedBytes, nil
+}
+
+func redactNode(node interface{}, redacted map[string]bool) interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for k, member := range value {
+			if redacted[k] {
+				value[k] = RedactedValue
+			} else {
+				value[k] = redactNode(member, redacted)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactNode(item, redacted)
+		}
+	}
+	return node
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLineLen the longest JSON line JSONLinesSink reads back.
+const maxLineLen = 16 << 20
+
+// appendFile an append-only file, synced after every write.
+type appendFile struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func openAppendFile(path string) (*appendFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &appendFile{path: path, file: file}, nil
+}
+
+func (f *appendFile) appendLine(line []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+func (f *appendFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+// FileSink appends one human readable line per entry to a log file:
+//
+//	2006-01-02T15:04:05Z replace tenants/a caller="admin" sourceIp="10.0.0.1" outcome=success status=200 before={...} after={...}
+type FileSink struct {
+	*appendFile
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := openAppendFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" NewFileSink: %s", err)
+	}
+	return &FileSink{file}, nil
+}
+
+func (s *FileSink) Append(entry Entry) error {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s %s %s caller=%s sourceIp=%s outcome=%s status=%d",
+		entry.Time.UTC().Format(time.RFC3339Nano), entry.Action, entry.Resource,
+		strconv.Quote(entry.Caller), strconv.Quote(entry.SourceIp), entry.Outcome, entry.Status)
+	if entry.Error != "" {
+		fmt.Fprintf(&line, " error=%s", strconv.Quote(entry.Error))
+	}
+	if entry.Before != nil {
+		fmt.Fprintf(&line, " before=%s", entry.Before)
+	}
+	if entry.After != nil {
+		fmt.Fprintf(&line, " after=%s", entry.After)
+	}
+	if err := s.appendLine([]byte(line.String())); err != nil {
+		return fmt.Errorf(TRACE+" FileSink Append \"%s\": %s", s.path, err)
+	}
+	return nil
+}
+
+// JSONLinesSink appends the JSON of each entry as a line of a file, and reads the
+// file back to answer queries.
+type JSONLinesSink struct {
+	*appendFile
+}
+
+func NewJSONLinesSink(path string) (*JSONLinesSink, error) {
+	file, err := openAppendFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" NewJSONLinesSink: %s", err)
+	}
+	return &JSONLinesSink{file}, nil
+}
+
+func (s *JSONLinesSink) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf(TRACE+" JSONLinesSink Append: %s", err)
+	}
+	if err := s.appendLine(line); err != nil {
+		return fmt.Errorf(TRACE+" JSONLinesSink Append \"%s\": %s", s.path, err)
+	}
+	return nil
+}
+
+// Query scans the whole file. A line that does not decode, such as one torn by a
+// crash, is skipped.
+func (s *JSONLinesSink) Query(filter Filter) (Entries, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" JSONLinesSink Query: %s", err)
+	}
+	defer file.Close()
+
+	entries := make(Entries, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLen)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf(TRACE+" JSONLinesSink Query \"%s\": %s", s.path, err)
+	}
+	return filter.limit(entries), nil
+}
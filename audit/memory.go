@@ -0,0 +1,42 @@
+package audit
+
+import "sync"
+
+// MemorySink keeps the last Max entries in memory, for querying a log whose other
+// sinks are not queryable.
+type MemorySink struct {
+	Max int
+
+	mutex   sync.Mutex
+	entries Entries
+}
+
+func NewMemorySink(max int) *MemorySink {
+	return &MemorySink{Max: max}
+}
+
+func (s *MemorySink) Append(entry Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > s.Max {
+		s.entries = append(Entries(nil), s.entries[len(s.entries)-s.Max:]...)
+	}
+	return nil
+}
+
+func (s *MemorySink) Query(filter Filter) (Entries, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries := make(Entries, 0)
+	for _, entry := range s.entries {
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return filter.limit(entries), nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
@@ -0,0 +1,326 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/bundle"
+
+// Format identifies the documents that are tenant bundles, and their version.
+const Format = "godynamicweb.tenantBundle/v1"
+
+// MaxCertificates the most certificates a bundle carries, as opening each one derives
+// a key from the passphrase.
+const MaxCertificates = 16
+
+// Bundle a tenant as a self-contained document, to be imported into another
+// instance: its config, its certificates with their private keys encrypted, and
+// optionally the content of its file servers.
+type Bundle struct {
+	Format   string   `json:"format"`
+	Metadata Metadata `json:"metadata"`
+	// Tenant the config of the tenant, without its certificates.
+	Tenant       multitenancy.TenantConfig `json:"tenant"`
+	Certificates []Certificate             `json:"certificates,omitempty"`
+	Files        []FileServerFiles         `json:"files,omitempty"`
+}
+
+func (b Bundle) String() string {
+	return util.ToJson(b)
+}
+
+func (b Bundle) Validate() error {
+	return validation.Validate(b)
+}
+
+func (b Bundle) ValidateWith(v *validation.Validator) {
+	if b.Format != Format {
+		v.Field("format").Failf("mustBeSupportedFormat", "%q", Format)
+		return
+	}
+	v.Field("metadata").Check(b.Metadata)
+	if len(b.Certificates) > MaxCertificates {
+		v.Field("certificates").Failf("mustNotExceed", "%d certificates", MaxCertificates)
+		return
+	}
+	for i, certificate := range b.Certificates {
+		v.Field("certificates").Index(i).Check(certificate)
+	}
+	fileServerEndpoints := 0
+	if b.Tenant.FileServerEndpoints != nil {
+		fileServerEndpoints = len(*b.Tenant.FileServerEndpoints)
+	}
+	for i, files := range b.Files {
+		filesValidator := v.Field("files").Index(i)
+		filesValidator.Check(files)
+		if files.Endpoint >= fileServerEndpoints {
+			filesValidator.Field("endpoint").Fail("mustBeFileServerEndpointOfTenant")
+		}
+	}
+}
+
+// Metadata where a bundle comes from, and the names an import may have to remap.
+type Metadata struct {
+	TenantID   string    `json:"tenantId"`
+	ExportedAt time.Time `json:"exportedAt"`
+	// Hostnames the hosts of the endpoint URLs of the tenant.
+	Hostnames []string `json:"hostnames"`
+	// Connectors the connectors the endpoints of the tenant are served on.
+	Connectors []string `json:"connectors"`
+}
+
+func (m Metadata) Validate() error {
+	return validation.Validate(m)
+}
+
+func (m Metadata) ValidateWith(v *validation.Validator) {
+	v.Required("tenantId", m.TenantID != "")
+}
+
+// Certificate a certificate chain, with its private key encrypted.
+type Certificate struct {
+	Cert []byte       `json:"cert"`
+	Key  EncryptedKey `json:"key"`
+}
+
+func (c Certificate) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c Certificate) ValidateWith(v *validation.Validator) {
+	v.Required("cert", len(c.Cert) > 0)
+	v.Field("key").Check(c.Key)
+}
+
+// Export the bundle of a tenant. The private keys of its certificates are encrypted
+// with passphrase, which is required when there are any. With withFiles, the content
+// of the roots of its file servers is included.
+func Export(tenantID string, config multitenancy.TenantConfig, passphrase string, withFiles bool) (Bundle, error) {
+	b := Bundle{
+		Format: Format,
+		Metadata: Metadata{
+			TenantID:   tenantID,
+			ExportedAt: time.Now().UTC(),
+		},
+	}
+	if err := copyConfig(config, &b.Tenant); err != nil {
+		return Bundle{}, err
+	}
+	b.Tenant.X509 = nil
+	if len(config.X509) > 0 && passphrase == "" {
+		return Bundle{}, fmt.Errorf(TRACE+" Export \"%s\" passphrase: requiredToEncryptPrivateKeys", tenantID)
+	}
+	if len(config.X509) > MaxCertificates {
+		return Bundle{}, fmt.Errorf(TRACE+" Export \"%s\" x509: mustNotExceed %d certificates", tenantID, MaxCertificates)
+	}
+	for _, x509Config := range config.X509 {
+		key, err := encryptKey(passphrase, x509Config.PKey)
+		if err != nil {
+			return Bundle{}, fmt.Errorf(TRACE+" Export \"%s\" x509: %s", tenantID, err)
+		}
+		b.Certificates = append(b.Certificates, Certificate{Cert: x509Config.Cert, Key: key})
+	}
+
+	hostnames := make(map[string]bool)
+	connectors := make(map[string]bool)
+	forEachEndpoint(&b.Tenant, func(endpointUrl *multitenancy.EndpointUrl, connector *string) {
+		if endpointUrl != nil {
+			if u, err := url.Parse(string(*endpointUrl)); err == nil {
+				hostnames[u.Hostname()] = true
+			}
+		}
+		if connector != nil {
+			connectors[*connector] = true
+		}
+	})
+	b.Metadata.Hostnames = sortedNames(hostnames)
+	b.Metadata.Connectors = sortedNames(connectors)
+
+	if withFiles && config.FileServerEndpoints != nil {
+		budget := int64(MaxFilesBytes)
+		for i, fileServer := range *config.FileServerEndpoints {
+			if fileServer.RootFs == nil {
+				continue
+			}
+			files, err := readFiles(string(*fileServer.RootFs), &budget)
+			if err != nil {
+				return Bundle{}, fmt.Errorf(TRACE+" Export \"%s\" fileServerEndpoints %d: %s", tenantID, i, err)
+			}
+			b.Files = append(b.Files, FileServerFiles{Endpoint: i, Files: files})
+		}
+	}
+	return b, nil
+}
+
+// Open the config of the tenant of the bundle, with the private keys of its
+// certificates decrypted with passphrase.
+func (b Bundle) Open(passphrase string) (multitenancy.TenantConfig, error) {
+	if err := b.Validate(); err != nil {
+		return multitenancy.TenantConfig{}, err
+	}
+	var config multitenancy.TenantConfig
+	if err := copyConfig(b.Tenant, &config); err != nil {
+		return multitenancy.TenantConfig{}, err
+	}
+	v := validation.NewValidator()
+	for i, certificate := range b.Certificates {
+		pkey, err := certificate.Key.decrypt(passphrase)
+		if err != nil {
+			v.Field("certificates").Index(i).Field("key").Add(err)
+			continue
+		}
+		config.X509 = append(config.X509, x509.X509Config{PKey: pkey, Cert: certificate.Cert})
+	}
+	if err := v.Err(); err != nil {
+		return multitenancy.TenantConfig{}, err
+	}
+	return config, nil
+}
+
+// Remap the hostnames and the connectors of the endpoints of config, by their names
+// in the bundle. The port of an endpoint URL is kept.
+func (b Bundle) Remap(config *multitenancy.TenantConfig, hostnames map[string]string, connectors map[string]string) error {
+	v := validation.NewValidator()
+	checkNames(v.Field("hostnames"), hostnames, b.Metadata.Hostnames, "mustBeHostnameOfBundle")
+	checkNames(v.Field("connectors"), connectors, b.Metadata.Connectors, "mustBeConnectorOfBundle")
+	if err := v.Err(); err != nil {
+		return err
+	}
+
+	forEachEndpoint(config, func(endpointUrl *multitenancy.EndpointUrl, connector *string) {
+		if endpointUrl != nil {
+			*endpointUrl = remapHostname(*endpointUrl, hostnames)
+		}
+		if connector != nil {
+			if remapped, found := connectors[*connector]; found {
+				*connector = remapped
+			}
+		}
+	})
+	return nil
+}
+
+func checkNames(v *validation.Validator, remapped map[string]string, names []string, code string) {
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, name := range validation.SortedKeys(remapped) {
+		if !known[name] {
+			v.Field(name).Fail(code)
+		} else if remapped[name] == "" {
+			v.Field(name).Fail("mustNotBeEmpty")
+		}
+	}
+}
+
+func remapHostname(endpointUrl multitenancy.EndpointUrl, hostnames map[string]string) multitenancy.EndpointUrl {
+	u, err := url.Parse(string(endpointUrl))
+	if err != nil {
+		return endpointUrl
+	}
+	hostname, found := hostnames[u.Hostname()]
+	if !found {
+		return endpointUrl
+	}
+	if port := u.Port(); port != "" {
+		hostname = net.JoinHostPort(hostname, port)
+	}
+	// The URL is edited in place rather than rebuilt, so its path is kept as written.
+	raw := string(endpointUrl)
+	hostIndex := strings.Index(raw, "//"+u.Host) + len("//")
+	return multitenancy.EndpointUrl(raw[:hostIndex] + hostname + raw[hostIndex+len(u.Host):])
+}
+
+// forEachEndpoint calls fn with the URL and the connector of every endpoint of
+// config, to be read or changed in place.
+func forEachEndpoint(config *multitenancy.TenantConfig, fn func(endpointUrl *multitenancy.EndpointUrl, connector *string)) {
+	if config.ServerEndpoints != nil {
+		for _, serverEndpoint := range *config.ServerEndpoints {
+			fn(serverEndpoint.Url, serverEndpoint.Connector)
+		}
+	}
+	if config.ReverseProxyEndpoints != nil {
+		for _, reverseProxy := range *config.ReverseProxyEndpoints {
+			fn(reverseProxy.Url, reverseProxy.Connector)
+		}
+	}
+	if config.FileServerEndpoints != nil {
+		for _, fileServer := range *config.FileServerEndpoints {
+			fn(fileServer.Url, fileServer.Connector)
+		}
+	}
+	if config.RedirectEndpoints != nil {
+		for _, redirect := range *config.RedirectEndpoints {
+			fn(redirect.Url, redirect.Connector)
+		}
+	}
+}
+
+// copyConfig a deep copy, so that remapping never changes a config that is live.
+func copyConfig(config multitenancy.TenantConfig, copied *multitenancy.TenantConfig) error {
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf(TRACE+" copyConfig: %s", err)
+	}
+	if err := json.Unmarshal(configBytes, copied); err != nil {
+		return fmt.Errorf(TRACE+" copyConfig: %s", err)
+	}
+	return nil
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// ImportRequest a bundle to import, and how.
+type ImportRequest struct {
+	Bundle     *Bundle `json:"bundle"`
+	Passphrase string  `json:"passphrase,omitempty"`
+	// TenantID the tenant to create, the one the bundle was exported from by default.
+	TenantID *string `json:"tenantId,omitempty"`
+	// Hostnames the hostnames of the endpoints to replace, by their names in the bundle.
+	Hostnames map[string]string `json:"hostnames,omitempty"`
+	// Connectors the connectors of the endpoints to replace, by their names in the
+	// bundle.
+	Connectors map[string]string `json:"connectors,omitempty"`
+	// Replace allows replacing a tenant that already exists.
+	Replace bool `json:"replace,omitempty"`
+}
+
+func (r ImportRequest) Validate() error {
+	return validation.Validate(r)
+}
+
+func (r ImportRequest) ValidateWith(v *validation.Validator) {
+	if v.Required("bundle", r.Bundle != nil) {
+		v.Field("bundle").Check(r.Bundle)
+	}
+	if r.TenantID != nil && *r.TenantID == "" {
+		v.Field("tenantId").Fail("mustNotBeEmpty")
+	}
+}
+
+// ImportedTenantID the tenant the request imports.
+func (r ImportRequest) ImportedTenantID() string {
+	if r.TenantID != nil {
+		return *r.TenantID
+	}
+	return r.Bundle.Metadata.TenantID
+}
@@ -0,0 +1,38 @@
+package bundle
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundleLimits(t *testing.T) {
+	key := EncryptedKey{
+		Kdf:        Pbkdf2Sha256,
+		Iterations: DefaultIterations,
+		Salt:       make([]byte, saltLen),
+		Cipher:     Aes256Gcm,
+		Nonce:      []byte{1},
+		Ciphertext: []byte{1},
+	}
+	if err := key.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	key.Iterations = maxIterations + 1
+	if err := key.Validate(); err == nil || !strings.Contains(err.Error(), "iterations") {
+		t.Fatal(err)
+	}
+
+	b := Bundle{Format: Format, Metadata: Metadata{TenantID: "a", ExportedAt: time.Now()}}
+	key.Iterations = DefaultIterations
+	for i := 0; i < MaxCertificates; i++ {
+		b.Certificates = append(b.Certificates, Certificate{Cert: []byte("cert"), Key: key})
+	}
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b.Certificates = append(b.Certificates, Certificate{Cert: []byte("cert"), Key: key})
+	if err := b.Validate(); err == nil || !strings.Contains(err.Error(), "certificates") {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+package bundle
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+const (
+	// Pbkdf2Sha256 derives the key of an EncryptedKey from the passphrase.
+	Pbkdf2Sha256 = "pbkdf2-sha256"
+	// Aes256Gcm encrypts an EncryptedKey.
+	Aes256Gcm = "aes-256-gcm"
+
+	// DefaultIterations the PBKDF2 iterations of the keys Export encrypts.
+	DefaultIterations = 600000
+	// minIterations and maxIterations bound the iterations of a bundle, so that an
+	// imported bundle can neither weaken the derivation nor stall the server.
+	minIterations = 10000
+	maxIterations = 1000000
+
+	saltLen = 16
+	keyLen  = 32
+)
+
+// EncryptedKey a private key encrypted with a key derived from a passphrase.
+type EncryptedKey struct {
+	Kdf        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (k EncryptedKey) Validate() error {
+	return validation.Validate(k)
+}
+
+func (k EncryptedKey) ValidateWith(v *validation.Validator) {
+	if k.Kdf != Pbkdf2Sha256 {
+		v.Field("kdf").Failf("mustBeSupportedKdf", "%q", k.Kdf)
+	}
+	if k.Iterations < minIterations || k.Iterations > maxIterations {
+		v.Field("iterations").Failf("mustBeInRange", "%d..%d", minIterations, maxIterations)
+	}
+	if len(k.Salt) < saltLen {
+		v.Field("salt").Failf("mustBeLongEnough", "%d bytes", saltLen)
+	}
+	if k.Cipher != Aes256Gcm {
+		v.Field("cipher").Failf("mustBeSupportedCipher", "%q", k.Cipher)
+	}
+	v.Required("nonce", len(k.Nonce) > 0)
+	v.Required("ciphertext", len(k.Ciphertext) > 0)
+}
+
+func encryptKey(passphrase string, plaintext []byte) (EncryptedKey, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return EncryptedKey{}, err
+	}
+	aead, err := newAead(passphrase, salt, DefaultIterations)
+	if err != nil {
+		return EncryptedKey{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return EncryptedKey{}, err
+	}
+	return EncryptedKey{
+		Kdf:        Pbkdf2Sha256,
+		Iterations: DefaultIterations,
+		Salt:       salt,
+		Cipher:     Aes256Gcm,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	}, nil
+}
+
+func (k EncryptedKey) decrypt(passphrase string) ([]byte, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	aead, err := newAead(passphrase, k.Salt, k.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Nonce) != aead.NonceSize() {
+		return nil, validation.Invalidf("mustBeNonceOfCipher", "%d bytes", aead.NonceSize())
+	}
+	plaintext, err := aead.Open(nil, k.Nonce, k.Ciphertext, nil)
+	if err != nil {
+		return nil, validation.Invalid("passphraseMustMatch")
+	}
+	return plaintext, nil
+}
+
+func newAead(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2Sha256([]byte(passphrase), salt, iterations, keyLen))
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" newAead: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2Sha256 the PBKDF2 of RFC 8018 with HMAC-SHA-256 as its pseudorandom function.
+func pbkdf2Sha256(password, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (length + prf.Size() - 1) / prf.Size()
+	derived := make([]byte, 0, blocks*prf.Size())
+	blockIndex := make([]byte, 4)
+	u := make([]byte, 0, prf.Size())
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(blockIndex, uint32(block))
+		prf.Write(blockIndex)
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		derived = append(derived, t...)
+	}
+	return derived[:length]
+}
@@ -0,0 +1,91 @@
+package bundle
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// MaxFilesBytes the most file-server content a bundle carries.
+const MaxFilesBytes = 64 << 20
+
+// FileServerFiles the content of the root of a file-server endpoint.
+type FileServerFiles struct {
+	// Endpoint the index of the endpoint in fileServerEndpoints.
+	Endpoint int `json:"endpoint"`
+	// Files the regular files below the root, by slash-separated relative path.
+	Files map[string][]byte `json:"files"`
+}
+
+func (f FileServerFiles) Validate() error {
+	return validation.Validate(f)
+}
+
+func (f FileServerFiles) ValidateWith(v *validation.Validator) {
+	if f.Endpoint < 0 {
+		v.Field("endpoint").Fail("mustNotBeNegative")
+	}
+	for _, name := range validation.SortedKeys(f.Files) {
+		if !filepath.IsLocal(filepath.FromSlash(name)) || path.Clean(name) != name {
+			v.Field("files").Field(name).Fail("mustBeLocalPath")
+		}
+	}
+}
+
+// readFiles the regular files below root. Symbolic links are not followed.
+func readFiles(root string, budget *int64) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if *budget -= info.Size(); *budget < 0 {
+			return fmt.Errorf("mustNotExceed %d bytes", MaxFilesBytes)
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relativePath)] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" readFiles \"%s\": %s", root, err)
+	}
+	return files, nil
+}
+
+// WriteFiles writes the files below dir, creating it.
+func (f FileServerFiles) WriteFiles(dir string) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf(TRACE+" FileServerFiles WriteFiles: %s", err)
+	}
+	for _, name := range validation.SortedKeys(f.Files) {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf(TRACE+" FileServerFiles WriteFiles: %s", err)
+		}
+		if err := os.WriteFile(filePath, f.Files[name], 0644); err != nil {
+			return fmt.Errorf(TRACE+" FileServerFiles WriteFiles: %s", err)
+		}
+	}
+	return nil
+}
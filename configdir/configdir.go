@@ -0,0 +1,297 @@
+package configdir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/format"
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/util"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/configdir"
+
+const (
+	ConnectorsDir = "connectors"
+	TenantsDir    = "tenants"
+	// SettingsFile the name, without extension, of the file of the settings.
+	SettingsFile = "settings"
+)
+
+// FileError a file that could not be loaded. It is skipped, and whatever it declared
+// is left as it is.
+type FileError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Snapshot the configuration declared by a directory that holds one file per
+// connector in its "connectors" directory, one per tenant in its "tenants"
+// directory, each named after the connector or tenant, and optionally a "settings"
+// file. Files are JSON, YAML or TOML according to their extension.
+type Snapshot struct {
+	Dir          string                          `json:"dir"`
+	LoadedAt     time.Time                       `json:"loadedAt"`
+	Server       server.ServerConfig             `json:"-"`
+	MultiTenancy multitenancy.MultiTenancyConfig `json:"-"`
+	// InvalidConnectors and InvalidTenants the names of the invalid files.
+	InvalidConnectors map[string]bool `json:"-"`
+	InvalidTenants    map[string]bool `json:"-"`
+	// Settings the settings file converted to JSON, nil when there is none. It is
+	// left to the caller to decode, as the settings are not known to this package.
+	Settings     []byte      `json:"-"`
+	SettingsPath string      `json:"-"`
+	Errors       []FileError `json:"errors"`
+
+	paths map[string]string
+}
+
+func (s Snapshot) String() string {
+	return util.ToJson(s)
+}
+
+// ConnectorPath the file of a connector, or the path of a new one.
+func (s *Snapshot) ConnectorPath(connectorName string) string {
+	if path, found := s.paths[ConnectorsDir+"/"+connectorName]; found {
+		return path
+	}
+	return filepath.Join(s.Dir, ConnectorsDir, connectorName+".json")
+}
+
+// TenantPath the file of a tenant, or the path of a new one.
+func (s *Snapshot) TenantPath(tenantID string) string {
+	if path, found := s.paths[TenantsDir+"/"+tenantID]; found {
+		return path
+	}
+	return filepath.Join(s.Dir, TenantsDir, tenantID+".json")
+}
+
+func Load(dir string) (*Snapshot, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Load: %s", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf(TRACE+" Load \"%s\": mustBeDirectory", dir)
+	}
+
+	snapshot := &Snapshot{
+		Dir:               dir,
+		LoadedAt:          time.Now(),
+		Server:            *server.NewServerConfig(),
+		MultiTenancy:      multitenancy.MultiTenancyConfig{Tenants: make(multitenancy.TenantsConfig)},
+		InvalidConnectors: make(map[string]bool),
+		InvalidTenants:    make(map[string]bool),
+		Errors:            make([]FileError, 0),
+		paths:             make(map[string]string),
+	}
+
+	connectorFiles, err := listFiles(filepath.Join(dir, ConnectorsDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, connectorName := range sortedKeys(connectorFiles) {
+		path, err := snapshot.uniquePath(ConnectorsDir, connectorName, connectorFiles[connectorName])
+		var connectorConfig server.ConnectorConfig
+		if err == nil {
+			err = loadFile(path, &connectorConfig)
+		}
+		if err != nil {
+			snapshot.addError(path, err)
+			snapshot.InvalidConnectors[connectorName] = true
+			continue
+		}
+		(*snapshot.Server.Connectors)[connectorName] = connectorConfig
+	}
+
+	tenantFiles, err := listFiles(filepath.Join(dir, TenantsDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, tenantID := range sortedKeys(tenantFiles) {
+		path, err := snapshot.uniquePath(TenantsDir, tenantID, tenantFiles[tenantID])
+		var tenantConfig multitenancy.TenantConfig
+		if err == nil {
+			err = loadFile(path, &tenantConfig)
+		}
+		if err != nil {
+			snapshot.addError(path, err)
+			snapshot.InvalidTenants[tenantID] = true
+			continue
+		}
+		snapshot.MultiTenancy.Tenants[tenantID] = tenantConfig
+	}
+
+	rootFiles, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if settingsFiles, found := rootFiles[SettingsFile]; found {
+		path, err := snapshot.uniquePath("", SettingsFile, settingsFiles)
+		if err == nil {
+			snapshot.Settings, err = loadJSON(path)
+		}
+		snapshot.SettingsPath = path
+		if err != nil {
+			snapshot.addError(path, err)
+		}
+	}
+	return snapshot, nil
+}
+
+// uniquePath the file that declares a name, that must not be declared by two files
+// in different formats.
+func (s *Snapshot) uniquePath(subDir, name string, paths []string) (string, error) {
+	s.paths[subDir+"/"+name] = paths[0]
+	if len(paths) > 1 {
+		return paths[0], fmt.Errorf(TRACE+" Load %s \"%s\": mustBeDeclaredOnce %q", subDir, name, paths)
+	}
+	return paths[0], nil
+}
+
+func (s *Snapshot) addError(path string, err error) {
+	s.Errors = append(s.Errors, FileError{Path: path, Error: err.Error()})
+}
+
+type validator interface {
+	Validate() error
+}
+
+// loadJSON a file of any format, converted to JSON.
+func loadJSON(path string) ([]byte, error) {
+	fileBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, _ := format.FromPath(path)
+	return format.ToJSON(f, fileBytes)
+}
+
+func loadFile(path string, config validator) error {
+	fileBytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, _ := format.FromPath(path)
+	if err := format.DecodeStrict(f, fileBytes, config); err != nil {
+		return err
+	}
+	return config.Validate()
+}
+
+// listFiles the JSON, YAML and TOML files of dir, by name without extension. Hidden
+// files, such as the temporary files of editors, are ignored. A missing dir has no
+// files.
+func listFiles(dir string) (map[string][]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" Load: %s", err)
+	}
+	files := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if _, supported := format.FromPath(fileName); entry.IsDir() || strings.HasPrefix(fileName, ".") || !supported {
+			continue
+		}
+		name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		files[name] = append(files[name], filepath.Join(dir, fileName))
+	}
+	return files, nil
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Watcher polls a directory and loads it again whenever one of its files is added,
+// removed or modified.
+type Watcher struct {
+	Dir      string
+	Interval time.Duration
+	OnChange func(*Snapshot)
+	// OnError when set, is told why the directory could not be loaded.
+	OnError func(error)
+
+	fingerprint string
+}
+
+func NewWatcher(dir string, interval time.Duration, onChange func(*Snapshot)) *Watcher {
+	return &Watcher{
+		Dir:      dir,
+		Interval: interval,
+		OnChange: onChange,
+	}
+}
+
+// Run polls the directory until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		w.Poll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll calls OnChange if the directory changed since the previous Poll.
+func (w *Watcher) Poll() {
+	fingerprint, err := dirFingerprint(w.Dir)
+	if err == nil && fingerprint == w.fingerprint {
+		return
+	}
+	var snapshot *Snapshot
+	if err == nil {
+		snapshot, err = Load(w.Dir)
+	}
+	if err != nil {
+		if w.OnError != nil {
+			w.OnError(err)
+		}
+		return
+	}
+	w.fingerprint = fingerprint
+	w.OnChange(snapshot)
+}
+
+// dirFingerprint the names, sizes and modification times of the files.
+func dirFingerprint(dir string) (string, error) {
+	var fingerprint strings.Builder
+	for _, subDir := range []string{ConnectorsDir, TenantsDir, ""} {
+		files, err := listFiles(filepath.Join(dir, subDir))
+		if err != nil {
+			return "", err
+		}
+		if subDir == "" {
+			files = map[string][]string{SettingsFile: files[SettingsFile]}
+		}
+		for _, name := range sortedKeys(files) {
+			for _, path := range files[name] {
+				info, err := os.Stat(path)
+				if err != nil {
+					return "", err
+				}
+				fmt.Fprintf(&fingerprint, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+			}
+		}
+	}
+	return fingerprint.String(), nil
+}
@@ -0,0 +1,94 @@
+package configstore
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/configstore"
+
+// Kind a family of documents, such as the connectors or the tenants.
+type Kind string
+
+const (
+	Connectors Kind = "connectors"
+	Tenants    Kind = "tenants"
+	Templates  Kind = "templates"
+	// X509 the global certificates, not the ones of the tenants.
+	X509 Kind = "x509"
+)
+
+// Change puts a JSON document under a name, or deletes it when Document is nil.
+type Change struct {
+	Kind     Kind            `json:"kind"`
+	Name     string          `json:"name"`
+	Document json.RawMessage `json:"document,omitempty"`
+}
+
+func Put(kind Kind, name string, document interface{}) (Change, error) {
+	documentBytes, err := json.Marshal(document)
+	if err != nil {
+		return Change{}, fmt.Errorf(TRACE+" Put %s \"%s\": %s", kind, name, err)
+	}
+	return Change{Kind: kind, Name: name, Document: documentBytes}, nil
+}
+
+func Delete(kind Kind, name string) Change {
+	return Change{Kind: kind, Name: name}
+}
+
+// ConfigStore keeps the configuration created at runtime across restarts.
+type ConfigStore interface {
+	// Load every document of the kind, by name.
+	Load(kind Kind) (map[string]json.RawMessage, error)
+	// Apply makes all the changes durable, or none of them.
+	Apply(changes ...Change) error
+	Close() error
+}
+
+// documents the content of a store, by kind and name.
+type documents map[Kind]map[string]json.RawMessage
+
+func (d documents) apply(changes []Change) {
+	for _, change := range changes {
+		if change.Document == nil {
+			delete(d[change.Kind], change.Name)
+			continue
+		}
+		if d[change.Kind] == nil {
+			d[change.Kind] = make(map[string]json.RawMessage)
+		}
+		d[change.Kind][change.Name] = change.Document
+	}
+}
+
+func (d documents) load(kind Kind) map[string]json.RawMessage {
+	result := make(map[string]json.RawMessage, len(d[kind]))
+	for name, document := range d[kind] {
+		result[name] = document
+	}
+	return result
+}
+
+func (d documents) len() int {
+	count := 0
+	for _, byName := range d {
+		count += len(byName)
+	}
+	return count
+}
+
+func validateChanges(changes []Change) error {
+	for _, change := range changes {
+		if change.Kind == "" {
+			return fmt.Errorf(TRACE + " Change Kind: required")
+		}
+		if change.Name == "" {
+			return fmt.Errorf(TRACE+" Change %s Name: required", change.Kind)
+		}
+		if change.Document != nil && !json.Valid(change.Document) {
+			return fmt.Errorf(TRACE+" Change %s \"%s\" Document: mustBeJson", change.Kind, change.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package configstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileConfigStore keeps every document in a single JSON file, rewritten as a whole
+// on each change: the new content is written to a temporary file that is renamed
+// over the previous one, so the file is never seen half written.
+type FileConfigStore struct {
+	Path string
+
+	mutex     sync.Mutex
+	documents documents
+}
+
+func NewFileConfigStore(path string) (*FileConfigStore, error) {
+	s := &FileConfigStore{
+		Path:      path,
+		documents: make(documents),
+	}
+	fileBytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" NewFileConfigStore: %s", err)
+	}
+	if err := json.Unmarshal(fileBytes, &s.documents); err != nil {
+		return nil, fmt.Errorf(TRACE+" NewFileConfigStore \"%s\": %s", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileConfigStore) Load(kind Kind) (map[string]json.RawMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.documents.load(kind), nil
+}
+
+func (s *FileConfigStore) Apply(changes ...Change) error {
+	if err := validateChanges(changes); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	updated := make(documents, len(s.documents))
+	for kind := range s.documents {
+		updated[kind] = s.documents.load(kind)
+	}
+	updated.apply(changes)
+
+	fileBytes, err := json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(s.Path, fileBytes); err != nil {
+		return fmt.Errorf(TRACE+" FileConfigStore Apply: %s", err)
+	}
+	s.documents = updated
+	return nil
+}
+
+func (s *FileConfigStore) Close() error {
+	return nil
+}
+
+// writeFileAtomically writes a temporary file next to path, syncs it and renames it
+// over path, then syncs the directory so the rename survives a crash.
+func writeFileAtomically(path string, data []byte) error {
+	file, err := createFileAtomically(path, data)
+	if file != nil {
+		file.Close()
+	}
+	return err
+}
+
+// createFileAtomically is writeFileAtomically, that returns the file still open, at
+// its end. The file is returned once renamed, even if the directory sync fails.
+func createFileAtomically(path string, data []byte) (*os.File, error) {
+	dir := filepath.Dir(path)
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if err := file.Chmod(0600); err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(data); err != nil {
+		return nil, err
+	}
+	if err := file.Sync(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return nil, err
+	}
+	renamed = true
+	return file, syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Not every platform can sync a directory; the rename is done either way.
+	d.Sync()
+	return nil
+}
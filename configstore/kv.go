@@ -0,0 +1,220 @@
+package configstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	recordHeaderLen = 8
+	maxRecordLen    = 64 << 20
+	// compactionRatio compacts the log when it holds this many times more changes than
+	// there are documents.
+	compactionRatio = 4
+	minCompaction   = 64
+)
+
+// KeyValueConfigStore an embedded key-value store: changes are appended to a log
+// file, one record per Apply, and replayed when the store is opened. A record is its
+// length, its CRC-32 and the JSON of its changes, so a record torn by a crash is
+// detected and dropped. The log is compacted into a single record once it mostly
+// holds overwritten changes.
+type KeyValueConfigStore struct {
+	Path string
+
+	mutex      sync.Mutex
+	file       logFile
+	documents  documents
+	logChanges int
+	// failed why the log could not be rewound after a failed Apply, which leaves
+	// it with a torn record that later records must not follow.
+	failed error
+}
+
+// logFile the file of the log, an *os.File.
+type logFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+func NewKeyValueConfigStore(path string) (*KeyValueConfigStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" NewKeyValueConfigStore: %s", err)
+	}
+	s := &KeyValueConfigStore{
+		Path:      path,
+		file:      file,
+		documents: make(documents),
+	}
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf(TRACE+" NewKeyValueConfigStore \"%s\": %s", path, err)
+	}
+	return s, nil
+}
+
+// replay applies every complete record, and truncates the log after the last one.
+func (s *KeyValueConfigStore) replay() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	for {
+		changes, recordLen, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println(TRACE+" KeyValueConfigStore replay: dropping log tail at", offset, err)
+			break
+		}
+		s.documents.apply(changes)
+		s.logChanges += len(changes)
+		offset += recordLen
+	}
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func readRecord(reader io.Reader) ([]Change, int64, error) {
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.New("tornRecordHeader")
+		}
+		return nil, 0, err
+	}
+	payloadLen := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if payloadLen > maxRecordLen {
+		return nil, 0, errors.New("recordTooLong")
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, 0, errors.New("tornRecord")
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, errors.New("recordChecksumMismatch")
+	}
+	var changes []Change
+	if err := json.Unmarshal(payload, &changes); err != nil {
+		return nil, 0, err
+	}
+	return changes, int64(recordHeaderLen + payloadLen), nil
+}
+
+func encodeRecord(changes []Change) ([]byte, error) {
+	payload, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, recordHeaderLen, recordHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...), nil
+}
+
+func (s *KeyValueConfigStore) Load(kind Kind) (map[string]json.RawMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.documents.load(kind), nil
+}
+
+func (s *KeyValueConfigStore) Apply(changes ...Change) error {
+	if err := validateChanges(changes); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return fmt.Errorf(TRACE + " KeyValueConfigStore Apply: closed")
+	}
+	if s.failed != nil {
+		return fmt.Errorf(TRACE+" KeyValueConfigStore Apply: failed %s", s.failed)
+	}
+
+	record, err := encodeRecord(changes)
+	if err != nil {
+		return err
+	}
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf(TRACE+" KeyValueConfigStore Apply: %s", err)
+	}
+	if _, err := s.file.Write(record); err != nil {
+		s.rewind(offset)
+		return fmt.Errorf(TRACE+" KeyValueConfigStore Apply: %s", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		s.rewind(offset)
+		return fmt.Errorf(TRACE+" KeyValueConfigStore Apply: %s", err)
+	}
+	s.documents.apply(changes)
+	s.logChanges += len(changes)
+
+	if s.logChanges > minCompaction && s.logChanges > compactionRatio*s.documents.len() {
+		if err := s.compact(); err != nil {
+			fmt.Println(TRACE+" KeyValueConfigStore compact:", err)
+		}
+	}
+	return nil
+}
+
+// rewind drops what a failed Apply wrote after offset, so the next record follows
+// the last complete one. When it cannot, the store fails every later Apply.
+func (s *KeyValueConfigStore) rewind(offset int64) {
+	if err := s.file.Truncate(offset); err != nil {
+		s.failed = err
+		return
+	}
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		s.failed = err
+	}
+}
+
+// compact rewrites the log as a single record of the current documents.
+func (s *KeyValueConfigStore) compact() error {
+	changes := make([]Change, 0, s.documents.len())
+	for kind, byName := range s.documents {
+		for name, document := range byName {
+			changes = append(changes, Change{Kind: kind, Name: name, Document: document})
+		}
+	}
+	record, err := encodeRecord(changes)
+	if err != nil {
+		return err
+	}
+	// The compacted log is still open when it replaces the previous one, so the store
+	// is never left without a file to append to: if anything fails, the previous log
+	// is kept as it is.
+	file, err := createFileAtomically(s.Path, record)
+	if file == nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	s.logChanges = len(changes)
+	return err
+}
+
+func (s *KeyValueConfigStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
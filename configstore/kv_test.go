@@ -0,0 +1,97 @@
+package configstore
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// partialWriteFile writes half of the next record, then fails.
+type partialWriteFile struct {
+	logFile
+}
+
+func (f *partialWriteFile) Write(p []byte) (int, error) {
+	n, _ := f.logFile.Write(p[:len(p)/2])
+	return n, errors.New("diskFull")
+}
+
+func TestKeyValueConfigStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.kv")
+	store, err := NewKeyValueConfigStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= compactionRatio*minCompaction; i++ {
+		change, err := Put(Tenants, "a", map[string]int{"version": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Apply(change); err != nil {
+			t.Fatalf("apply %d: %s", i, err)
+		}
+	}
+	if store.logChanges > minCompaction {
+		t.Fatalf("log not compacted: %d changes", store.logChanges)
+	}
+	// The compacted log is the one appended to.
+	change, _ := Put(Tenants, "b", map[string]int{"version": 0})
+	if err := store.Apply(change); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewKeyValueConfigStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	tenants, _ := reopened.Load(Tenants)
+	if expected := fmt.Sprintf(`{"version":%d}`, compactionRatio*minCompaction); string(tenants["a"]) != expected || tenants["b"] == nil {
+		t.Fatalf("reopened %s", tenants)
+	}
+}
+
+func TestKeyValueConfigStorePartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.kv")
+	store, err := NewKeyValueConfigStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(name string) error {
+		change, err := Put(Tenants, name, map[string]int{"version": 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.Apply(change)
+	}
+	if err := apply("a"); err != nil {
+		t.Fatal(err)
+	}
+	file := store.file
+	store.file = &partialWriteFile{file}
+	if err := apply("b"); err == nil {
+		t.Fatal("the partial write was applied")
+	}
+	store.file = file
+	// The next record follows the last complete one, not the torn one.
+	if err := apply("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewKeyValueConfigStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	tenants, _ := reopened.Load(Tenants)
+	if len(tenants) != 2 || tenants["a"] == nil || tenants["c"] == nil {
+		t.Fatalf("reopened %s", tenants)
+	}
+}
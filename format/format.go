@@ -0,0 +1,216 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/format"
+
+// Format a syntax configuration is written in. YAML and TOML documents are converted
+// to JSON before they are decoded, so every config type is read through its JSON tags
+// and the same field names apply in every format.
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+	TOML Format = "toml"
+)
+
+func (f Format) ContentType() string {
+	switch f {
+	case YAML:
+		return "application/yaml"
+	case TOML:
+		return "application/toml"
+	}
+	return "application/json"
+}
+
+// FromContentType the format of a request body. An empty Content-Type is JSON.
+func FromContentType(contentType string) (Format, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf(TRACE+" FromContentType: %s", err)
+	}
+	switch mediaType {
+	case "application/json", "text/json":
+		return JSON, nil
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return YAML, nil
+	case "application/toml", "text/toml":
+		return TOML, nil
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return JSON, nil
+	}
+	if strings.HasSuffix(mediaType, "+yaml") {
+		return YAML, nil
+	}
+	return "", fmt.Errorf(TRACE+" FromContentType: unsupported \"%s\"", mediaType)
+}
+
+// FromPath the format of a file, by its extension.
+func FromPath(path string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON, true
+	case ".yaml", ".yml":
+		return YAML, true
+	case ".toml":
+		return TOML, true
+	}
+	return "", false
+}
+
+// FromAccept the format a response is written in: YAML when the Accept header
+// prefers it to JSON, JSON otherwise.
+func FromAccept(accept string) Format {
+	bestFormat, bestQuality := JSON, -1.0
+	for _, acceptedRange := range strings.Split(accept, ",") {
+		mediaType, parameters, err := mime.ParseMediaType(strings.TrimSpace(acceptedRange))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, found := parameters["q"]; found {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		f, err := FromContentType(mediaType)
+		if err != nil || f == TOML || quality <= bestQuality {
+			continue
+		}
+		bestFormat, bestQuality = f, quality
+	}
+	return bestFormat
+}
+
+// ToJSON converts a document to JSON.
+func ToJSON(f Format, data []byte) ([]byte, error) {
+	switch f {
+	case JSON:
+		return data, nil
+	case YAML:
+		var document interface{}
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf(TRACE+" ToJSON yaml: %s", err)
+		}
+		document, err := jsonCompatible(document)
+		if err != nil {
+			return nil, fmt.Errorf(TRACE+" ToJSON yaml: %s", err)
+		}
+		return json.Marshal(document)
+	case TOML:
+		var document map[string]interface{}
+		if err := toml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf(TRACE+" ToJSON toml: %s", err)
+		}
+		return json.Marshal(document)
+	}
+	return nil, fmt.Errorf(TRACE+" ToJSON: unsupported \"%s\"", f)
+}
+
+// Decode decodes a document into v, through the JSON tags of v.
+func Decode(f Format, data []byte, v interface{}) error {
+	jsonBytes, err := ToJSON(f, data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonBytes, v)
+}
+
+// DecodeStrict is Decode, failing on fields v does not have.
+func DecodeStrict(f Format, data []byte, v interface{}) error {
+	jsonBytes, err := ToJSON(f, data)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// FromJSON converts a JSON document to JSON or YAML, keeping the order of its fields.
+func FromJSON(f Format, jsonBytes []byte) ([]byte, error) {
+	switch f {
+	case JSON:
+		return jsonBytes, nil
+	case YAML:
+		// JSON is YAML, so the node keeps the field order; only the flow and quoting
+		// styles are reset to the block style.
+		var node yaml.Node
+		if err := yaml.Unmarshal(jsonBytes, &node); err != nil {
+			return nil, fmt.Errorf(TRACE+" FromJSON yaml: %s", err)
+		}
+		resetStyle(&node)
+		var buffer bytes.Buffer
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, fmt.Errorf(TRACE+" FromJSON yaml: %s", err)
+		}
+		encoder.Close()
+		return buffer.Bytes(), nil
+	}
+	return nil, fmt.Errorf(TRACE+" FromJSON: unsupported \"%s\"", f)
+}
+
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// jsonCompatible replaces the maps with non string keys that YAML allows.
+func jsonCompatible(document interface{}) (interface{}, error) {
+	switch value := document.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			converted, err := jsonCompatible(v)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = converted
+		}
+		return value, nil
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			convertedValue, err := jsonCompatible(v)
+			if err != nil {
+				return nil, err
+			}
+			converted[key] = convertedValue
+		}
+		return converted, nil
+	case []interface{}:
+		for i, v := range value {
+			converted, err := jsonCompatible(v)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = converted
+		}
+		return value, nil
+	}
+	return document, nil
+}
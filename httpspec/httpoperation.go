@@ -10,6 +10,8 @@ import (
 	"os"
 	"regexp"
 	"strings"
+
+	"github.com/riotemergence/godynamicweb/format"
 )
 
 type HTTPOperationConfig struct {
@@ -17,28 +19,36 @@ type HTTPOperationConfig struct {
 	Response HTTPResponseConfig `json:"response"`
 }
 
+// NewHTTPOperationConfigFromJSON reads a spec, in JSON unless the Content-Type of the
+// response, or else the extension of the file or URL, tells YAML or TOML.
 func NewHTTPOperationConfigFromJSON(specURL string) (*HTTPOperationConfig, error) {
 
-	var jsonData io.Reader
+	var specData io.Reader
+	contentType := ""
 	if strings.HasPrefix(specURL, "http://") || strings.HasPrefix(specURL, "https://") {
 		r, err := http.Get(specURL)
 		if err != nil {
 			return nil, err
 		}
 		defer r.Body.Close()
-		jsonData = r.Body
+		specData = r.Body
+		contentType = r.Header.Get("Content-Type")
 	} else {
 		f, err := os.Open(specURL)
 		if err != nil {
 			return nil, err
 		}
 		defer f.Close()
-		jsonData = f
+		specData = f
+	}
+	f := specFormat(specURL, contentType)
+	specBytes, err := io.ReadAll(specData)
+	if err != nil {
+		return nil, err
 	}
-	jsonDecoder := json.NewDecoder(jsonData)
 	spec := &HTTPOperationConfig{}
-	if err := jsonDecoder.Decode(spec); err != nil {
-		return nil, fmt.Errorf("client JSON content invalid: %s", err.Error())
+	if err := format.Decode(f, specBytes, spec); err != nil {
+		return nil, fmt.Errorf("client %s content invalid: %s", strings.ToUpper(string(f)), err.Error())
 	}
 
 	validateHTTPRequest(spec.Request)
@@ -46,6 +56,23 @@ func NewHTTPOperationConfigFromJSON(specURL string) (*HTTPOperationConfig, error
 	return spec, nil
 }
 
+func specFormat(specURL string, contentType string) format.Format {
+	if contentType != "" {
+		if f, err := format.FromContentType(contentType); err == nil {
+			return f
+		}
+	}
+	if u, err := url.Parse(specURL); err == nil {
+		if f, supported := format.FromPath(u.Path); supported {
+			return f
+		}
+	}
+	if f, supported := format.FromPath(specURL); supported {
+		return f
+	}
+	return format.JSON
+}
+
 func (spec HTTPOperationConfig) NewRequest(vars map[string]string, bodyEntity *interface{}) (*http.Request, error) {
 	//TODO Body
 
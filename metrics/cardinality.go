@@ -0,0 +1,57 @@
+package metrics
+
+import "sync"
+
+// OverflowLabelValue replaces the label values beyond the limit of a CardinalityGuard.
+const OverflowLabelValue = "__overflow__"
+
+// CardinalityGuard caps how many distinct values a label takes. The first max values
+// seen are kept, every other one is reported as OverflowLabelValue until Retain
+// releases some.
+type CardinalityGuard struct {
+	mutex  sync.Mutex
+	max    int
+	values map[string]bool
+}
+
+func NewCardinalityGuard(max int) *CardinalityGuard {
+	return &CardinalityGuard{
+		max:    max,
+		values: make(map[string]bool),
+	}
+}
+
+// SetMax changes how many values are kept. The values already kept stay so.
+func (g *CardinalityGuard) SetMax(max int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.max = max
+}
+
+// Retain releases the values kept that keep rejects, making room for new ones, and
+// returns them.
+func (g *CardinalityGuard) Retain(keep func(value string) bool) []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	released := make([]string, 0)
+	for value := range g.values {
+		if !keep(value) {
+			delete(g.values, value)
+			released = append(released, value)
+		}
+	}
+	return released
+}
+
+func (g *CardinalityGuard) Value(value string) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.values[value] {
+		return value
+	}
+	if len(g.values) >= g.max {
+		return OverflowLabelValue
+	}
+	g.values[value] = true
+	return value
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCardinalityGuardRetain(t *testing.T) {
+	g := NewCardinalityGuard(2)
+	g.Value("a")
+	g.Value("b")
+	if value := g.Value("c"); value != OverflowLabelValue {
+		t.Fatalf("third value kept as %q", value)
+	}
+
+	released := g.Retain(func(value string) bool { return value != "a" })
+	if len(released) != 1 || released[0] != "a" {
+		t.Fatalf("released %q", released)
+	}
+	if value := g.Value("c"); value != "c" {
+		t.Fatalf("released label not reused: %q", value)
+	}
+
+	g.SetMax(3)
+	if value := g.Value("d"); value != "d" {
+		t.Fatalf("raised max not applied: %q", value)
+	}
+}
+
+func TestDeleteLabelValue(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests.", "tenant")
+	requests.Inc("a")
+	requests.Inc("b")
+	requests.DeleteLabelValue("tenant", "a")
+
+	var written strings.Builder
+	if err := r.Write(&written); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(written.String(), `tenant="a"`) || !strings.Contains(written.String(), `tenant="b"`) {
+		t.Fatalf("unexpected series:\n%s", written.String())
+	}
+}
@@ -0,0 +1,285 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/metrics"
+
+// ContentType the Prometheus text exposition format written by Registry.Write.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+type family interface {
+	writeTo(w *bufio.Writer)
+}
+
+// Registry the metric families exposed together, in registration order.
+type Registry struct {
+	mutex    sync.Mutex
+	names    map[string]bool
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]bool),
+	}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.names[name] {
+		panic(fmt.Errorf(TRACE+" Registry register name: mustBeUnique \"%s\"", name))
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	families := append([]family(nil), r.families...)
+	r.mutex.Unlock()
+
+	bufferedWriter := bufio.NewWriter(w)
+	for _, f := range families {
+		f.writeTo(bufferedWriter)
+	}
+	return bufferedWriter.Flush()
+}
+
+// vec the series of a family, one per combination of label values.
+type vec struct {
+	name       string
+	help       string
+	metricType metricType
+	labelNames []string
+
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// histograms only
+	bucketCounts []uint64
+	count        uint64
+}
+
+func newVec(name, help string, metricType metricType, labelNames []string) *vec {
+	return &vec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		series:     make(map[string]*series),
+	}
+}
+
+// get the series of the label values. The caller must hold the mutex.
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Errorf(TRACE+" %s labelValues: mustMatchLabelNames %v", v.name, v.labelNames))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, found := v.series[key]
+	if !found {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// DeleteLabelValue drops the series whose label labelName is labelValue.
+func (v *vec) DeleteLabelValue(labelName, labelValue string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	for i, name := range v.labelNames {
+		if name != labelName {
+			continue
+		}
+		for key, s := range v.series {
+			if s.labelValues[i] == labelValue {
+				delete(v.series, key)
+			}
+		}
+	}
+}
+
+func (v *vec) sortedSeries() []*series {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]*series, 0, len(keys))
+	for _, k := range keys {
+		s := *v.series[k]
+		s.bucketCounts = append([]uint64(nil), s.bucketCounts...)
+		result = append(result, &s)
+	}
+	return result
+}
+
+func (v *vec) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.metricType)
+}
+
+func (v *vec) writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 {
+		w.WriteString("{")
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			w.WriteString(labelName)
+			w.WriteString("=\"")
+			w.WriteString(escapeLabelValue(labelValues[i]))
+			w.WriteString("\"")
+		}
+		w.WriteString("}")
+	}
+	w.WriteString(" ")
+	w.WriteString(formatFloat(value))
+	w.WriteString("\n")
+}
+
+func (v *vec) writeTo(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, s := range v.sortedSeries() {
+		v.writeSample(w, v.name, v.labelNames, s.labelValues, s.value)
+	}
+}
+
+type CounterVec struct {
+	*vec
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, counterType, labelNames)}
+	r.register(name, c)
+	return c
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Errorf(TRACE+" CounterVec Add %s value: mustNotBeNegative", c.name))
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.get(labelValues).value += value
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+type GaugeVec struct {
+	*vec
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, gaugeType, labelNames)}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.get(labelValues).value = value
+}
+
+func (g *GaugeVec) Add(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.get(labelValues).value += value
+}
+
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{newVec(name, help, histogramType, labelNames), buckets}
+	r.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := h.get(labelValues)
+	if s.bucketCounts == nil {
+		s.bucketCounts = make([]uint64, len(h.buckets))
+	}
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			s.bucketCounts[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	h.writeHeader(w)
+	bucketLabelNames := append(append([]string(nil), h.labelNames...), "le")
+	for _, s := range h.sortedSeries() {
+		for i, upperBound := range h.buckets {
+			h.writeSample(w, h.name+"_bucket", bucketLabelNames, append(append([]string(nil), s.labelValues...), formatFloat(upperBound)), float64(s.bucketCounts[i]))
+		}
+		h.writeSample(w, h.name+"_bucket", bucketLabelNames, append(append([]string(nil), s.labelValues...), "+Inf"), float64(s.count))
+		h.writeSample(w, h.name+"_sum", h.labelNames, s.labelValues, s.value)
+		h.writeSample(w, h.name+"_count", h.labelNames, s.labelValues, float64(s.count))
+	}
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+func escapeLabelValue(labelValue string) string {
+	return labelValueEscaper.Replace(labelValue)
+}
+
+var helpEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
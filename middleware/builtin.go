@@ -0,0 +1,386 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// corsParams the "cors" middleware: answers preflight requests and adds the CORS
+// headers to the responses for the allowed origins.
+type corsParams struct {
+	// AllowedOrigins the origins allowed, or "*" for any.
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods GET, HEAD and POST by default.
+	AllowedMethods   []string `json:"allowedMethods,omitempty"`
+	AllowedHeaders   []string `json:"allowedHeaders,omitempty"`
+	ExposedHeaders   []string `json:"exposedHeaders,omitempty"`
+	AllowCredentials bool     `json:"allowCredentials,omitempty"`
+	// MaxAge seconds a preflight response can be cached.
+	MaxAge *int `json:"maxAge,omitempty"`
+}
+
+func newCors(params json.RawMessage, secrets Secrets) (Middleware, error) {
+	var p corsParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	v := validation.NewValidator()
+	anyOrigin := false
+	if v.Required("allowedOrigins", len(p.AllowedOrigins) > 0) {
+		for _, origin := range p.AllowedOrigins {
+			anyOrigin = anyOrigin || origin == "*"
+		}
+	}
+	if anyOrigin && p.AllowCredentials {
+		v.Field("allowCredentials").Fail("mustNotBeSetForAnyOrigin")
+	}
+	if p.MaxAge != nil && *p.MaxAge < 0 {
+		v.Field("maxAge").Fail("mustNotBeNegative")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+
+	allowedOrigins := make(map[string]bool, len(p.AllowedOrigins))
+	for _, origin := range p.AllowedOrigins {
+		allowedOrigins[origin] = true
+	}
+	allowedMethods := make(map[string]bool, len(p.AllowedMethods))
+	for _, method := range p.AllowedMethods {
+		allowedMethods[strings.ToUpper(method)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !anyOrigin && !allowedOrigins[origin] {
+				if preflight {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowOrigin := origin
+			if anyOrigin {
+				allowOrigin = "*"
+			}
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			if p.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if len(p.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !allowedMethods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+			if len(p.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+			}
+			if p.MaxAge != nil {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(*p.MaxAge))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}, nil
+}
+
+// headersParams the "headers" middleware: changes the response headers just before
+// they are written, whatever the endpoint set.
+type headersParams struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+func newHeaders(params json.RawMessage, secrets Secrets) (Middleware, error) {
+	var p headersParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	v := validation.NewValidator()
+	for name := range p.Set {
+		if name == "" {
+			v.Field("set").Field(name).Fail("nameMustNotBeEmpty")
+		}
+	}
+	for name := range p.Add {
+		if name == "" {
+			v.Field("add").Field(name).Fail("nameMustNotBeEmpty")
+		}
+	}
+	for index, name := range p.Remove {
+		if name == "" {
+			v.Field("remove").Index(index).Fail("mustNotBeEmpty")
+		}
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&responseWriter{
+				ResponseWriter: w,
+				beforeWriteHeader: func(int) {
+					header := w.Header()
+					for _, name := range p.Remove {
+						header.Del(name)
+					}
+					for name, value := range p.Set {
+						header.Set(name, value)
+					}
+					for name, value := range p.Add {
+						header.Add(name, value)
+					}
+				},
+			}, r)
+		})
+	}, nil
+}
+
+// compressParams the "compress" middleware: gzips the responses of the clients that
+// accept it.
+type compressParams struct {
+	// Level from 1, the fastest, to 9, the smallest; gzip.DefaultCompression by default.
+	Level *int `json:"level,omitempty"`
+}
+
+func newCompress(params json.RawMessage, secrets Secrets) (Middleware, error) {
+	var p compressParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	level := gzip.DefaultCompression
+	if p.Level != nil {
+		if *p.Level < gzip.BestSpeed || *p.Level > gzip.BestCompression {
+			v := validation.NewValidator()
+			v.Field("level").Failf("mustBeInRange", "%d..%d", gzip.BestSpeed, gzip.BestCompression)
+			return nil, v.Err()
+		}
+		level = *p.Level
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gzipWriter := &gzipResponseWriter{level: level}
+			gzipWriter.responseWriter = responseWriter{
+				ResponseWriter:    w,
+				beforeWriteHeader: gzipWriter.start,
+			}
+			defer gzipWriter.close()
+			next.ServeHTTP(gzipWriter, r)
+		})
+	}, nil
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, parameters, _ := strings.Cut(strings.TrimSpace(coding), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(parameters), "q=")
+		if !found {
+			return true
+		}
+		quality, err := strconv.ParseFloat(q, 64)
+		return err == nil && quality > 0
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	responseWriter
+	level  int
+	writer *gzip.Writer
+}
+
+// start compresses the body unless the response has none, is already encoded or is a
+// byte range.
+func (w *gzipResponseWriter) start(statusCode int) {
+	header := w.Header()
+	if statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified ||
+		statusCode == http.StatusPartialContent || header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	w.writer, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.writer == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.writer.Write(b)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.writer != nil {
+		w.writer.Flush()
+	}
+	w.responseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.writer != nil {
+		w.writer.Close()
+	}
+}
+
+// maxBodySizeParams the "maxBodySize" middleware: rejects request bodies larger than
+// Bytes with 413 Request Entity Too Large.
+type maxBodySizeParams struct {
+	Bytes *int64 `json:"bytes"`
+}
+
+func newMaxBodySize(params json.RawMessage, secrets Secrets) (Middleware, error) {
+	var p maxBodySizeParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	v := validation.NewValidator()
+	if v.Required("bytes", p.Bytes != nil) && *p.Bytes < 0 {
+		v.Field("bytes").Fail("mustNotBeNegative")
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	maxBytes := *p.Bytes
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+const (
+	BearerAuth = "bearer"
+	BasicAuth  = "basic"
+)
+
+// authParams the "auth" middleware: rejects the requests without the credentials of
+// Scheme with 401 Unauthorized. Tokens and passwords are the names of tenant secrets.
+type authParams struct {
+	Scheme *string `json:"scheme"`
+	Realm  *string `json:"realm,omitempty"`
+	// Tokens the secrets holding the accepted bearer tokens.
+	Tokens []string `json:"tokens,omitempty"`
+	// Users the secret holding the password of every basic user.
+	Users map[string]string `json:"users,omitempty"`
+}
+
+func newAuth(params json.RawMessage, secrets Secrets) (Middleware, error) {
+	var p authParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	v := validation.NewValidator()
+	secret := func(v *validation.Validator, name string) []byte {
+		value, found := secrets(name)
+		if !found {
+			v.Failf("mustReferenceSecret", "%q", name)
+		}
+		return []byte(value)
+	}
+
+	tokens := make([][]byte, 0, len(p.Tokens))
+	passwords := make(map[string][]byte, len(p.Users))
+	if v.Required("scheme", p.Scheme != nil) {
+		switch *p.Scheme {
+		case BearerAuth:
+			v.Required("tokens", len(p.Tokens) > 0)
+			for index, name := range p.Tokens {
+				tokens = append(tokens, secret(v.Field("tokens").Index(index), name))
+			}
+		case BasicAuth:
+			v.Required("users", len(p.Users) > 0)
+			for user, name := range p.Users {
+				passwords[user] = secret(v.Field("users").Field(user), name)
+			}
+		default:
+			v.Field("scheme").Failf("mustBeKnownScheme", "%q", *p.Scheme)
+		}
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	realm := "tenant"
+	if p.Realm != nil {
+		realm = *p.Realm
+	}
+
+	scheme := *p.Scheme
+	challenge := fmt.Sprintf("Bearer realm=%q", realm)
+	if scheme == BasicAuth {
+		challenge = fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm)
+	}
+	authorized := func(r *http.Request) bool {
+		if scheme == BasicAuth {
+			user, password, ok := r.BasicAuth()
+			expected, found := passwords[user]
+			return ok && found && subtle.ConstantTimeCompare([]byte(password), expected) == 1
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			return false
+		}
+		match := 0
+		for _, expected := range tokens {
+			match |= subtle.ConstantTimeCompare([]byte(token), expected)
+		}
+		return match == 1
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/middleware"
+
+// Middleware wraps a handler with a cross-cutting behavior.
+type Middleware func(http.Handler) http.Handler
+
+// Identity the Middleware that leaves a handler as it is.
+func Identity(next http.Handler) http.Handler {
+	return next
+}
+
+// Chain the Middleware that applies middleware in order: the first one sees the
+// request first, and the response last.
+func Chain(middleware ...Middleware) Middleware {
+	if len(middleware) == 0 {
+		return Identity
+	}
+	return func(next http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		return next
+	}
+}
+
+// Secrets the secrets a middleware is built with, by name.
+type Secrets func(name string) (string, bool)
+
+// Factory builds a middleware from its JSON params. Invalid params are reported as
+// validation.Errors, with pointers relative to the params.
+type Factory func(params json.RawMessage, secrets Secrets) (Middleware, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Factory{
+		"cors":        newCors,
+		"headers":     newHeaders,
+		"compress":    newCompress,
+		"maxBodySize": newMaxBodySize,
+		"auth":        newAuth,
+	}
+)
+
+// Register adds a middleware that configs can reference by name.
+func Register(name string, factory Factory) error {
+	if name == "" {
+		return fmt.Errorf(TRACE + " Register name: mustNotBeEmpty")
+	}
+	if factory == nil {
+		return fmt.Errorf(TRACE + " Register factory: mustNotBeEmpty")
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, found := registry[name]; found {
+		return fmt.Errorf(TRACE+" Register name: alreadyExists \"%s\"", name)
+	}
+	registry[name] = factory
+	return nil
+}
+
+// Names the names of the registered middleware.
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registered whether a middleware is registered as name.
+func Registered(name string) bool {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	_, found := registry[name]
+	return found
+}
+
+// New builds the middleware registered as name.
+func New(name string, params json.RawMessage, secrets Secrets) (Middleware, error) {
+	registryMutex.RLock()
+	factory, found := registry[name]
+	registryMutex.RUnlock()
+	if !found {
+		return nil, validation.Invalidf("mustBeRegisteredMiddleware", "%q", name)
+	}
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		params = json.RawMessage("{}")
+	}
+	return factory(params, secrets)
+}
+
+// decodeParams decodes params strictly, so that a misspelled param is reported.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return validation.Invalidf("mustBeValidParams", "%s", err)
+	}
+	return nil
+}
+
+// responseWriter lets a middleware act when the response header is written.
+type responseWriter struct {
+	http.ResponseWriter
+	beforeWriteHeader func(statusCode int)
+	wroteHeader       bool
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.beforeWriteHeader(statusCode)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,67 @@
+package multitenancy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	gox509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+func selfSignedX509Config(t *testing.T, name string) x509.X509Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &gox509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateBytes, err := gox509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := gox509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x509.X509Config{
+		PKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}),
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes}),
+	}
+}
+
+func TestCertificateConflictsFailCommit(t *testing.T) {
+	m := NewMultiTenancySupport()
+	m.CertificateConflicts = func(certificate *x509.Certificate) []string {
+		if certificate.HasName("taken.test") {
+			return []string{"taken.test"}
+		}
+		return nil
+	}
+	methods := map[string]string{"hello": "GET"}
+
+	config := tenantConfig(t, `{"name":"a","serverEndpoints":{"hello":{"url":"http://a.test/hello","connector":"c"}}}`)
+	config.X509 = []x509.X509Config{selfSignedX509Config(t, "taken.test")}
+	if err := m.AddTenant("a", config, methods); err == nil {
+		t.Fatal("a tenant took a name used elsewhere")
+	}
+	if len(m.Tenants()) != 0 || len(m.CertificateIndex().Certificates()) != 0 {
+		t.Fatal("the failed commit left changes")
+	}
+
+	config.X509 = []x509.X509Config{selfSignedX509Config(t, "free.test")}
+	if err := m.AddTenant("a", config, methods); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,11 +1,19 @@
 package multitenancy
 
 import (
-	"fmt"
+	"math"
+	"net/http"
 	"net/url"
 	"os"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/riotemergence/godynamicweb/mux"
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/tenantresolver"
 	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
 	"github.com/riotemergence/godynamicweb/x509"
 )
 
@@ -14,87 +22,147 @@ type AbsoluteHttpUrl string
 
 // Set setter for AbsoluteURL
 func (u AbsoluteHttpUrl) Validate() error {
+	return validation.Validate(u)
+}
+
+func (u AbsoluteHttpUrl) JSONSchema() *schema.Schema {
+	return &schema.Schema{Type: "string", Format: "uri", Pattern: "^[hH][tT][tT][pP][sS]?:"}
+}
+
+func (u AbsoluteHttpUrl) ValidateWith(v *validation.Validator) {
 	uAsUrl, err := url.Parse(string(u))
 	if err != nil {
-		return fmt.Errorf(TRACE + " AbsoluteHttpUrl: mustBeValidUrl")
+		v.Fail("mustBeValidUrl")
+		return
 	}
 	if !uAsUrl.IsAbs() {
-		return fmt.Errorf(TRACE + " AbsoluteHttpUrl: mustBeAbsoluteUrl")
+		v.Fail("mustBeAbsoluteUrl")
+		return
 	}
 	if uAsUrl.Scheme != "http" && uAsUrl.Scheme != "https" {
-		return fmt.Errorf(TRACE + " AbsoluteHttpUrl: mustBeHttpUrl")
+		v.Fail("mustBeHttpUrl")
+	}
+}
+
+const (
+	// AnyHost the host of an EndpointUrl that matches requests for every host.
+	AnyHost = mux.AnyHost
+)
+
+// EndpointUrl an absolute HTTP URL where the host may be AnyHost, and the scheme may
+// be omitted ("//host/path") to match both http and https.
+type EndpointUrl string
+
+func (u EndpointUrl) Validate() error {
+	return validation.Validate(u)
+}
+
+func (u EndpointUrl) JSONSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:    "string",
+		Pattern: `^([hH][tT][tT][pP][sS]?:)?//(\*|[^/?#*]+)([/?#].*)?$`,
+	}
+}
+
+func (u EndpointUrl) ValidateWith(v *validation.Validator) {
+	uAsUrl, err := url.Parse(string(u))
+	if err != nil {
+		v.Fail("mustBeValidUrl")
+		return
+	}
+	if uAsUrl.Scheme == "" {
+		if !strings.HasPrefix(string(u), "//") {
+			v.Fail("mustBeAbsoluteOrSchemeRelativeUrl")
+			return
+		}
+	} else if uAsUrl.Scheme != "http" && uAsUrl.Scheme != "https" {
+		v.Fail("mustBeHttpUrl")
+		return
+	}
+	if uAsUrl.Host == "" {
+		v.Fail("hostRequired")
+		return
+	}
+	if uAsUrl.Host != AnyHost && strings.Contains(uAsUrl.Host, "*") {
+		v.Fail("hostMustBeLiteralOrAnyHost")
 	}
-	return nil
 }
 
 type ServerEndpointConfig struct {
-	Url       *AbsoluteHttpUrl `json:"url"`
-	Connector *string          `json:"connector"`
+	Url       *EndpointUrl `json:"url"`
+	Connector *string      `json:"connector"`
+	// Middleware applied after the middleware of the tenant; checked by TenantConfig,
+	// which knows the secrets.
+	Middleware MiddlewareConfigs `json:"middleware,omitempty"`
+	// TenantResolver finds the tenant of a request when the endpoint is shared by
+	// several tenants, in place of the resolver of the connector.
+	TenantResolver *tenantresolver.Config `json:"tenantResolver,omitempty"`
 }
 
 func (sec ServerEndpointConfig) Validate() error {
-	if sec.Url == nil {
-		return fmt.Errorf(TRACE + " ServerEndpointConfig Url: required")
-	}
-	if err := sec.Url.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" ServerEndpointConfig Url: %s", err)
+	return validation.Validate(sec)
+}
+
+func (sec ServerEndpointConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("url", sec.Url != nil) {
+		v.Field("url").Check(sec.Url)
 	}
-	if sec.Connector == nil {
-		return fmt.Errorf(TRACE + " ServerEndpointConfig Connector: required")
+	v.Required("connector", sec.Connector != nil)
+	if sec.TenantResolver != nil {
+		v.Field("tenantResolver").Check(sec.TenantResolver)
 	}
-	return nil
 }
 
 type ServerEndpointsConfig map[string]ServerEndpointConfig
 
 func (c ServerEndpointsConfig) Validate() error {
-	for k, v := range c {
-		if err := v.Validate(); err != nil {
-			return fmt.Errorf(TRACE+" ServerEndpointsConfig \"%s\": %s", k, err)
-		}
+	return validation.Validate(c)
+}
+
+func (c ServerEndpointsConfig) ValidateWith(v *validation.Validator) {
+	for _, k := range validation.SortedKeys(c) {
+		v.Field(k).Check(c[k])
 	}
-	return nil
 }
 
 type ReverseProxyEndpointConfig struct {
-	Url       *AbsoluteHttpUrl `json:"url"`
+	Url       *EndpointUrl     `json:"url"`
 	Connector *string          `json:"connector"`
 	Methods   *[]string        `json:"methods"`
 	TargetUrl *AbsoluteHttpUrl `json:"targetUrl"`
+	// Middleware as in ServerEndpointConfig.
+	Middleware MiddlewareConfigs `json:"middleware,omitempty"`
 }
 
 func (rpc ReverseProxyEndpointConfig) Validate() error {
-	if rpc.Url == nil {
-		return fmt.Errorf(TRACE + " ReverseProxyConfig Url: required")
-	}
-	if err := rpc.Url.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" ReverseProxyConfig Url: %s", err)
-	}
-	if rpc.Connector == nil {
-		return fmt.Errorf(TRACE + " ReverseProxyConfig Connector: required")
-	}
-	if rpc.Methods == nil || len(*rpc.Methods) == 0 {
-		return fmt.Errorf(TRACE + " ReverseProxyConfig Methods: required")
-	}
-	if rpc.TargetUrl == nil {
-		return fmt.Errorf(TRACE + " ReverseProxyConfig TargetUrl: required")
+	return validation.Validate(rpc)
+}
+
+func (rpc ReverseProxyEndpointConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("methods").MinItems = schema.Int(1)
+}
+
+func (rpc ReverseProxyEndpointConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("url", rpc.Url != nil) {
+		v.Field("url").Check(rpc.Url)
 	}
-	if err := rpc.TargetUrl.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" ReverseProxyConfig TargetUrl: %s", err)
+	v.Required("connector", rpc.Connector != nil)
+	v.Required("methods", rpc.Methods != nil && len(*rpc.Methods) > 0)
+	if v.Required("targetUrl", rpc.TargetUrl != nil) {
+		v.Field("targetUrl").Check(rpc.TargetUrl)
 	}
-
-	return nil
 }
 
 type ReverseProxyEndpointsConfig []ReverseProxyEndpointConfig
 
 func (c ReverseProxyEndpointsConfig) Validate() error {
-	for k, v := range c {
-		if err := v.Validate(); err != nil {
-			return fmt.Errorf(TRACE+" ReverseProxyEndpointsConfig \"%s\": %s", k, err)
-		}
+	return validation.Validate(c)
+}
+
+func (c ReverseProxyEndpointsConfig) ValidateWith(v *validation.Validator) {
+	for i, endpoint := range c {
+		v.Index(i).Check(endpoint)
 	}
-	return nil
 }
 
 // ExistingFile a string representing a existing file path
@@ -102,52 +170,311 @@ type ExistingDir string
 
 // Set setter for ExistingFile
 func (ed ExistingDir) Validate() error {
+	return validation.Validate(ed)
+}
+
+func (ed ExistingDir) JSONSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        "string",
+		Description: "A directory that exists on the server.",
+		MinLength:   schema.Int(1),
+	}
+}
+
+func (ed ExistingDir) ValidateWith(v *validation.Validator) {
 	fi, err := os.Stat(string(ed))
-	if os.IsNotExist(err) {
-		return fmt.Errorf("\"%s\" file not found", ed)
+	if err != nil {
+		v.Failf("mustExist", "%q", string(ed))
+		return
 	}
 	if !fi.IsDir() {
-		return fmt.Errorf("\"%s\" must be dir", ed)
+		v.Failf("mustBeDir", "%q", string(ed))
 	}
-	return nil
 }
 
 type FileServerEndpointConfig struct {
-	Url        *AbsoluteHttpUrl `json:"url"`
-	Connector  *string          `json:"connector"`
-	RootFs     *ExistingDir     `json:"rootFs"`
-	DirListing *bool            `json:"dirListing"`
+	Url        *EndpointUrl `json:"url"`
+	Connector  *string      `json:"connector"`
+	RootFs     *ExistingDir `json:"rootFs"`
+	DirListing *bool        `json:"dirListing"`
+	// Middleware as in ServerEndpointConfig.
+	Middleware MiddlewareConfigs `json:"middleware,omitempty"`
 }
 
 func (fsec FileServerEndpointConfig) Validate() error {
-	if fsec.Url == nil {
-		return fmt.Errorf(TRACE + " FileServerEndpointConfig Url: required")
+	return validation.Validate(fsec)
+}
+
+func (fsec FileServerEndpointConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("url", fsec.Url != nil) {
+		v.Field("url").Check(fsec.Url)
 	}
-	if err := fsec.Url.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" FileServerEndpointConfig Url: %s", err)
+	v.Required("connector", fsec.Connector != nil)
+	if v.Required("rootFs", fsec.RootFs != nil) {
+		v.Field("rootFs").Check(fsec.RootFs)
 	}
-	if fsec.Connector == nil {
-		return fmt.Errorf(TRACE + " FileServerEndpointConfig Connector: required")
+}
+
+type FileServerEndpointsConfig []FileServerEndpointConfig
+
+func (c FileServerEndpointsConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c FileServerEndpointsConfig) ValidateWith(v *validation.Validator) {
+	for i, endpoint := range c {
+		v.Index(i).Check(endpoint)
 	}
-	if fsec.RootFs == nil {
-		return fmt.Errorf(TRACE + " FileServerEndpointConfig RootFs: required")
+}
+
+// RedirectStatuses the statuses a redirect endpoint may answer with.
+var RedirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+type RedirectEndpointConfig struct {
+	Url       *EndpointUrl `json:"url"`
+	Connector *string      `json:"connector"`
+	// Methods defaults to GET and HEAD.
+	Methods *[]string `json:"methods,omitempty"`
+	// Target the URL or the absolute path redirected to. "{name}" is replaced by the
+	// path segment the url captures as "{name}", and "*" by the rest of the path its
+	// trailing "*" matches.
+	Target *string `json:"target"`
+	// Status defaults to 302 Found.
+	Status *int `json:"status,omitempty"`
+	// PreserveQuery appends the query string of the request to the target.
+	PreserveQuery *bool `json:"preserveQuery,omitempty"`
+	// Middleware as in ServerEndpointConfig.
+	Middleware MiddlewareConfigs `json:"middleware,omitempty"`
+}
+
+func (rec RedirectEndpointConfig) Validate() error {
+	return validation.Validate(rec)
+}
+
+func (rec RedirectEndpointConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("methods").MinItems = schema.Int(1)
+	s.Property("target").MinLength = schema.Int(1)
+	s.Property("status").Enum = make([]interface{}, len(RedirectStatuses))
+	for i, status := range RedirectStatuses {
+		s.Property("status").Enum[i] = status
 	}
-	if err := fsec.RootFs.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" FileServerEndpointConfig RootFs: %s", err)
+}
+
+func (rec RedirectEndpointConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("url", rec.Url != nil) {
+		v.Field("url").Check(rec.Url)
+	}
+	v.Required("connector", rec.Connector != nil)
+	if rec.Methods != nil && len(*rec.Methods) == 0 {
+		v.Field("methods").Fail("mustNotBeEmpty")
+	}
+	if v.Required("target", rec.Target != nil && *rec.Target != "") && rec.Url != nil {
+		if u, err := url.Parse(string(*rec.Url)); err == nil {
+			v.Field("target").Add(validateRedirectTarget(*rec.Target, *mux.NewPathParts(u.Path)))
+		}
+	}
+	if rec.Status != nil && !isRedirectStatus(*rec.Status) {
+		v.Field("status").Failf("mustBeRedirectStatus", "%d", *rec.Status)
 	}
+}
 
-	return nil
+func isRedirectStatus(status int) bool {
+	for _, redirectStatus := range RedirectStatuses {
+		if status == redirectStatus {
+			return true
+		}
+	}
+	return false
 }
 
-type FileServerEndpointsConfig []FileServerEndpointConfig
+// MethodsOrDefault the methods the endpoint redirects.
+func (rec RedirectEndpointConfig) MethodsOrDefault() []string {
+	if rec.Methods != nil {
+		return *rec.Methods
+	}
+	return []string{http.MethodGet, http.MethodHead}
+}
 
-func (c FileServerEndpointsConfig) Validate() error {
-	for k, v := range c {
-		if err := v.Validate(); err != nil {
-			return fmt.Errorf(TRACE+" FileServerEndpointsConfig \"%s\": %s", k, err)
-		}
+func (rec RedirectEndpointConfig) StatusOrDefault() int {
+	if rec.Status != nil {
+		return *rec.Status
+	}
+	return http.StatusFound
+}
+
+type RedirectEndpointsConfig []RedirectEndpointConfig
+
+func (c RedirectEndpointsConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c RedirectEndpointsConfig) ValidateWith(v *validation.Validator) {
+	for i, endpoint := range c {
+		v.Index(i).Check(endpoint)
+	}
+}
+
+type RateLimitConfig struct {
+	RequestsPerSecond *float64 `json:"requestsPerSecond"`
+	// Burst defaults to RequestsPerSecond rounded up.
+	Burst *int `json:"burst"`
+}
+
+func (c RateLimitConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c RateLimitConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("requestsPerSecond").ExclusiveMinimum = schema.Float(0)
+	s.Property("burst").Minimum = schema.Float(1)
+}
+
+func (c RateLimitConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("requestsPerSecond", c.RequestsPerSecond != nil) && *c.RequestsPerSecond <= 0 {
+		v.Field("requestsPerSecond").Fail("mustBePositive")
+	}
+	if c.Burst != nil && *c.Burst < 1 {
+		v.Field("burst").Fail("mustBePositive")
+	}
+}
+
+func (c RateLimitConfig) BurstOrDefault() int {
+	if c.Burst != nil {
+		return *c.Burst
+	}
+	return int(math.Ceil(*c.RequestsPerSecond))
+}
+
+type QuotaConfig struct {
+	Daily   *int64 `json:"daily"`
+	Monthly *int64 `json:"monthly"`
+}
+
+func (c QuotaConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c QuotaConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("daily").Minimum = schema.Float(0)
+	s.Property("monthly").Minimum = schema.Float(0)
+	s.AnyOf = []*schema.Schema{
+		{Required: []string{"daily"}, Properties: map[string]*schema.Schema{"daily": {Type: "integer"}}},
+		{Required: []string{"monthly"}, Properties: map[string]*schema.Schema{"monthly": {Type: "integer"}}},
+	}
+}
+
+func (c QuotaConfig) ValidateWith(v *validation.Validator) {
+	if c.Daily == nil && c.Monthly == nil {
+		v.Fail("dailyOrMonthlyRequired")
+	}
+	if c.Daily != nil && *c.Daily < 0 {
+		v.Field("daily").Fail("mustNotBeNegative")
+	}
+	if c.Monthly != nil && *c.Monthly < 0 {
+		v.Field("monthly").Fail("mustNotBeNegative")
+	}
+}
+
+// ConcurrencyConfig a bulkhead: how many requests are served at once, and how many
+// more wait for their turn.
+type ConcurrencyConfig struct {
+	MaxInFlight *int `json:"maxInFlight"`
+	// MaxQueued 0 by default, rejecting the requests over MaxInFlight right away.
+	MaxQueued *int `json:"maxQueued,omitempty"`
+	// QueueTimeout seconds a request waits at most, 1 by default.
+	QueueTimeout *float64 `json:"queueTimeout,omitempty"`
+}
+
+func (c ConcurrencyConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c ConcurrencyConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("maxInFlight").Minimum = schema.Float(1)
+	s.Property("maxQueued").Minimum = schema.Float(0)
+	s.Property("queueTimeout").ExclusiveMinimum = schema.Float(0)
+}
+
+func (c ConcurrencyConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("maxInFlight", c.MaxInFlight != nil) && *c.MaxInFlight < 1 {
+		v.Field("maxInFlight").Fail("mustBePositive")
+	}
+	if c.MaxQueued != nil && *c.MaxQueued < 0 {
+		v.Field("maxQueued").Fail("mustNotBeNegative")
+	}
+	if c.QueueTimeout != nil && *c.QueueTimeout <= 0 {
+		v.Field("queueTimeout").Fail("mustBePositive")
+	}
+}
+
+func (c ConcurrencyConfig) MaxQueuedOrDefault() int {
+	if c.MaxQueued != nil {
+		return *c.MaxQueued
+	}
+	return 0
+}
+
+func (c ConcurrencyConfig) QueueTimeoutOrDefault() time.Duration {
+	if c.QueueTimeout != nil {
+		return time.Duration(*c.QueueTimeout * float64(time.Second))
+	}
+	return time.Second
+}
+
+// LimitsConfig the rate limits, quotas and concurrency limits of a tenant. Endpoints
+// and EndpointConcurrency are keyed by EndpointKey, for example "server/hello" or
+// "proxy/0".
+type LimitsConfig struct {
+	Tenant    *RateLimitConfig           `json:"tenant"`
+	Endpoints map[string]RateLimitConfig `json:"endpoints"`
+	ClientIp  *RateLimitConfig           `json:"clientIp"`
+	Quota     *QuotaConfig               `json:"quota"`
+	// Concurrency the bulkhead of the whole tenant.
+	Concurrency         *ConcurrencyConfig           `json:"concurrency,omitempty"`
+	EndpointConcurrency map[string]ConcurrencyConfig `json:"endpointConcurrency,omitempty"`
+}
+
+func (c LimitsConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c LimitsConfig) RefineJSONSchema(s *schema.Schema) {
+	endpointKey := &schema.Schema{
+		Pattern: "^(" + string(ServerEndpointKind) + "|" + string(ReverseProxyEndpointKind) + "|" + string(FileServerEndpointKind) + "|" + string(RedirectEndpointKind) + ")/.+$",
+	}
+	s.Property("endpoints").PropertyNames = endpointKey
+	s.Property("endpointConcurrency").PropertyNames = endpointKey
+}
+
+func (c LimitsConfig) ValidateWith(v *validation.Validator) {
+	if c.Tenant != nil {
+		v.Field("tenant").Check(c.Tenant)
+	}
+	for _, k := range validation.SortedKeys(c.Endpoints) {
+		endpointValidator := v.Field("endpoints").Field(k)
+		endpointValidator.Add(ValidateEndpointKey(k))
+		endpointValidator.Check(c.Endpoints[k])
+	}
+	if c.ClientIp != nil {
+		v.Field("clientIp").Check(c.ClientIp)
+	}
+	if c.Quota != nil {
+		v.Field("quota").Check(c.Quota)
+	}
+	if c.Concurrency != nil {
+		v.Field("concurrency").Check(c.Concurrency)
+	}
+	for _, k := range validation.SortedKeys(c.EndpointConcurrency) {
+		endpointValidator := v.Field("endpointConcurrency").Field(k)
+		endpointValidator.Add(ValidateEndpointKey(k))
+		endpointValidator.Check(c.EndpointConcurrency[k])
 	}
-	return nil
 }
 
 type TenantConfig struct {
@@ -156,6 +483,22 @@ type TenantConfig struct {
 	ServerEndpoints       *ServerEndpointsConfig       `json:"serverEndpoints"`
 	ReverseProxyEndpoints *ReverseProxyEndpointsConfig `json:"reverseProxyEndpoints"`
 	FileServerEndpoints   *FileServerEndpointsConfig   `json:"fileServerEndpoints"`
+	RedirectEndpoints     *RedirectEndpointsConfig     `json:"redirectEndpoints,omitempty"`
+	Limits                *LimitsConfig                `json:"limits,omitempty"`
+	// Variables settings of the tenant, such as feature flags, read by handlers with
+	// Variable.
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// Secrets where the secrets of the tenant, such as API keys, are read from.
+	Secrets map[string]SecretConfig `json:"secrets,omitempty"`
+	// Middleware applied to every endpoint of the tenant, before the middleware of the
+	// endpoint.
+	Middleware MiddlewareConfigs `json:"middleware,omitempty"`
+	// ResolvableBy the tenants whose own tenant resolvers may serve requests as this
+	// tenant, with its values. The resolvers of the connectors, which only admins
+	// configure, need no such consent.
+	ResolvableBy []string `json:"resolvableBy,omitempty"`
+	// Template the template the tenant was rendered from, if any.
+	Template *TemplateRef `json:"template,omitempty"`
 }
 
 func (c TenantConfig) String() string {
@@ -163,36 +506,196 @@ func (c TenantConfig) String() string {
 }
 
 func (c TenantConfig) Validate() error {
-	if c.Name == nil {
-		return fmt.Errorf(TRACE + " TenantConfig Name: required")
+	return validation.Validate(c)
+}
+
+func (c TenantConfig) ValidateWith(v *validation.Validator) {
+	v.Required("name", c.Name != nil)
+	for i, x509 := range c.X509 {
+		v.Field("x509").Index(i).Check(x509)
+	}
+	if v.Required("serverEndpoints", c.ServerEndpoints != nil) {
+		v.Field("serverEndpoints").Check(c.ServerEndpoints)
+	}
+	if c.ReverseProxyEndpoints != nil {
+		v.Field("reverseProxyEndpoints").Check(c.ReverseProxyEndpoints)
+	}
+	if c.FileServerEndpoints != nil {
+		v.Field("fileServerEndpoints").Check(c.FileServerEndpoints)
+	}
+	if c.RedirectEndpoints != nil {
+		v.Field("redirectEndpoints").Check(c.RedirectEndpoints)
 	}
+	if c.Limits != nil {
+		v.Field("limits").Check(c.Limits)
+	}
+	for _, name := range validation.SortedKeys(c.Variables) {
+		if name == "" {
+			v.Field("variables").Field(name).Fail("nameMustNotBeEmpty")
+		}
+	}
+	for _, name := range validation.SortedKeys(c.Secrets) {
+		if name == "" {
+			v.Field("secrets").Field(name).Fail("nameMustNotBeEmpty")
+		}
+		v.Field("secrets").Field(name).Check(c.Secrets[name])
+	}
+	c.validateMiddleware(v)
+	for i, tenantID := range c.ResolvableBy {
+		if tenantID == "" {
+			v.Field("resolvableBy").Index(i).Fail("mustNotBeEmpty")
+		}
+	}
+	if c.Template != nil {
+		v.Field("template").Check(c.Template)
+	}
+}
+
+// IsResolvableBy whether the tenant resolvers of tenantID may serve requests as c.
+func (c TenantConfig) IsResolvableBy(tenantID string) bool {
+	for _, resolvableBy := range c.ResolvableBy {
+		if resolvableBy == tenantID {
+			return true
+		}
+	}
+	return false
+}
 
-	if c.X509 != nil {
-		for _, x509 := range c.X509 {
-			if err := x509.Validate(); err != nil {
-				return err
+// ValidateOperatorFields checks that c, the config of tenantID among tenants, sets
+// none of the fields only an operator may set, unless the config c replaces has them
+// already: they reach outside the tenant. They are the directories the file servers
+// serve, the targets of the reverse proxies, where the secrets are read from, the
+// tenant resolvers of the server endpoints, and the urls of any host, of any scheme
+// or of a host another tenant serves.
+func (c TenantConfig) ValidateOperatorFields(tenantID string, tenants TenantsConfig) error {
+	current := tenants[tenantID]
+	v := validation.NewValidator()
+	if c.FileServerEndpoints != nil {
+		servedDirs := make(map[ExistingDir]bool)
+		if current.FileServerEndpoints != nil {
+			for _, endpoint := range *current.FileServerEndpoints {
+				if endpoint.RootFs != nil {
+					servedDirs[*endpoint.RootFs] = true
+				}
+			}
+		}
+		for i, endpoint := range *c.FileServerEndpoints {
+			if endpoint.RootFs != nil && !servedDirs[*endpoint.RootFs] {
+				v.Field("fileServerEndpoints").Index(i).Field("rootFs").Fail("mustBeSetByAnOperator")
+			}
+		}
+	}
+	if c.ReverseProxyEndpoints != nil {
+		targetUrls := make(map[AbsoluteHttpUrl]bool)
+		if current.ReverseProxyEndpoints != nil {
+			for _, endpoint := range *current.ReverseProxyEndpoints {
+				if endpoint.TargetUrl != nil {
+					targetUrls[*endpoint.TargetUrl] = true
+				}
+			}
+		}
+		for i, endpoint := range *c.ReverseProxyEndpoints {
+			if endpoint.TargetUrl != nil && !targetUrls[*endpoint.TargetUrl] {
+				v.Field("reverseProxyEndpoints").Index(i).Field("targetUrl").Fail("mustBeSetByAnOperator")
 			}
 		}
 	}
+	for _, name := range validation.SortedKeys(c.Secrets) {
+		if currentSecret, found := current.Secrets[name]; !found || !reflect.DeepEqual(c.Secrets[name], currentSecret) {
+			v.Field("secrets").Field(name).Fail("mustBeSetByAnOperator")
+		}
+	}
+	if c.ServerEndpoints != nil {
+		for _, name := range validation.SortedKeys(*c.ServerEndpoints) {
+			tenantResolver := (*c.ServerEndpoints)[name].TenantResolver
+			var currentTenantResolver *tenantresolver.Config
+			if current.ServerEndpoints != nil {
+				currentTenantResolver = (*current.ServerEndpoints)[name].TenantResolver
+			}
+			if tenantResolver != nil && !reflect.DeepEqual(tenantResolver, currentTenantResolver) {
+				v.Field("serverEndpoints").Field(name).Field("tenantResolver").Fail("mustBeSetByAnOperator")
+			}
+		}
+	}
+
+	currentOrigins := make(map[endpointOrigin]bool)
+	current.endpointUrls(func(pointer *validation.Validator, u EndpointUrl) {
+		if origin, ok := u.origin(); ok {
+			currentOrigins[origin] = true
+		}
+	}, validation.NewValidator())
+	otherHosts := make(map[string]bool)
+	for otherTenantID, other := range tenants {
+		if otherTenantID != tenantID {
+			other.endpointUrls(func(pointer *validation.Validator, u EndpointUrl) {
+				if origin, ok := u.origin(); ok {
+					otherHosts[origin.host] = true
+				}
+			}, validation.NewValidator())
+		}
+	}
+	c.endpointUrls(func(pointer *validation.Validator, u EndpointUrl) {
+		origin, ok := u.origin()
+		if !ok || currentOrigins[origin] {
+			return
+		}
+		if origin.host == AnyHost {
+			pointer.Fail("anyHostMustBeSetByAnOperator")
+		} else if otherHosts[origin.host] {
+			pointer.Fail("hostMustNotBeServedByAnotherTenant")
+		} else if origin.scheme == mux.AnyScheme {
+			pointer.Fail("anySchemeMustBeSetByAnOperator")
+		}
+	}, v)
+	return v.Err()
+}
+
+// endpointOrigin the scheme and the lower case host of an EndpointUrl, mux.AnyScheme for
+// a scheme relative one.
+type endpointOrigin struct {
+	scheme string
+	host   string
+}
 
-	if c.ServerEndpoints == nil {
-		return fmt.Errorf(TRACE + " TenantConfig ServerEndpoints: required")
+// origin the endpointOrigin of u, none when it is not a valid url.
+func (u EndpointUrl) origin() (endpointOrigin, bool) {
+	uAsUrl, err := url.Parse(string(u))
+	if err != nil {
+		return endpointOrigin{}, false
 	}
-	if err := c.ServerEndpoints.Validate(); err != nil {
-		return err
+	return endpointOrigin{scheme: muxScheme(uAsUrl), host: strings.ToLower(uAsUrl.Host)}, true
+}
+
+// endpointUrls calls f with the url of every endpoint of c, and its pointer in c.
+func (c TenantConfig) endpointUrls(f func(pointer *validation.Validator, u EndpointUrl), v *validation.Validator) {
+	if c.ServerEndpoints != nil {
+		for _, name := range validation.SortedKeys(*c.ServerEndpoints) {
+			if u := (*c.ServerEndpoints)[name].Url; u != nil {
+				f(v.Field("serverEndpoints").Field(name).Field("url"), *u)
+			}
+		}
 	}
 	if c.ReverseProxyEndpoints != nil {
-		if err := c.ReverseProxyEndpoints.Validate(); err != nil {
-			return err
+		for i, endpoint := range *c.ReverseProxyEndpoints {
+			if endpoint.Url != nil {
+				f(v.Field("reverseProxyEndpoints").Index(i).Field("url"), *endpoint.Url)
+			}
 		}
 	}
 	if c.FileServerEndpoints != nil {
-		if err := c.FileServerEndpoints.Validate(); err != nil {
-			return err
+		for i, endpoint := range *c.FileServerEndpoints {
+			if endpoint.Url != nil {
+				f(v.Field("fileServerEndpoints").Index(i).Field("url"), *endpoint.Url)
+			}
+		}
+	}
+	if c.RedirectEndpoints != nil {
+		for i, endpoint := range *c.RedirectEndpoints {
+			if endpoint.Url != nil {
+				f(v.Field("redirectEndpoints").Index(i).Field("url"), *endpoint.Url)
+			}
 		}
 	}
-
-	return nil
 }
 
 type TenantsConfig map[string]TenantConfig
@@ -0,0 +1,101 @@
+package multitenancy
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/riotemergence/godynamicweb/mux"
+	"github.com/riotemergence/godynamicweb/util"
+)
+
+// RouteEndpoint identifies the tenant endpoint that owns a route.
+type RouteEndpoint struct {
+	TenantID     string       `json:"tenantId"`
+	EndpointKind EndpointKind `json:"endpointKind"`
+	EndpointName string       `json:"endpointName"`
+	Route        string       `json:"route"`
+}
+
+func newRouteEndpoint(muxEntry mux.MuxEntry[TenantRoute]) RouteEndpoint {
+	return RouteEndpoint{
+		TenantID:     muxEntry.Value.TenantID(),
+		EndpointKind: muxEntry.Value.Kind(),
+		EndpointName: muxEntry.Value.EndpointName(),
+		Route:        muxEntry.Key.String(),
+	}
+}
+
+type RouteConflict struct {
+	Kind        mux.OverlapKind `json:"kind"`
+	Candidate   RouteEndpoint   `json:"candidate"`
+	Conflicting RouteEndpoint   `json:"conflicting"`
+}
+
+func (c RouteConflict) String() string {
+	return fmt.Sprintf("%s: %s endpoint \"%s\" %s conflicts with tenant \"%s\" %s endpoint \"%s\" %s",
+		c.Kind,
+		c.Candidate.EndpointKind, c.Candidate.EndpointName, c.Candidate.Route,
+		c.Conflicting.TenantID, c.Conflicting.EndpointKind, c.Conflicting.EndpointName, c.Conflicting.Route,
+	)
+}
+
+// TenantConflictsError every route of a candidate tenant that overlaps with the
+// catalog or with another route of the same tenant.
+type TenantConflictsError struct {
+	TenantID  string          `json:"tenantId"`
+	Conflicts []RouteConflict `json:"conflicts"`
+}
+
+func (e *TenantConflictsError) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf(TRACE+" MultiTenancySupport tenant \"%s\": mustNotConflictWithExistingUrls", e.TenantID))
+	for _, conflict := range e.Conflicts {
+		buffer.WriteString("\n  ")
+		buffer.WriteString(conflict.String())
+	}
+	return buffer.String()
+}
+
+func (e *TenantConflictsError) ToJson() string {
+	return util.ToJson(e)
+}
+
+// AnalyzeTenant reports every overlap between the routes of a candidate tenant and
+// the live catalog. Routes already owned by tenantID are ignored, so a tenant can be
+// checked against its own replacement.
+func (m *MultiTenancySupport) AnalyzeTenant(tenantID string, config TenantConfig, httpMethodByServerEndpointName map[string]string) ([]RouteConflict, error) {
+	muxEntries, err := newTenantMuxEntries(tenantID, config, httpMethodByServerEndpointName, m.SecretSources().ReadKey)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeMuxEntries(muxEntries, m.MuxCatalog.Entries(), tenantID), nil
+}
+
+func analyzeMuxEntries(candidateEntries []mux.MuxEntry[TenantRoute], catalogEntries mux.MuxEntries[TenantRoute], ignoredTenantID string) []RouteConflict {
+	conflicts := make([]RouteConflict, 0)
+	for candidateIndex, candidateEntry := range candidateEntries {
+		for _, catalogEntry := range catalogEntries {
+			if ignoredTenantID != "" && catalogEntry.Value.TenantID() == ignoredTenantID {
+				continue
+			}
+			if overlap := mux.Overlap(candidateEntry.Key, catalogEntry.Key); overlap != mux.NoOverlap {
+				conflicts = append(conflicts, RouteConflict{
+					Kind:        overlap,
+					Candidate:   newRouteEndpoint(candidateEntry),
+					Conflicting: newRouteEndpoint(catalogEntry),
+				})
+			}
+		}
+
+		for _, otherCandidateEntry := range candidateEntries[candidateIndex+1:] {
+			if overlap := mux.Overlap(candidateEntry.Key, otherCandidateEntry.Key); overlap != mux.NoOverlap {
+				conflicts = append(conflicts, RouteConflict{
+					Kind:        overlap,
+					Candidate:   newRouteEndpoint(candidateEntry),
+					Conflicting: newRouteEndpoint(otherCandidateEntry),
+				})
+			}
+		}
+	}
+	return conflicts
+}
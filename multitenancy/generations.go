@@ -0,0 +1,274 @@
+package multitenancy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/mux"
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+const defaultMaxGenerations = 32
+
+// Generation a committed state of the tenants and their routes.
+type Generation struct {
+	Number      uint64        `json:"generation"`
+	Timestamp   time.Time     `json:"timestamp"`
+	Description string        `json:"description"`
+	TenantIDs   []string      `json:"tenants"`
+	Tenants     TenantsConfig `json:"-"`
+
+	certificates     tenantCertificates
+	certificateIndex *x509.CertificateIndex
+	values           tenantValues
+	handlers         tenantHandlers
+	muxEntries       mux.MuxEntries[TenantRoute]
+}
+
+func (g Generation) String() string {
+	return util.ToJson(g)
+}
+
+type Generations []Generation
+
+func (g Generations) String() string {
+	return util.ToJson(g)
+}
+
+// RoutesDiff the routes added and removed between two catalog states, and the ones
+// kept whose target changed.
+type RoutesDiff struct {
+	Added   []RouteEndpoint `json:"added"`
+	Removed []RouteEndpoint `json:"removed"`
+	Changed []RouteEndpoint `json:"changed"`
+}
+
+func (d RoutesDiff) String() string {
+	return util.ToJson(d)
+}
+
+type GenerationsDiff struct {
+	From           uint64     `json:"from"`
+	To             uint64     `json:"to"`
+	AddedTenants   []string   `json:"addedTenants"`
+	RemovedTenants []string   `json:"removedTenants"`
+	ChangedTenants []string   `json:"changedTenants"`
+	Routes         RoutesDiff `json:"routes"`
+}
+
+func (d GenerationsDiff) String() string {
+	return util.ToJson(d)
+}
+
+// clone a deep copy of the generation, so that neither the live state nor a later
+// change of it alters the history.
+func (g Generation) clone() (Generation, error) {
+	tenants, err := cloneTenants(g.Tenants)
+	if err != nil {
+		return Generation{}, err
+	}
+	cloned := g
+	cloned.TenantIDs = append([]string(nil), g.TenantIDs...)
+	cloned.Tenants = tenants
+	cloned.certificates = make(tenantCertificates, len(g.certificates))
+	for tenantID, certificates := range g.certificates {
+		cloned.certificates[tenantID] = append([]*x509.Certificate(nil), certificates...)
+	}
+	cloned.certificateIndex = g.certificateIndex.Clone()
+	cloned.values = make(tenantValues, len(g.values))
+	for tenantID, values := range g.values {
+		clonedValues := &TenantValues{
+			TenantID:  values.TenantID,
+			Variables: make(map[string]json.RawMessage, len(values.Variables)),
+			Secrets:   make(map[string]Secret, len(values.Secrets)),
+		}
+		for name, variable := range values.Variables {
+			clonedValues.Variables[name] = append(json.RawMessage(nil), variable...)
+		}
+		for name, secret := range values.Secrets {
+			clonedValues.Secrets[name] = secret
+		}
+		cloned.values[tenantID] = clonedValues
+	}
+	cloned.handlers = make(tenantHandlers, len(g.handlers))
+	for tenantID, handlers := range g.handlers {
+		clonedHandlers := make(TenantHandlers, len(handlers))
+		for endpointKey, handler := range handlers {
+			clonedHandlers[endpointKey] = handler
+		}
+		cloned.handlers[tenantID] = clonedHandlers
+	}
+	cloned.muxEntries = append(mux.MuxEntries[TenantRoute](nil), g.muxEntries...)
+	return cloned, nil
+}
+
+// cloneTenants a deep copy of tenants, through their JSON encoding.
+func cloneTenants(tenants TenantsConfig) (TenantsConfig, error) {
+	tenantsJson, err := json.Marshal(tenants)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" cloneTenants: %s", err)
+	}
+	cloned := make(TenantsConfig, len(tenants))
+	if err := json.Unmarshal(tenantsJson, &cloned); err != nil {
+		return nil, fmt.Errorf(TRACE+" cloneTenants: %s", err)
+	}
+	return cloned, nil
+}
+
+// recordGeneration numbers and timestamps a committed state and retains it. It must
+// be given a generation no one else holds, see Generation.clone.
+func (m *MultiTenancySupport) recordGeneration(generation Generation) Generation {
+	m.generationsMutex.Lock()
+	defer m.generationsMutex.Unlock()
+
+	if generationsLen := len(m.generations); generationsLen > 0 {
+		generation.Number = m.generations[generationsLen-1].Number + 1
+	}
+	generation.Timestamp = time.Now()
+	generation.TenantIDs = make([]string, 0, len(generation.Tenants))
+	for tenantID := range generation.Tenants {
+		generation.TenantIDs = append(generation.TenantIDs, tenantID)
+	}
+	sort.Strings(generation.TenantIDs)
+
+	m.generations = append(m.generations, generation)
+	if m.MaxGenerations > 0 && len(m.generations) > m.MaxGenerations {
+		m.generations = append(Generations(nil), m.generations[len(m.generations)-m.MaxGenerations:]...)
+	}
+	return generation
+}
+
+// Generations the retained generations, oldest first.
+func (m *MultiTenancySupport) Generations() Generations {
+	m.generationsMutex.RLock()
+	defer m.generationsMutex.RUnlock()
+	return append(Generations(nil), m.generations...)
+}
+
+func (m *MultiTenancySupport) GetGeneration(number uint64) (Generation, bool) {
+	m.generationsMutex.RLock()
+	defer m.generationsMutex.RUnlock()
+	for _, generation := range m.generations {
+		if generation.Number == number {
+			return generation, true
+		}
+	}
+	return Generation{}, false
+}
+
+// CurrentGeneration the generation being served.
+func (m *MultiTenancySupport) CurrentGeneration() Generation {
+	m.generationsMutex.RLock()
+	defer m.generationsMutex.RUnlock()
+	return m.generations[len(m.generations)-1]
+}
+
+func (m *MultiTenancySupport) DiffGenerations(from, to uint64) (GenerationsDiff, error) {
+	fromGeneration, found := m.GetGeneration(from)
+	if !found {
+		return GenerationsDiff{}, fmt.Errorf(TRACE+" MultiTenancySupport DiffGenerations from: mustExist \"%d\"", from)
+	}
+	toGeneration, found := m.GetGeneration(to)
+	if !found {
+		return GenerationsDiff{}, fmt.Errorf(TRACE+" MultiTenancySupport DiffGenerations to: mustExist \"%d\"", to)
+	}
+
+	diff := GenerationsDiff{
+		From:           from,
+		To:             to,
+		AddedTenants:   make([]string, 0),
+		RemovedTenants: make([]string, 0),
+		ChangedTenants: make([]string, 0),
+		Routes:         diffMuxEntries(fromGeneration.muxEntries, toGeneration.muxEntries),
+	}
+	for tenantID, toConfig := range toGeneration.Tenants {
+		fromConfig, found := fromGeneration.Tenants[tenantID]
+		if !found {
+			diff.AddedTenants = append(diff.AddedTenants, tenantID)
+		} else if !reflect.DeepEqual(fromConfig, toConfig) {
+			diff.ChangedTenants = append(diff.ChangedTenants, tenantID)
+		}
+	}
+	for tenantID := range fromGeneration.Tenants {
+		if _, found := toGeneration.Tenants[tenantID]; !found {
+			diff.RemovedTenants = append(diff.RemovedTenants, tenantID)
+		}
+	}
+	sort.Strings(diff.AddedTenants)
+	sort.Strings(diff.RemovedTenants)
+	sort.Strings(diff.ChangedTenants)
+	return diff, nil
+}
+
+// RollbackToGeneration atomically restores the tenants and routes of a retained
+// generation. The restored state is recorded as a new generation, so the rollback
+// can be rolled back too.
+func (m *MultiTenancySupport) RollbackToGeneration(number uint64) (Generation, error) {
+	generation, found := m.GetGeneration(number)
+	if !found {
+		return Generation{}, fmt.Errorf(TRACE+" MultiTenancySupport RollbackToGeneration generation: mustExist \"%d\"", number)
+	}
+
+	generation, err := generation.clone()
+	if err != nil {
+		return Generation{}, fmt.Errorf(TRACE+" MultiTenancySupport RollbackToGeneration: %s", err)
+	}
+
+	tx := m.Begin(fmt.Sprintf("rollback to generation %d", number))
+	defer tx.Rollback()
+	tx.muxTransaction.Replace(generation.muxEntries)
+	tx.tenants = generation.Tenants
+	tx.certificates = generation.certificates
+	tx.certificateIndex = generation.certificateIndex
+	tx.values = generation.values
+	tx.handlers = generation.handlers
+	if err := tx.Commit(); err != nil {
+		return Generation{}, err
+	}
+	return m.CurrentGeneration(), nil
+}
+
+func diffMuxEntries(fromEntries, toEntries mux.MuxEntries[TenantRoute]) RoutesDiff {
+	diff := RoutesDiff{
+		Added:   make([]RouteEndpoint, 0),
+		Removed: make([]RouteEndpoint, 0),
+		Changed: make([]RouteEndpoint, 0),
+	}
+	fromRoutes := make(map[RouteEndpoint]TenantRoute, len(fromEntries))
+	for _, fromEntry := range fromEntries {
+		fromRoutes[newRouteEndpoint(fromEntry)] = fromEntry.Value
+	}
+	toRoutes := make(map[RouteEndpoint]bool, len(toEntries))
+	for _, toEntry := range toEntries {
+		toRoute := newRouteEndpoint(toEntry)
+		toRoutes[toRoute] = true
+		if fromRoute, found := fromRoutes[toRoute]; !found {
+			diff.Added = append(diff.Added, toRoute)
+		} else if !sameRouteTarget(fromRoute, toEntry.Value) {
+			diff.Changed = append(diff.Changed, toRoute)
+		}
+	}
+	for _, fromEntry := range fromEntries {
+		if fromRoute := newRouteEndpoint(fromEntry); !toRoutes[fromRoute] {
+			diff.Removed = append(diff.Removed, fromRoute)
+		}
+	}
+	return diff
+}
+
+// sameRouteTarget whether two routes serve requests the same way. Resolvers are
+// functions, which never compare equal, so the configs they were built from are
+// compared instead.
+func sameRouteTarget(from, to TenantRoute) bool {
+	fromServer, fromIsServer := from.(TenantServerEndpoint)
+	toServer, toIsServer := to.(TenantServerEndpoint)
+	if fromIsServer && toIsServer {
+		fromServer.TenantResolver, toServer.TenantResolver = nil, nil
+		return reflect.DeepEqual(fromServer, toServer)
+	}
+	return reflect.DeepEqual(from, to)
+}
@@ -0,0 +1,71 @@
+package multitenancy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func tenantConfig(t *testing.T, configJson string) TenantConfig {
+	t.Helper()
+	var config TenantConfig
+	if err := json.Unmarshal([]byte(configJson), &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestRollbackDoesNotShareHistory(t *testing.T) {
+	m := NewMultiTenancySupport()
+	methods := map[string]string{"hello": "GET"}
+	config := tenantConfig(t, `{"name":"a","serverEndpoints":{"hello":{"url":"http://a.test/hello","connector":"c"}}}`)
+	if err := m.AddTenant("a", config, methods); err != nil {
+		t.Fatal(err)
+	}
+	added := m.CurrentGeneration().Number
+
+	if err := m.RemoveTenant("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RollbackToGeneration(added); err != nil {
+		t.Fatal(err)
+	}
+	// Changing the restored state must not change the generation it came from.
+	*m.Tenants()["a"].Name = "changed"
+	(*m.Tenants()["a"].ServerEndpoints)["other"] = ServerEndpointConfig{}
+	generation, _ := m.GetGeneration(added)
+	if name := *generation.Tenants["a"].Name; name != "a" {
+		t.Fatalf("generation %d changed to %q", added, name)
+	}
+	if len(*generation.Tenants["a"].ServerEndpoints) != 1 {
+		t.Fatalf("generation %d endpoints changed", added)
+	}
+}
+
+func TestReplaceTenantReportsChangedTargets(t *testing.T) {
+	m := NewMultiTenancySupport()
+	methods := map[string]string{"hello": "GET"}
+	config := func(targetUrl string) TenantConfig {
+		return tenantConfig(t, `{"name":"a",
+			"serverEndpoints":{"hello":{"url":"http://a.test/hello","connector":"c","tenantResolver":{"kind":"header"}}},
+			"reverseProxyEndpoints":[{"url":"http://a.test/api/*","connector":"c","methods":["GET"],"targetUrl":"`+targetUrl+`"}]}`)
+	}
+	if err := m.AddTenant("a", config("http://backend-1"), methods); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := m.ReplaceTenant("a", config("http://backend-1"), methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Changed) != 0 {
+		t.Fatalf("unchanged tenant reported as %s", diff)
+	}
+
+	diff, err = m.ReplaceTenant("a", config("http://backend-2"), methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Changed) != 1 || diff.Changed[0].EndpointKind != ReverseProxyEndpointKind {
+		t.Fatalf("changed target reported as %s", diff)
+	}
+}
@@ -0,0 +1,209 @@
+package multitenancy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+type TenantStatus string
+
+const (
+	TenantActive TenantStatus = "active"
+	// TenantSuspended requests are rejected with 403 Forbidden.
+	TenantSuspended TenantStatus = "suspended"
+	// TenantMaintenance requests are rejected with 503 Service Unavailable and Retry-After.
+	TenantMaintenance TenantStatus = "maintenance"
+	// TenantDraining new requests are rejected, and the tenant is removed once the
+	// requests in flight are finished.
+	TenantDraining TenantStatus = "draining"
+)
+
+func (s TenantStatus) Validate() error {
+	return validation.Validate(s)
+}
+
+func (s TenantStatus) JSONSchema() *schema.Schema {
+	return &schema.Schema{
+		Type: "string",
+		Enum: []interface{}{TenantActive, TenantSuspended, TenantMaintenance, TenantDraining},
+	}
+}
+
+func (s TenantStatus) ValidateWith(v *validation.Validator) {
+	switch s {
+	case TenantActive, TenantSuspended, TenantMaintenance, TenantDraining:
+		return
+	}
+	v.Failf("mustBeKnownStatus", "%q", string(s))
+}
+
+type TenantStateConfig struct {
+	Status TenantStatus `json:"status"`
+	// RetryAfter seconds sent in the Retry-After header while in maintenance.
+	RetryAfter *int `json:"retryAfter,omitempty"`
+	// Body replaces the default response body while suspended or in maintenance.
+	Body        *string `json:"body,omitempty"`
+	ContentType *string `json:"contentType,omitempty"`
+	// DrainTimeout seconds to wait for requests in flight before removing a draining
+	// tenant anyway. Waits forever when absent.
+	DrainTimeout *int `json:"drainTimeout,omitempty"`
+}
+
+func (c TenantStateConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c TenantStateConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("retryAfter").Minimum = schema.Float(0)
+	s.Property("drainTimeout").Minimum = schema.Float(0)
+}
+
+func (c TenantStateConfig) ValidateWith(v *validation.Validator) {
+	v.Field("status").Check(c.Status)
+	if c.RetryAfter != nil && *c.RetryAfter < 0 {
+		v.Field("retryAfter").Fail("mustNotBeNegative")
+	}
+	if c.DrainTimeout != nil && *c.DrainTimeout < 0 {
+		v.Field("drainTimeout").Fail("mustNotBeNegative")
+	}
+}
+
+type TenantState struct {
+	TenantStateConfig
+	InFlight int `json:"inFlight"`
+}
+
+func (s TenantState) String() string {
+	return util.ToJson(s)
+}
+
+type tenantState struct {
+	config   TenantStateConfig
+	inFlight int
+	idle     chan struct{}
+}
+
+type tenantStates struct {
+	mutex  sync.Mutex
+	states map[string]*tenantState
+}
+
+// get the state of a tenant, creating it as active. The caller must hold the mutex.
+func (s *tenantStates) get(tenantID string) *tenantState {
+	if s.states == nil {
+		s.states = make(map[string]*tenantState)
+	}
+	state, found := s.states[tenantID]
+	if !found {
+		state = &tenantState{
+			config: TenantStateConfig{Status: TenantActive},
+		}
+		s.states[tenantID] = state
+	}
+	return state
+}
+
+// prune forgets the states of the tenants that no longer exist.
+func (s *tenantStates) prune(tenants TenantsConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for tenantID := range s.states {
+		if _, found := tenants[tenantID]; !found {
+			delete(s.states, tenantID)
+		}
+	}
+}
+
+func (m *MultiTenancySupport) GetTenantState(tenantID string) (TenantState, bool) {
+	if _, found := m.Tenants()[tenantID]; !found {
+		return TenantState{}, false
+	}
+	m.tenantStates.mutex.Lock()
+	defer m.tenantStates.mutex.Unlock()
+	state := m.tenantStates.get(tenantID)
+	return TenantState{
+		TenantStateConfig: state.config,
+		InFlight:          state.inFlight,
+	}, true
+}
+
+// SetTenantState moves a tenant to another status, and returns the status it had. A
+// draining tenant can not leave that status, it is only waiting to be removed.
+func (m *MultiTenancySupport) SetTenantState(tenantID string, config TenantStateConfig) (TenantStatus, error) {
+	if _, found := m.Tenants()[tenantID]; !found {
+		return "", fmt.Errorf(TRACE+" MultiTenancySupport SetTenantState tenantID: mustExists \"%s\"", tenantID)
+	}
+	if err := config.Validate(); err != nil {
+		return "", fmt.Errorf(TRACE+" MultiTenancySupport SetTenantState config: %w", err)
+	}
+
+	m.tenantStates.mutex.Lock()
+	defer m.tenantStates.mutex.Unlock()
+	state := m.tenantStates.get(tenantID)
+	previousStatus := state.config.Status
+	if previousStatus == TenantDraining && config.Status != TenantDraining {
+		return previousStatus, fmt.Errorf(TRACE+" MultiTenancySupport SetTenantState tenantID: mustNotBeDraining \"%s\"", tenantID)
+	}
+	state.config = config
+	return previousStatus, nil
+}
+
+// AdmitRequest registers a request for an active tenant. When admitted, release must
+// be called once the request is finished. Otherwise the state tells why it was not.
+func (m *MultiTenancySupport) AdmitRequest(tenantID string) (state TenantStateConfig, release func(), admitted bool) {
+	m.tenantStates.mutex.Lock()
+	defer m.tenantStates.mutex.Unlock()
+	tenantState := m.tenantStates.get(tenantID)
+	if tenantState.config.Status != TenantActive {
+		return tenantState.config, nil, false
+	}
+
+	tenantState.inFlight++
+	released := false
+	release = func() {
+		m.tenantStates.mutex.Lock()
+		defer m.tenantStates.mutex.Unlock()
+		if released {
+			return
+		}
+		released = true
+		tenantState.inFlight--
+		if tenantState.inFlight == 0 && tenantState.idle != nil {
+			close(tenantState.idle)
+			tenantState.idle = nil
+		}
+	}
+	return tenantState.config, release, true
+}
+
+// WaitForIdleTenant blocks until the tenant has no requests in flight, or until the
+// timeout when it is positive. It reports whether the tenant became idle.
+func (m *MultiTenancySupport) WaitForIdleTenant(tenantID string, timeout time.Duration) bool {
+	m.tenantStates.mutex.Lock()
+	tenantState := m.tenantStates.get(tenantID)
+	if tenantState.inFlight == 0 {
+		m.tenantStates.mutex.Unlock()
+		return true
+	}
+	if tenantState.idle == nil {
+		tenantState.idle = make(chan struct{})
+	}
+	idle := tenantState.idle
+	m.tenantStates.mutex.Unlock()
+
+	if timeout <= 0 {
+		<-idle
+		return true
+	}
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
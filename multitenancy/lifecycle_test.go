@@ -0,0 +1,38 @@
+package multitenancy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetTenantStateStartsDrainingOnce(t *testing.T) {
+	m := NewMultiTenancySupport()
+	config := tenantConfig(t, `{"name":"a","serverEndpoints":{"hello":{"url":"http://a.test/hello","connector":"c"}}}`)
+	if err := m.AddTenant("a", config, map[string]string{"hello": "GET"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var started atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			previousStatus, err := m.SetTenantState("a", TenantStateConfig{Status: TenantDraining})
+			if err != nil {
+				t.Error(err)
+			}
+			if previousStatus != TenantDraining {
+				started.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if started.Load() != 1 {
+		t.Fatalf("draining started %d times", started.Load())
+	}
+	if _, err := m.SetTenantState("a", TenantStateConfig{Status: TenantActive}); err == nil {
+		t.Fatal("a draining tenant must not become active")
+	}
+}
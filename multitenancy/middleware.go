@@ -0,0 +1,172 @@
+package multitenancy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/riotemergence/godynamicweb/middleware"
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// MiddlewareConfig a built-in middleware, referenced by name, and its params. The
+// params that are secrets, such as tokens, name secrets of the tenant.
+type MiddlewareConfig struct {
+	Name   *string         `json:"name"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+func (c MiddlewareConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c MiddlewareConfig) RefineJSONSchema(s *schema.Schema) {
+	names := middleware.Names()
+	s.Property("name").Enum = make([]interface{}, len(names))
+	for i, name := range names {
+		s.Property("name").Enum[i] = name
+	}
+	s.Properties["params"] = &schema.Schema{Type: []string{"object", "null"}}
+}
+
+// ValidateWith checks the params without the secrets of the tenant, which
+// TenantConfig checks are declared.
+func (c MiddlewareConfig) ValidateWith(v *validation.Validator) {
+	c.validateWith(v, func(string) (string, bool) {
+		return "", true
+	})
+}
+
+func (c MiddlewareConfig) validateWith(v *validation.Validator, secrets middleware.Secrets) {
+	if !v.Required("name", c.Name != nil) {
+		return
+	}
+	if !middleware.Registered(*c.Name) {
+		v.Field("name").Failf("mustBeRegisteredMiddleware", "%q", *c.Name)
+		return
+	}
+	if _, err := middleware.New(*c.Name, c.Params, secrets); err != nil {
+		v.Field("params").Add(err)
+	}
+}
+
+// MiddlewareConfigs a chain of middleware: the first one sees the request first.
+type MiddlewareConfigs []MiddlewareConfig
+
+func (c MiddlewareConfigs) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c MiddlewareConfigs) ValidateWith(v *validation.Validator) {
+	for i, config := range c {
+		v.Index(i).Check(config)
+	}
+}
+
+func (c MiddlewareConfigs) validateWith(v *validation.Validator, secrets middleware.Secrets) {
+	for i, config := range c {
+		config.validateWith(v.Index(i), secrets)
+	}
+}
+
+// build the chain of c, whose secrets are looked up in secrets.
+func (c MiddlewareConfigs) build(secrets middleware.Secrets) (middleware.Middleware, error) {
+	chain := make([]middleware.Middleware, 0, len(c))
+	for _, config := range c {
+		m, err := middleware.New(*config.Name, config.Params, secrets)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, m)
+	}
+	return middleware.Chain(chain...), nil
+}
+
+// middlewareByEndpoint calls f with the middleware of every endpoint of config, by
+// EndpointKey, and its pointer in config.
+func (c TenantConfig) middlewareByEndpoint(f func(endpointKey string, pointer *validation.Validator, configs MiddlewareConfigs), v *validation.Validator) {
+	if c.ServerEndpoints != nil {
+		for _, name := range validation.SortedKeys(*c.ServerEndpoints) {
+			f(string(ServerEndpointKind)+"/"+name, v.Field("serverEndpoints").Field(name).Field("middleware"), (*c.ServerEndpoints)[name].Middleware)
+		}
+	}
+	if c.ReverseProxyEndpoints != nil {
+		for i, endpoint := range *c.ReverseProxyEndpoints {
+			f(string(ReverseProxyEndpointKind)+"/"+strconv.Itoa(i), v.Field("reverseProxyEndpoints").Index(i).Field("middleware"), endpoint.Middleware)
+		}
+	}
+	if c.FileServerEndpoints != nil {
+		for i, endpoint := range *c.FileServerEndpoints {
+			f(string(FileServerEndpointKind)+"/"+strconv.Itoa(i), v.Field("fileServerEndpoints").Index(i).Field("middleware"), endpoint.Middleware)
+		}
+	}
+	if c.RedirectEndpoints != nil {
+		for i, endpoint := range *c.RedirectEndpoints {
+			f(string(RedirectEndpointKind)+"/"+strconv.Itoa(i), v.Field("redirectEndpoints").Index(i).Field("middleware"), endpoint.Middleware)
+		}
+	}
+}
+
+// validateMiddleware checks the middleware of the tenant and of its endpoints, and
+// that the secrets they name are declared.
+func (c TenantConfig) validateMiddleware(v *validation.Validator) {
+	declared := func(name string) (string, bool) {
+		_, found := c.Secrets[name]
+		return "", found
+	}
+	c.Middleware.validateWith(v.Field("middleware"), declared)
+	c.middlewareByEndpoint(func(endpointKey string, pointer *validation.Validator, configs MiddlewareConfigs) {
+		configs.validateWith(pointer, declared)
+	}, v)
+}
+
+// TenantHandlers the handler of every endpoint of a tenant, by EndpointKey: the
+// EndpointHandler behind the middleware of the tenant, then the ones of the endpoint.
+// They are composed once, when the tenant is added, rather than on every request.
+type TenantHandlers map[string]http.Handler
+
+// tenantHandlerKey the key of the EndpointHandler behind the middleware of the tenant
+// alone, for the endpoints of other tenants that resolve to it.
+const tenantHandlerKey = ""
+
+func newTenantHandlers(config TenantConfig, values *TenantValues, endpointHandler http.Handler) (TenantHandlers, error) {
+	tenantChain, err := config.Middleware.build(values.Secret)
+	if err != nil {
+		return nil, err
+	}
+	handlers := TenantHandlers{tenantHandlerKey: tenantChain(endpointHandler)}
+	var buildErr error
+	config.middlewareByEndpoint(func(endpointKey string, pointer *validation.Validator, configs MiddlewareConfigs) {
+		endpointChain, err := configs.build(values.Secret)
+		if err != nil {
+			buildErr = err
+			return
+		}
+		handlers[endpointKey] = middleware.Chain(tenantChain, endpointChain)(endpointHandler)
+	}, validation.NewValidator())
+	if buildErr != nil {
+		return nil, buildErr
+	}
+	return handlers, nil
+}
+
+// tenantHandlers the endpoint handlers of every tenant.
+type tenantHandlers map[string]TenantHandlers
+
+// RouteHandler the handler of a route for the requests of tenantID, as of the last
+// commit. That is the tenant of the route, unless a tenant resolver found another
+// one: then the handler of its own endpoint of the same key serves them or, when it
+// has none, the middleware of the tenant alone.
+func (m *MultiTenancySupport) RouteHandler(tenantID string, route TenantRoute) http.Handler {
+	return m.Snapshot().RouteHandler(tenantID, route)
+}
+
+// endpointHandler the EndpointHandler, or one that answers 404 Not Found when there
+// is none.
+func (m *MultiTenancySupport) endpointHandler() http.Handler {
+	if m.EndpointHandler == nil {
+		return http.NotFoundHandler()
+	}
+	return m.EndpointHandler
+}
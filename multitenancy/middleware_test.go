@@ -0,0 +1,41 @@
+package multitenancy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/middleware"
+)
+
+func TestRouteHandlerIsComposedOnce(t *testing.T) {
+	wraps := 0
+	middleware.Register("countWraps", func(params json.RawMessage, secrets middleware.Secrets) (middleware.Middleware, error) {
+		return func(next http.Handler) http.Handler {
+			wraps++
+			return next
+		}, nil
+	})
+	served := 0
+	m := NewMultiTenancySupport()
+	m.EndpointHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	})
+	config := tenantConfig(t, `{"name":"a","serverEndpoints":{"hello":{"url":"http://a.test/hello","connector":"c","middleware":[{"name":"countWraps"}]}}}`)
+	if err := m.AddTenant("a", config, map[string]string{"hello": "GET"}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("GET", "http://a.test/hello", nil)
+	route, found := m.GetTenantRoute("c", request)
+	if !found {
+		t.Fatal("route not found")
+	}
+	for i := 0; i < 3; i++ {
+		m.RouteHandler("a", route).ServeHTTP(httptest.NewRecorder(), request)
+	}
+	if wraps != 1 || served != 3 {
+		t.Fatalf("wrapped %d times, served %d requests", wraps, served)
+	}
+}
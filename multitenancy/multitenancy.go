@@ -4,246 +4,612 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
-
-	"crypto/tls"
-	"crypto/x509"
+	"sync"
+	"sync/atomic"
 
 	"github.com/riotemergence/godynamicweb/mux"
+	"github.com/riotemergence/godynamicweb/tenantresolver"
+	"github.com/riotemergence/godynamicweb/validation"
+	"github.com/riotemergence/godynamicweb/x509"
 )
 
 const TRACE = "github.com/riotemergence/godynamicweb/multitenancy"
 
+type EndpointKind string
+
+const (
+	ServerEndpointKind       EndpointKind = "server"
+	ReverseProxyEndpointKind EndpointKind = "proxy"
+	FileServerEndpointKind   EndpointKind = "fileserver"
+	RedirectEndpointKind     EndpointKind = "redirect"
+)
+
+// TenantRoute the value stored in the MuxCatalog for every endpoint kind. Endpoints
+// configured as lists are named after their index.
+type TenantRoute interface {
+	TenantID() string
+	Kind() EndpointKind
+	EndpointName() string
+	Accept(visitor TenantRouteVisitor)
+}
+
+// TenantRouteVisitor must be implemented by anything that serves tenant routes,
+// so a new endpoint kind fails to compile until every server handles it.
+type TenantRouteVisitor interface {
+	VisitServerEndpoint(TenantServerEndpoint)
+	VisitReverseProxyEndpoint(TenantReverseProxyEndpoint)
+	VisitFileServerEndpoint(TenantFileServerEndpoint)
+	VisitRedirectEndpoint(TenantRedirectEndpoint)
+}
+
 type TenantServerEndpoint struct {
-	TenantID           string
+	Tenant             string
 	ServerEndpointName string
+	// TenantResolver the resolver of the endpoint, nil when it has none.
+	TenantResolver tenantresolver.Resolver
+	// TenantResolverConfig the config TenantResolver was built from.
+	TenantResolverConfig *tenantresolver.Config
+}
+
+func (e TenantServerEndpoint) TenantID() string {
+	return e.Tenant
+}
+
+func (e TenantServerEndpoint) Kind() EndpointKind {
+	return ServerEndpointKind
+}
+
+func (e TenantServerEndpoint) EndpointName() string {
+	return e.ServerEndpointName
+}
+
+func (e TenantServerEndpoint) Accept(visitor TenantRouteVisitor) {
+	visitor.VisitServerEndpoint(e)
 }
 
 type TenantReverseProxyEndpoint struct {
-	TenantID    string
+	Tenant      string
+	Index       string
 	StripPrefix string
 	TargetUrl   *url.URL
 }
 
+func (e TenantReverseProxyEndpoint) TenantID() string {
+	return e.Tenant
+}
+
+func (e TenantReverseProxyEndpoint) Kind() EndpointKind {
+	return ReverseProxyEndpointKind
+}
+
+func (e TenantReverseProxyEndpoint) EndpointName() string {
+	return e.Index
+}
+
+func (e TenantReverseProxyEndpoint) Accept(visitor TenantRouteVisitor) {
+	visitor.VisitReverseProxyEndpoint(e)
+}
+
 type TenantFileServerEndpoint struct {
-	TenantID    string
+	Tenant      string
+	Index       string
 	StripPrefix string
 	RootFs      string
 	DirListing  bool
 }
 
+func (e TenantFileServerEndpoint) TenantID() string {
+	return e.Tenant
+}
+
+func (e TenantFileServerEndpoint) Kind() EndpointKind {
+	return FileServerEndpointKind
+}
+
+func (e TenantFileServerEndpoint) EndpointName() string {
+	return e.Index
+}
+
+func (e TenantFileServerEndpoint) Accept(visitor TenantRouteVisitor) {
+	visitor.VisitFileServerEndpoint(e)
+}
+
+type TenantRedirectEndpoint struct {
+	Tenant string
+	Index  string
+	// SourcePath the path of the endpoint URL, the request path is captured with.
+	SourcePath    mux.PathParts
+	Target        string
+	Status        int
+	PreserveQuery bool
+}
+
+func (e TenantRedirectEndpoint) TenantID() string {
+	return e.Tenant
+}
+
+func (e TenantRedirectEndpoint) Kind() EndpointKind {
+	return RedirectEndpointKind
+}
+
+func (e TenantRedirectEndpoint) EndpointName() string {
+	return e.Index
+}
+
+func (e TenantRedirectEndpoint) Accept(visitor TenantRouteVisitor) {
+	visitor.VisitRedirectEndpoint(e)
+}
+
+// EndpointKey identifies an endpoint within a tenant, as "<kind>/<name>".
+func EndpointKey(route TenantRoute) string {
+	return string(route.Kind()) + "/" + route.EndpointName()
+}
+
+func ValidateEndpointKey(endpointKey string) error {
+	kind, name, found := strings.Cut(endpointKey, "/")
+	if !found || name == "" {
+		return validation.Invalid("mustBeKindSlashName")
+	}
+	switch EndpointKind(kind) {
+	case ServerEndpointKind, ReverseProxyEndpointKind, FileServerEndpointKind, RedirectEndpointKind:
+		return nil
+	}
+	return validation.Invalidf("mustBeKnownKind", "%q", kind)
+}
+
+type TenantMuxCatalog = mux.MuxCatalog[TenantRoute]
+
 type MultiTenancySupport struct {
-	Config     MultiTenancyConfig
-	MuxCatalog *mux.MuxCatalog
-	//	x509Certificates            []tls.Certificate
-	x509CertificateBySubjectName map[string]tls.Certificate
+	MuxCatalog *TenantMuxCatalog
+	// snapshot the *Snapshot of the last commit, replaced as a whole.
+	snapshot atomic.Value
+	// secretSources the SecretSources the secrets of tenants are resolved from.
+	secretSources atomic.Value
+	// MaxGenerations how many committed generations are kept for rollback.
+	MaxGenerations   int
+	generationsMutex sync.RWMutex
+	generations      []Generation
+	tenantStates     tenantStates
+	// BeforeCommit when set, is given the tenants before and after a transaction,
+	// which fails without any change visible if it returns an error.
+	BeforeCommit func(before, after TenantsConfig) error
+	// AfterCommit when set, is given the tenants once a transaction is visible, so
+	// that state kept by tenant elsewhere follows them.
+	AfterCommit func(tenants TenantsConfig)
+	// CertificateConflicts when set, reports the names of a tenant certificate that
+	// are taken elsewhere, which fails the transaction. It is called with the tenants
+	// locked, see Locked.
+	CertificateConflicts func(certificate *x509.Certificate) []string
+	// EndpointHandler serves the requests the middleware of a tenant endpoint lets
+	// through. It is set before any tenant is added, as the handlers of the
+	// endpoints are composed with it then, see RouteHandler.
+	EndpointHandler http.Handler
 }
 
 func NewMultiTenancySupport() *MultiTenancySupport {
 	multiTenancy := &MultiTenancySupport{
-		Config: MultiTenancyConfig{
-			Tenants: make(TenantsConfig),
-		},
-		MuxCatalog: mux.NewMuxCatalog(),
+		MuxCatalog:     mux.NewMuxCatalog[TenantRoute](),
+		MaxGenerations: defaultMaxGenerations,
 	}
+	multiTenancy.snapshot.Store(&Snapshot{
+		tenants:          make(TenantsConfig),
+		certificateIndex: x509.NewCertificateIndex(),
+		values:           make(tenantValues),
+		handlers:         make(tenantHandlers),
+		muxEntries:       multiTenancy.MuxCatalog.Entries(),
+		endpointHandler:  multiTenancy.endpointHandler(),
+	})
+	multiTenancy.secretSources.Store(SecretSources{})
+	multiTenancy.recordGeneration(Generation{
+		Description:      "initial",
+		Tenants:          make(TenantsConfig),
+		certificates:     make(tenantCertificates),
+		certificateIndex: x509.NewCertificateIndex(),
+		values:           make(tenantValues),
+		handlers:         make(tenantHandlers),
+		muxEntries:       multiTenancy.MuxCatalog.Entries(),
+	})
 	return multiTenancy
 }
 
-//TODO Check if connector use tls if https url is used
+// Tenants the tenants as of the last commit. It must not be modified.
+func (m *MultiTenancySupport) Tenants() TenantsConfig {
+	return m.Snapshot().Tenants()
+}
+
+// Locked runs fn while no transaction can change the tenants, so that fn can check
+// them and change what depends on them at once.
+func (m *MultiTenancySupport) Locked(fn func() error) error {
+	muxTransaction := m.MuxCatalog.Begin()
+	defer muxTransaction.Rollback()
+	return fn()
+}
+
+// Begin starts a transaction that groups several tenant changes. None of them is
+// visible until Commit, and a failed change makes the whole transaction fail.
+// The description is recorded in the generation created by Commit.
+func (m *MultiTenancySupport) Begin(description string) *TenantsTransaction {
+	muxTransaction := m.MuxCatalog.Begin()
+	tenants := make(TenantsConfig, len(m.Tenants()))
+	for k, v := range m.Tenants() {
+		tenants[k] = v
+	}
+	current := m.CurrentGeneration()
+	certificates := make(tenantCertificates, len(current.certificates))
+	for k, v := range current.certificates {
+		certificates[k] = v
+	}
+	values := make(tenantValues, len(current.values))
+	for k, v := range current.values {
+		values[k] = v
+	}
+	handlers := make(tenantHandlers, len(current.handlers))
+	for k, v := range current.handlers {
+		handlers[k] = v
+	}
+	return &TenantsTransaction{
+		multiTenancySupport: m,
+		muxTransaction:      muxTransaction,
+		tenants:             tenants,
+		certificates:        certificates,
+		certificateIndex:    m.CertificateIndex().Clone(),
+		values:              values,
+		handlers:            handlers,
+		description:         description,
+	}
+}
+
 func (m *MultiTenancySupport) AddTenant(tenantID string, config TenantConfig, httpMethodByServerEndpointName map[string]string) error {
+	tx := m.Begin(fmt.Sprintf("add tenant \"%s\"", tenantID))
+	defer tx.Rollback()
+	if err := tx.AddTenant(tenantID, config, httpMethodByServerEndpointName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceTenant swaps the routes of an existing tenant for the ones of config in a
+// single commit, so requests see either the old routes or the new ones.
+func (m *MultiTenancySupport) ReplaceTenant(tenantID string, config TenantConfig, httpMethodByServerEndpointName map[string]string) (RoutesDiff, error) {
+	tx := m.Begin(fmt.Sprintf("replace tenant \"%s\"", tenantID))
+	defer tx.Rollback()
+	before := tx.muxTransaction.Entries()
+	before = append(before[:0:0], before...)
+	if err := tx.ReplaceTenant(tenantID, config, httpMethodByServerEndpointName); err != nil {
+		return RoutesDiff{}, err
+	}
+	diff := diffMuxEntries(before, tx.muxTransaction.Entries())
+	if err := tx.Commit(); err != nil {
+		return RoutesDiff{}, err
+	}
+	return diff, nil
+}
+
+func (m *MultiTenancySupport) RemoveTenant(tenantID string) error {
+	tx := m.Begin(fmt.Sprintf("remove tenant \"%s\"", tenantID))
+	defer tx.Rollback()
+	if err := tx.RemoveTenant(tenantID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type TenantsTransaction struct {
+	multiTenancySupport *MultiTenancySupport
+	muxTransaction      *mux.MuxCatalogTransaction[TenantRoute]
+	tenants             TenantsConfig
+	certificates        tenantCertificates
+	certificateIndex    *x509.CertificateIndex
+	values              tenantValues
+	handlers            tenantHandlers
+	description         string
+	err                 error
+	done                bool
+}
+
+// tenantCertificates the loaded X509 certificates of every tenant.
+type tenantCertificates map[string][]*x509.Certificate
+
+//TODO Check if connector use tls if https url is used
+func (t *TenantsTransaction) AddTenant(tenantID string, config TenantConfig, httpMethodByServerEndpointName map[string]string) error {
+	if t.err != nil {
+		return t.err
+	}
+	if err := t.addTenant(tenantID, config, httpMethodByServerEndpointName); err != nil {
+		t.err = err
+		return err
+	}
+	return nil
+}
+
+func (t *TenantsTransaction) addTenant(tenantID string, config TenantConfig, httpMethodByServerEndpointName map[string]string) error {
 	if tenantID == "" {
 		return fmt.Errorf(TRACE + " MultiTenancySupport AddTenant tenantID: mustNotBeEmpty")
 	}
 
-	if err := config.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config: %s", err)
+	if _, found := t.tenants[tenantID]; found {
+		return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant tenantID: mustNotExist \"%s\"", tenantID)
 	}
 
-	if httpMethodByServerEndpointName == nil {
-		return fmt.Errorf(TRACE + " MultiTenancySupport AddTenant httpMethodByServerEndpointName: mustNotBeEmpty")
+	muxEntries, err := newTenantMuxEntries(tenantID, config, httpMethodByServerEndpointName, t.multiTenancySupport.SecretSources().ReadKey)
+	if err != nil {
+		return err
 	}
 
-	if _, found := m.Config.Tenants[tenantID]; found {
-		return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant tenantID: mustNotExist \"%s\"", tenantID)
+	if conflicts := analyzeMuxEntries(muxEntries, t.muxTransaction.Entries(), ""); len(conflicts) > 0 {
+		return &TenantConflictsError{
+			TenantID:  tenantID,
+			Conflicts: conflicts,
+		}
 	}
 
-	if config.X509 != nil {
-		for _, x509 := range config.X509 {
-			if err := x509.Validate(); err != nil {
-				return err
-			}
+	certificates := make([]*x509.Certificate, 0, len(config.X509))
+	for index, x509Config := range config.X509 {
+		certificate, err := x509Config.Load()
+		if err != nil {
+			return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config X509 \"%d\": %s", index, err)
 		}
+		if err := t.certificateIndex.Add(certificate); err != nil {
+			return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config X509 \"%d\": %s", index, err)
+		}
+		certificates = append(certificates, certificate)
 	}
 
-	certificateChainAndPrivateKey, err := tls.X509KeyPair(certificateChainBytes, privateKeyBytes)
+	values, err := newTenantValues(tenantID, config, t.multiTenancySupport.SecretSources())
 	if err != nil {
 		return err
 	}
 
-	certificateBytes := certificateChainAndPrivateKey.Certificate[0]
-	x509Certificate, err := x509.ParseCertificate(certificateBytes)
+	handlers, err := newTenantHandlers(config, values, t.multiTenancySupport.endpointHandler())
 	if err != nil {
+		return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config middleware: %w", err)
+	}
+
+	for _, muxEntry := range muxEntries {
+		if err := t.muxTransaction.AddEntry(muxEntry); err != nil {
+			return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config url : mustNotConflictWithExistingUrl \"%s\"", muxEntry.Key.String())
+		}
+	}
+
+	t.tenants[tenantID] = config
+	t.certificates[tenantID] = certificates
+	t.values[tenantID] = values
+	t.handlers[tenantID] = handlers
+	return nil
+}
+
+func (t *TenantsTransaction) ReplaceTenant(tenantID string, config TenantConfig, httpMethodByServerEndpointName map[string]string) error {
+	if err := t.RemoveTenant(tenantID); err != nil {
 		return err
 	}
+	return t.AddTenant(tenantID, config, httpMethodByServerEndpointName)
+}
 
-	//config.X509.
+func (t *TenantsTransaction) RemoveTenant(tenantID string) error {
+	if t.err != nil {
+		return t.err
+	}
+	if _, ok := t.tenants[tenantID]; !ok {
+		t.err = fmt.Errorf(TRACE+" MultiTenancySupport RemoveTenant tenantID: mustExists \"%s\"", tenantID)
+		return t.err
+	}
 
-	if _, ok := m.x509CertificateBySubjectName(commonName); ok {
-		return fmt.Errorf(TRACE + " WebApp AddX509Certificate: CertificateSubjectCommonNameMustBeUnique")
+	removeWhen := func(muxEntry mux.MuxEntry[TenantRoute]) bool {
+		return muxEntry.Value.TenantID() == tenantID
+	}
+	t.muxTransaction.RemoveAll(removeWhen)
+	for _, certificate := range t.certificates[tenantID] {
+		t.certificateIndex.Remove(certificate)
 	}
+	delete(t.tenants, tenantID)
+	delete(t.certificates, tenantID)
+	delete(t.values, tenantID)
+	delete(t.handlers, tenantID)
 
-	for _, subjectAlternativeName := range x509Certificate.DNSNames {
-		if len(subjectAlternativeName) == 0 {
-			return fmt.Errorf(TRACE + " WebApp AddX509Certificate: certificateChainBytes CertificateSubjectAlternativeNameMustNotBeEmpty")
-		}
-		if _, ok := webApp.x509CertificateBySubjectName[subjectAlternativeName]; ok {
-			return fmt.Errorf(TRACE + " WebApp AddX509Certificate: CertificateSubjectAlternativeNameMustBeUnique")
+	return nil
+}
+
+// Commit makes every change of the transaction visible at once, or none of them
+// if any change failed.
+func (t *TenantsTransaction) Commit() error {
+	if t.done {
+		return fmt.Errorf(TRACE + " TenantsTransaction Commit: mustNotBeFinished")
+	}
+	if t.err != nil {
+		t.Rollback()
+		return fmt.Errorf(TRACE+" TenantsTransaction Commit: %s", t.err)
+	}
+	m := t.multiTenancySupport
+	if certificateConflicts := m.CertificateConflicts; certificateConflicts != nil {
+		for _, certificate := range t.certificateIndex.Certificates() {
+			if conflicts := certificateConflicts(certificate); len(conflicts) > 0 {
+				t.Rollback()
+				return fmt.Errorf(TRACE+" TenantsTransaction Commit: certificateSubjectNameMustNotBeUsedByWebApp %q", conflicts)
+			}
 		}
-		if _, ok := webApp.multiTenancySupport.GetCertificateChainAndPrivateKeyBySubjectName(subjectAlternativeName); ok {
-			return fmt.Errorf(TRACE + " WebApp AddX509Certificate: CertificateSubjectCommonNameMustBeUnique")
+	}
+	if beforeCommit := m.BeforeCommit; beforeCommit != nil {
+		if err := beforeCommit(m.Tenants(), t.tenants); err != nil {
+			t.Rollback()
+			return fmt.Errorf(TRACE+" TenantsTransaction Commit: %s", err)
 		}
 	}
+	generation, err := Generation{
+		Description:      t.description,
+		Tenants:          t.tenants,
+		certificates:     t.certificates,
+		certificateIndex: t.certificateIndex,
+		values:           t.values,
+		handlers:         t.handlers,
+		muxEntries:       t.muxTransaction.Entries(),
+	}.clone()
+	if err != nil {
+		t.Rollback()
+		return fmt.Errorf(TRACE+" TenantsTransaction Commit: %s", err)
+	}
+	// The snapshot is published while the catalog is still locked, so the next
+	// transaction begins from this one. Requests route with its entries rather than
+	// with those of the catalog, which follow.
+	t.done = true
+	m.snapshot.Store(&Snapshot{
+		tenants:          t.tenants,
+		certificateIndex: t.certificateIndex,
+		values:           t.values,
+		handlers:         t.handlers,
+		muxEntries:       t.muxTransaction.Entries(),
+		endpointHandler:  m.endpointHandler(),
+	})
+	m.tenantStates.prune(t.tenants)
+	m.recordGeneration(generation)
+	if err := t.muxTransaction.Commit(); err != nil {
+		return err
+	}
+	if m.AfterCommit != nil {
+		m.AfterCommit(t.tenants)
+	}
+	return nil
+}
+
+// Rollback discards the transaction. It is a no-op after Commit, so it can be deferred.
+func (t *TenantsTransaction) Rollback() {
+	t.done = true
+	t.muxTransaction.Rollback()
+}
+
+// GetTenantRoute the route of a request, as of the last commit. A request that also
+// needs the handler or the values of its route takes them from the same Snapshot.
+func (m *MultiTenancySupport) GetTenantRoute(connectorName string, r *http.Request) (TenantRoute, bool) {
+	return m.Snapshot().GetTenantRoute(connectorName, r)
+}
+
+// CertificateIndex the certificates of every tenant, as of the last commit.
+func (m *MultiTenancySupport) CertificateIndex() *x509.CertificateIndex {
+	return m.Snapshot().certificateIndex
+}
+
+// newTenantMuxEntries the routes of a tenant, whose resolvers read their keys with keys.
+func newTenantMuxEntries(tenantID string, config TenantConfig, httpMethodByServerEndpointName map[string]string, keys tenantresolver.Keys) ([]mux.MuxEntry[TenantRoute], error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config: %w", err)
+	}
 
-	TempMuxCatalog := *m.MuxCatalog
+	if httpMethodByServerEndpointName == nil {
+		return nil, fmt.Errorf(TRACE + " MultiTenancySupport AddTenant httpMethodByServerEndpointName: mustNotBeEmpty")
+	}
+
+	muxEntries := make([]mux.MuxEntry[TenantRoute], 0)
+	addMuxEntry := func(connector string, u *url.URL, method string, route TenantRoute) {
+		muxEntries = append(muxEntries, mux.MuxEntry[TenantRoute]{
+			Key:   mux.NewMuxKey(connector, muxScheme(u), u.Host, u.Path, method),
+			Value: route,
+		})
+	}
 
 	for serverEndpointName, serverEndpointValue := range *config.ServerEndpoints {
 		serverEndpointURL, err := url.Parse(string(*serverEndpointValue.Url))
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		httpMethod, found := httpMethodByServerEndpointName[serverEndpointName]
 		if !found {
-			return fmt.Errorf(TRACE + " MultiTenancySupport AddTenant config ServerEndpoints \"%s\" : mustExistsInHttpMethodByServerEndpointName")
+			return nil, fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config ServerEndpoints \"%s\" : mustExistsInHttpMethodByServerEndpointName", serverEndpointName)
 		}
 
-		err = TempMuxCatalog.Add(
-			*serverEndpointValue.Connector,
-			serverEndpointURL.Scheme,
-			serverEndpointURL.Host,
-			serverEndpointURL.Path,
-			httpMethod,
+		var tenantResolver tenantresolver.Resolver
+		if serverEndpointValue.TenantResolver != nil {
+			if tenantResolver, err = serverEndpointValue.TenantResolver.Build(keys); err != nil {
+				return nil, fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config ServerEndpoints \"%s\" tenantResolver: %w", serverEndpointName, err)
+			}
+		}
+
+		addMuxEntry(*serverEndpointValue.Connector, serverEndpointURL, httpMethod,
 			TenantServerEndpoint{
 				tenantID,
 				serverEndpointName,
+				tenantResolver,
+				serverEndpointValue.TenantResolver,
 			},
 		)
-		if err != nil {
-			return fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config url : mustNotConflictWithExistingUrl \"%s\"", *serverEndpointValue.Url)
-		}
 	}
 
 	if config.ReverseProxyEndpoints != nil {
-		for _, reverseProxyEndpoint := range *config.ReverseProxyEndpoints {
+		for index, reverseProxyEndpoint := range *config.ReverseProxyEndpoints {
 			reverseProxySourceUrl, err := url.Parse(string(*reverseProxyEndpoint.Url))
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			reverseProxyTargetUrl, err := url.Parse(string(*reverseProxyEndpoint.TargetUrl))
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			for _, method := range *reverseProxyEndpoint.Methods {
-				err := TempMuxCatalog.Add(
-					*reverseProxyEndpoint.Connector,
-					reverseProxySourceUrl.Scheme,
-					reverseProxySourceUrl.Host,
-					reverseProxySourceUrl.Path,
-					method,
+				addMuxEntry(*reverseProxyEndpoint.Connector, reverseProxySourceUrl, method,
 					TenantReverseProxyEndpoint{
 						tenantID,
+						strconv.Itoa(index),
 						saneDirTerminator(reverseProxySourceUrl.Path),
 						reverseProxyTargetUrl,
 					},
 				)
-				if err != nil {
-					return fmt.Errorf(TRACE + " MultiTenancySupport AddTenant config ReverseProxies Url: mustNotConflictWithExistingUrl")
-				}
 			}
 		}
 	}
 
 	if config.FileServerEndpoints != nil {
-		for _, fileServerEndpoint := range *config.FileServerEndpoints {
+		for index, fileServerEndpoint := range *config.FileServerEndpoints {
 			fileServerUrl, err := url.Parse(string(*fileServerEndpoint.Url))
 			if err != nil {
-				return err
+				return nil, err
 			}
 
-			err = TempMuxCatalog.Add(
-				*fileServerEndpoint.Connector,
-				fileServerUrl.Scheme,
-				fileServerUrl.Host,
-				fileServerUrl.Path,
-				http.MethodGet,
+			addMuxEntry(*fileServerEndpoint.Connector, fileServerUrl, http.MethodGet,
 				TenantFileServerEndpoint{
 					tenantID,
+					strconv.Itoa(index),
 					saneDirTerminator(fileServerUrl.Path),
 					string(*fileServerEndpoint.RootFs),
 					fileServerEndpoint.DirListing != nil && *fileServerEndpoint.DirListing,
 				},
 			)
-			if err != nil {
-				return fmt.Errorf(TRACE + " MultiTenancySupport AddTenant config FileServerEndpoints Url: mustNotConflictWithExistingUrl")
-			}
 		}
 	}
 
-	m.MuxCatalog = &TempMuxCatalog
-	m.Config.Tenants[tenantID] = config
-	return nil
-}
-
-func (m *MultiTenancySupport) RemoveTenant(tenantID string) error {
-	if _, ok := m.Config.Tenants[tenantID]; !ok {
-		return fmt.Errorf(TRACE+" MultiTenancySupport RemoveTenant tenantID: mustExists \"%s\"", tenantID)
-	}
+	if config.RedirectEndpoints != nil {
+		for index, redirectEndpoint := range *config.RedirectEndpoints {
+			redirectUrl, err := url.Parse(string(*redirectEndpoint.Url))
+			if err != nil {
+				return nil, err
+			}
 
-	removeWhen := func(muxEntry mux.MuxEntry) bool {
-		serverEndpoint, ok := muxEntry.Value.(TenantServerEndpoint)
-		if ok && serverEndpoint.TenantID == tenantID {
-			return true
+			for _, method := range redirectEndpoint.MethodsOrDefault() {
+				addMuxEntry(*redirectEndpoint.Connector, redirectUrl, method,
+					TenantRedirectEndpoint{
+						tenantID,
+						strconv.Itoa(index),
+						*mux.NewPathParts(redirectUrl.Path),
+						*redirectEndpoint.Target,
+						redirectEndpoint.StatusOrDefault(),
+						redirectEndpoint.PreserveQuery != nil && *redirectEndpoint.PreserveQuery,
+					},
+				)
+			}
 		}
-		proxyEndpoint, ok := muxEntry.Value.(TenantReverseProxyEndpoint)
-		return ok && proxyEndpoint.TenantID == tenantID
-
 	}
-	m.MuxCatalog.RemoveAll(removeWhen)
 
-	return nil
+	return muxEntries, nil
 }
 
-func (m *MultiTenancySupport) GetTenantIdAndEndpointName(connectorName string, r *http.Request) (tenantID string, result interface{}, found bool) {
-	muxEntry, found := m.MuxCatalog.GetWithRequest(connectorName, r)
-	if !found {
-		return "", nil, false
+// muxScheme the MuxKey Scheme for an EndpointUrl, mux.AnyScheme when it is scheme relative.
+func muxScheme(u *url.URL) string {
+	if u.Scheme == "" {
+		return mux.AnyScheme
 	}
-
-	fmt.Println(muxEntry.Key, muxEntry.Value)
-	serverEndpoint, ok := muxEntry.Value.(TenantServerEndpoint)
-	if ok {
-		return serverEndpoint.TenantID, serverEndpoint, true
-	}
-
-	proxyEndpoint, ok := muxEntry.Value.(TenantReverseProxyEndpoint)
-	if ok {
-		return proxyEndpoint.TenantID, proxyEndpoint, true
-	}
-
-	fsEndpoint, ok := muxEntry.Value.(TenantFileServerEndpoint)
-	if ok {
-		return fsEndpoint.TenantID, fsEndpoint, true
-	}
-
-	return "", nil, false
-
-}
-
-func (m *MultiTenancySupport) GetCertificateChainAndPrivateKeyBySubjectName(subjectName string) (tls.Certificate, bool) {
-	certificate, ok := m.x509CertificateBySubjectName[subjectName]
-	return certificate, ok
+	return u.Scheme
 }
 
 func saneDirTerminator(s string) string {
@@ -0,0 +1,142 @@
+package multitenancy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/riotemergence/godynamicweb/mux"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// redirectTargetPart a piece of a redirect target: literal text, a "{name}"
+// placeholder, or the "*" one.
+type redirectTargetPart struct {
+	literal  string
+	param    string
+	wildcard bool
+}
+
+func parseRedirectTarget(target string) ([]redirectTargetPart, error) {
+	parts := make([]redirectTargetPart, 0)
+	for target != "" {
+		switch i := strings.IndexAny(target, "{*"); {
+		case i < 0:
+			parts = append(parts, redirectTargetPart{literal: target})
+			target = ""
+		case i > 0:
+			parts = append(parts, redirectTargetPart{literal: target[:i]})
+			target = target[i:]
+		case target[0] == '*':
+			parts = append(parts, redirectTargetPart{wildcard: true})
+			target = target[1:]
+		default:
+			end := strings.IndexByte(target, '}')
+			if end < 0 {
+				return nil, validation.Invalid("placeholderMustBeClosed")
+			}
+			if end == 1 {
+				return nil, validation.Invalid("placeholderMustBeNamed")
+			}
+			parts = append(parts, redirectTargetPart{param: target[1:end]})
+			target = target[end+1:]
+		}
+	}
+	return parts, nil
+}
+
+// validateRedirectTarget checks that target is an http URL or an absolute path, and
+// that its placeholders are captured by the source path.
+func validateRedirectTarget(target string, sourcePath mux.PathParts) error {
+	parts, err := parseRedirectTarget(target)
+	if err != nil {
+		return err
+	}
+	captured := make(map[string]bool)
+	for _, sourcePart := range sourcePath {
+		if strings.HasPrefix(sourcePart, "{") && strings.HasSuffix(sourcePart, "}") {
+			captured[sourcePart[1:len(sourcePart)-1]] = true
+		}
+	}
+	for _, part := range parts {
+		if part.param != "" && !captured[part.param] {
+			return validation.Invalidf("placeholderMustBeCapturedByUrl", "%q", "{"+part.param+"}")
+		}
+		if part.wildcard && (len(sourcePath) == 0 || sourcePath[len(sourcePath)-1] != "*") {
+			return validation.Invalid("wildcardMustBeCapturedByUrl")
+		}
+	}
+
+	targetUrl, err := url.Parse(expandRedirectTarget(parts, func(string) string { return "x" }, "x"))
+	if err != nil {
+		return validation.Invalid("mustBeValidUrl")
+	}
+	if targetUrl.Scheme == "" && targetUrl.Host == "" && strings.HasPrefix(targetUrl.Path, "/") && !strings.HasPrefix(target, "//") {
+		return nil
+	}
+	if (targetUrl.Scheme == "http" || targetUrl.Scheme == "https") && targetUrl.Host != "" {
+		return nil
+	}
+	return validation.Invalid("mustBeHttpUrlOrAbsolutePath")
+}
+
+func expandRedirectTarget(parts []redirectTargetPart, param func(name string) string, wildcard string) string {
+	var location strings.Builder
+	for _, part := range parts {
+		switch {
+		case part.wildcard:
+			location.WriteString(wildcard)
+		case part.param != "":
+			location.WriteString(param(part.param))
+		default:
+			location.WriteString(part.literal)
+		}
+	}
+	return location.String()
+}
+
+// Location where the endpoint redirects r to: its target with the placeholders
+// replaced by what the request path captures, escaped.
+func (e TenantRedirectEndpoint) Location(r *http.Request) string {
+	// The target was checked when the tenant was added.
+	parts, _ := parseRedirectTarget(e.Target)
+
+	requestPath := *mux.NewPathParts(r.URL.Path)
+	captured := make(map[string]string)
+	wildcard := ""
+	for i, sourcePart := range e.SourcePath {
+		if i == len(e.SourcePath)-1 && sourcePart == "*" {
+			rest := make([]string, 0)
+			if i < len(requestPath) {
+				for _, requestPart := range requestPath[i:] {
+					rest = append(rest, url.PathEscape(requestPart))
+				}
+			}
+			wildcard = strings.Join(rest, "/")
+			break
+		}
+		if i < len(requestPath) && strings.HasPrefix(sourcePart, "{") && strings.HasSuffix(sourcePart, "}") {
+			captured[sourcePart[1:len(sourcePart)-1]] = url.PathEscape(requestPath[i])
+		}
+	}
+	location := expandRedirectTarget(parts, func(name string) string { return captured[name] }, wildcard)
+	if strings.HasPrefix(e.Target, "/") {
+		// Empty captures must not turn the path into a scheme-relative URL.
+		location = "/" + strings.TrimLeft(location, "/")
+	}
+
+	if !e.PreserveQuery || r.URL.RawQuery == "" {
+		return location
+	}
+	// The query goes before the fragment of the target, after its own query if any.
+	withoutFragment, fragment, hasFragment := strings.Cut(location, "#")
+	separator := "?"
+	if strings.Contains(withoutFragment, "?") {
+		separator = "&"
+	}
+	location = withoutFragment + separator + r.URL.RawQuery
+	if hasFragment {
+		location += "#" + fragment
+	}
+	return location
+}
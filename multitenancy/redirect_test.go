@@ -0,0 +1,46 @@
+package multitenancy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/mux"
+)
+
+func TestRedirectLocation(t *testing.T) {
+	tests := []struct {
+		sourcePath    string
+		target        string
+		preserveQuery bool
+		requestUrl    string
+		location      string
+	}{
+		// Captures, escaped.
+		{"/old/{id}", "https://b.test/new/{id}", false, "/old/42", "https://b.test/new/42"},
+		{"/docs/{page}", "/manual/{page}", false, "/docs/a%20b", "/manual/a%20b"},
+		{"/{a}/{b}", "/{b}/{a}", false, "/x/y", "/y/x"},
+		// Wildcard, empty or spanning several segments.
+		{"/old/{id}/*", "https://b.test/new/{id}/*", false, "/old/42/a/b%20c", "https://b.test/new/42/a/b%20c"},
+		{"/old/{id}/*", "https://b.test/new/{id}/*", false, "/old/42/", "https://b.test/new/42/"},
+		{"/go/*", "/*", false, "/go//evil.test", "/evil.test"},
+		// Query, kept only when asked, after the query of the target.
+		{"/old/*", "/new/*", false, "/old/a?x=1", "/new/a"},
+		{"/old/*", "/new/*", true, "/old/a?x=1&y=2", "/new/a?x=1&y=2"},
+		{"/old/*", "/new/*?v=2", true, "/old/a?x=1", "/new/a?v=2&x=1"},
+		{"/old/*", "/new/*", true, "/old/a", "/new/a"},
+		// Fragment of the target, after the query.
+		{"/docs/{page}", "/manual/{page}#top", false, "/docs/intro?lang=en", "/manual/intro#top"},
+		{"/docs/{page}", "/manual/{page}#top", true, "/docs/intro?lang=en", "/manual/intro?lang=en#top"},
+		{"/docs/{page}", "/manual/{page}?v=2#top", true, "/docs/intro?lang=en", "/manual/intro?v=2&lang=en#top"},
+	}
+	for _, test := range tests {
+		endpoint := TenantRedirectEndpoint{
+			SourcePath:    *mux.NewPathParts(test.sourcePath),
+			Target:        test.target,
+			PreserveQuery: test.preserveQuery,
+		}
+		if location := endpoint.Location(httptest.NewRequest("GET", "http://a.test"+test.requestUrl, nil)); location != test.location {
+			t.Errorf("%s to %s, %s: %s, expected %s", test.sourcePath, test.target, test.requestUrl, location, test.location)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package multitenancy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/riotemergence/godynamicweb/mux"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+// Snapshot what a commit publishes, at once: the routes together with the tenants,
+// the values and the handlers they lead to. A request resolves all of them from the
+// same Snapshot, so it never runs an old route with the middleware or the values of
+// a newer commit. It must not be modified.
+type Snapshot struct {
+	tenants          TenantsConfig
+	certificateIndex *x509.CertificateIndex
+	values           tenantValues
+	handlers         tenantHandlers
+	muxEntries       mux.MuxEntries[TenantRoute]
+	endpointHandler  http.Handler
+}
+
+// Snapshot the state of the last commit.
+func (m *MultiTenancySupport) Snapshot() *Snapshot {
+	return m.snapshot.Load().(*Snapshot)
+}
+
+// Tenants the tenants of the snapshot.
+func (s *Snapshot) Tenants() TenantsConfig {
+	return s.tenants
+}
+
+// GetTenantRoute the route of a request, as MultiTenancySupport GetTenantRoute.
+func (s *Snapshot) GetTenantRoute(connectorName string, r *http.Request) (TenantRoute, bool) {
+	muxEntry, found := s.muxEntries.GetWithRequest(connectorName, r)
+	if !found {
+		return nil, false
+	}
+
+	fmt.Println(muxEntry.Key, muxEntry.Value)
+	return muxEntry.Value, true
+}
+
+// TenantValues the values of a tenant, as MultiTenancySupport TenantValues.
+func (s *Snapshot) TenantValues(tenantID string) (*TenantValues, bool) {
+	values, found := s.values[tenantID]
+	return values, found
+}
+
+// RouteHandler the handler of a route, as MultiTenancySupport RouteHandler.
+func (s *Snapshot) RouteHandler(tenantID string, route TenantRoute) http.Handler {
+	handlers := s.handlers[tenantID]
+	if handler, found := handlers[EndpointKey(route)]; found {
+		return handler
+	}
+	if handler, found := handlers[tenantHandlerKey]; found {
+		return handler
+	}
+	return s.endpointHandler
+}
@@ -0,0 +1,51 @@
+package multitenancy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSnapshotKeepsItsCommit(t *testing.T) {
+	m := NewMultiTenancySupport()
+	m.EndpointHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	config := func(path, version string) TenantConfig {
+		return tenantConfig(t, `{"name":"a","variables":{"version":"`+version+`"},
+			"serverEndpoints":{"hello":{"url":"http://a.test`+path+`","connector":"c",
+			"middleware":[{"name":"headers","params":{"set":{"X-Version":"`+version+`"}}}]}}}`)
+	}
+	if err := m.AddTenant("a", config("/v1", "1"), map[string]string{"hello": "GET"}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := m.Snapshot()
+	if _, err := m.ReplaceTenant("a", config("/v2", "2"), map[string]string{"hello": "GET"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot taken before the commit still routes, handles and resolves as of
+	// its own commit.
+	request := httptest.NewRequest("GET", "http://a.test/v1", nil)
+	route, found := snapshot.GetTenantRoute("c", request)
+	if !found {
+		t.Fatal("route not found")
+	}
+	recorder := httptest.NewRecorder()
+	snapshot.RouteHandler("a", route).ServeHTTP(recorder, request)
+	if version := recorder.Header().Get("X-Version"); version != "1" {
+		t.Fatal(version)
+	}
+	values, _ := snapshot.TenantValues("a")
+	if version, err := Variable[string](values, "version"); err != nil || version != "1" {
+		t.Fatal(version, err)
+	}
+
+	if _, found := m.GetTenantRoute("c", request); found {
+		t.Fatal("the replaced route is still found")
+	}
+	values, _ = m.TenantValues("a")
+	if version, err := Variable[string](values, "version"); err != nil || version != "2" {
+		t.Fatal(version, err)
+	}
+}
@@ -0,0 +1,397 @@
+package multitenancy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// Template parameter types.
+const (
+	StringParameter  = "string"
+	IntegerParameter = "integer"
+	NumberParameter  = "number"
+	BooleanParameter = "boolean"
+)
+
+var parameterNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parameterPlaceholderPattern a string that is nothing but the placeholder of a
+// parameter, which renders as the value of the parameter rather than as a string.
+var parameterPlaceholderPattern = regexp.MustCompile(`^\{\{-?\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*-?\}\}$`)
+
+// TemplateParameterConfig a parameter of a tenant template.
+type TemplateParameterConfig struct {
+	Type        *string `json:"type"`
+	Description *string `json:"description,omitempty"`
+	// Pattern the regular expression a string parameter must match.
+	Pattern *string `json:"pattern,omitempty"`
+	// Default the value of the parameter when it is not given; a parameter without
+	// Default is required.
+	Default interface{} `json:"default,omitempty"`
+	// Example the value the template is validated with, instead of Default or a
+	// placeholder value of the type.
+	Example interface{} `json:"example,omitempty"`
+}
+
+func (c TemplateParameterConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c TemplateParameterConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("type").Enum = []interface{}{StringParameter, IntegerParameter, NumberParameter, BooleanParameter}
+}
+
+func (c TemplateParameterConfig) ValidateWith(v *validation.Validator) {
+	if !v.Required("type", c.Type != nil) {
+		return
+	}
+	parameterSchema := c.schema()
+	if parameterSchema == nil {
+		v.Field("type").Failf("mustBeParameterType", "%q", *c.Type)
+		return
+	}
+	if c.Pattern != nil {
+		if *c.Type != StringParameter {
+			v.Field("pattern").Fail("requiresStringType")
+		} else if _, err := regexp.Compile(*c.Pattern); err != nil {
+			v.Field("pattern").Failf("mustBeRegexp", "%s", err)
+		}
+	}
+	if c.Default != nil {
+		checkParameter(v.Field("default"), parameterSchema, c.Default)
+	}
+	if c.Example != nil {
+		checkParameter(v.Field("example"), parameterSchema, c.Example)
+	}
+}
+
+// schema the JSON Schema of the values of the parameter, nil for an unknown type.
+func (c TemplateParameterConfig) schema() *schema.Schema {
+	switch *c.Type {
+	case StringParameter, IntegerParameter, NumberParameter, BooleanParameter:
+	default:
+		return nil
+	}
+	s := &schema.Schema{Type: *c.Type}
+	if c.Description != nil {
+		s.Description = *c.Description
+	}
+	if c.Pattern != nil {
+		s.Pattern = *c.Pattern
+	}
+	return s
+}
+
+// sample the value the template is validated with.
+func (c TemplateParameterConfig) sample() interface{} {
+	switch {
+	case c.Example != nil:
+		return c.Example
+	case c.Default != nil:
+		return c.Default
+	}
+	switch *c.Type {
+	case IntegerParameter, NumberParameter:
+		return 1
+	case BooleanParameter:
+		return true
+	}
+	return "sample"
+}
+
+func checkParameter(v *validation.Validator, parameterSchema *schema.Schema, value interface{}) {
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		v.Failf("mustBeJson", "%s", err)
+		return
+	}
+	v.Add(parameterSchema.Check(valueJson))
+}
+
+// TemplateConfig a TenantConfig with {{ .param }} placeholders, and the parameters
+// they take. Every string of Tenant, keys included, is a text/template; numbers and
+// booleans are kept as they are. A value that is nothing but the placeholder of a
+// parameter, such as "{{ .port }}", is replaced by the value of the parameter, so
+// that numbers and booleans can be parameters too.
+type TemplateConfig struct {
+	Description *string                            `json:"description,omitempty"`
+	Parameters  map[string]TemplateParameterConfig `json:"parameters,omitempty"`
+	Tenant      json.RawMessage                    `json:"tenant"`
+}
+
+func (c TemplateConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c TemplateConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Properties["tenant"] = &schema.Schema{Type: "object"}
+	s.Property("parameters").PropertyNames = &schema.Schema{Pattern: parameterNamePattern.String()}
+}
+
+// ValidateWith checks the parameters, then renders the tenant with a sample value of
+// every parameter and checks it as a TenantConfig.
+func (c TemplateConfig) ValidateWith(v *validation.Validator) {
+	parametersValid := true
+	for _, name := range validation.SortedKeys(c.Parameters) {
+		parameterValidator := v.Field("parameters").Field(name)
+		if !parameterNamePattern.MatchString(name) {
+			parameterValidator.Fail("nameMustBeIdentifier")
+		}
+		before := len(v.Errors())
+		parameterValidator.Check(c.Parameters[name])
+		parametersValid = parametersValid && len(v.Errors()) == before
+	}
+	if !v.Required("tenant", len(c.Tenant) > 0 && !bytes.Equal(c.Tenant, []byte("null"))) || !parametersValid {
+		return
+	}
+
+	samples := make(map[string]interface{}, len(c.Parameters))
+	for name, parameter := range c.Parameters {
+		samples[name] = parameter.sample()
+	}
+	tenantValidator := v.Field("tenant")
+	before := len(v.Errors())
+	rendered := c.render(tenantValidator, samples)
+	if len(v.Errors()) > before {
+		return
+	}
+	var config TenantConfig
+	if err := decodeTenantConfig(rendered, &config); err != nil {
+		tenantValidator.Add(err)
+		return
+	}
+	tenantValidator.Check(config)
+}
+
+// ParametersSchema the JSON Schema of the parameters object a tenant is created with.
+func (c TemplateConfig) ParametersSchema() *schema.Schema {
+	s := &schema.Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*schema.Schema, len(c.Parameters)),
+		AdditionalProperties: false,
+	}
+	for _, name := range validation.SortedKeys(c.Parameters) {
+		parameter := c.Parameters[name]
+		if parameter.Type == nil || parameter.schema() == nil {
+			continue
+		}
+		s.Properties[name] = parameter.schema()
+		if parameter.Default == nil {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// Render the TenantConfig of the template for parameters, recording that it came
+// from version of the template name.
+func (c TemplateConfig) Render(name string, version int, parameters map[string]interface{}) (TenantConfig, error) {
+	if parameters == nil {
+		parameters = make(map[string]interface{})
+	}
+	parametersJson, err := json.Marshal(parameters)
+	if err != nil {
+		return TenantConfig{}, fmt.Errorf(TRACE+" TemplateConfig Render parameters: %s", err)
+	}
+	if err := c.ParametersSchema().Check(parametersJson); err != nil {
+		return TenantConfig{}, err
+	}
+
+	values := make(map[string]interface{}, len(c.Parameters))
+	for parameterName, parameter := range c.Parameters {
+		values[parameterName] = parameter.Default
+	}
+	for parameterName, value := range parameters {
+		values[parameterName] = value
+	}
+	v := validation.NewValidator()
+	rendered := c.render(v.Field("tenant"), values)
+	if err := v.Err(); err != nil {
+		return TenantConfig{}, err
+	}
+	var config TenantConfig
+	if err := decodeTenantConfig(rendered, &config); err != nil {
+		return TenantConfig{}, err
+	}
+	config.Template = &TemplateRef{
+		Name:       &name,
+		Version:    &version,
+		Parameters: parameters,
+	}
+	return config, nil
+}
+
+// render the tenant document with values, reporting the placeholders that do not
+// parse or execute.
+func (c TemplateConfig) render(v *validation.Validator, values map[string]interface{}) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(c.Tenant))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		v.Failf("mustBeJson", "%s", err)
+		return nil
+	}
+	if _, isObject := document.(map[string]interface{}); !isObject {
+		v.Fail("mustBeObject")
+		return nil
+	}
+	rendered, err := json.Marshal(renderNode(v, document, values))
+	if err != nil {
+		v.Failf("mustBeJson", "%s", err)
+		return nil
+	}
+	return rendered
+}
+
+func renderNode(v *validation.Validator, node interface{}, values map[string]interface{}) interface{} {
+	switch value := node.(type) {
+	case string:
+		if match := parameterPlaceholderPattern.FindStringSubmatch(value); match != nil {
+			if parameter, found := values[match[1]]; found && parameter != nil {
+				return parameter
+			}
+		}
+		return renderString(v, value, values)
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+		for i, item := range value {
+			rendered[i] = renderNode(v.Index(i), item, values)
+		}
+		return rendered
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(value))
+		for _, key := range validation.SortedKeys(value) {
+			rendered[renderString(v.Field(key), key, values)] = renderNode(v.Field(key), value[key], values)
+		}
+		return rendered
+	}
+	return node
+}
+
+func renderString(v *validation.Validator, s string, values map[string]interface{}) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		v.Failf("mustBeTemplate", "%s", err)
+		return s
+	}
+	var rendered strings.Builder
+	if err := t.Execute(&rendered, values); err != nil {
+		v.Failf("mustRender", "%s", err)
+		return s
+	}
+	return rendered.String()
+}
+
+// decodeTenantConfig decodes strictly, so that a misspelled member of a template is
+// reported.
+func decodeTenantConfig(document []byte, config *TenantConfig) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return validation.Invalidf("mustBeTenantConfig", "%s", err)
+	}
+	return nil
+}
+
+// TemplateRef the template version a tenant was rendered from, and its parameters.
+type TemplateRef struct {
+	Name       *string                `json:"name"`
+	Version    *int                   `json:"version"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+func (r TemplateRef) Validate() error {
+	return validation.Validate(r)
+}
+
+func (r TemplateRef) ValidateWith(v *validation.Validator) {
+	if v.Required("name", r.Name != nil) && *r.Name == "" {
+		v.Field("name").Fail("mustNotBeEmpty")
+	}
+	if v.Required("version", r.Version != nil) && *r.Version < 1 {
+		v.Field("version").Fail("mustBePositive")
+	}
+}
+
+// TenantTemplate a version of a named template.
+type TenantTemplate struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	TemplateConfig
+}
+
+func (t TenantTemplate) String() string {
+	return util.ToJson(t)
+}
+
+// TenantTemplateHistory every version of a template, oldest first. A deleted template
+// keeps its history, so that a template put again under its name goes on from its
+// last version number rather than start over.
+type TenantTemplateHistory struct {
+	Versions []TenantTemplate `json:"versions"`
+	Deleted  bool             `json:"deleted,omitempty"`
+}
+
+func (h TenantTemplateHistory) String() string {
+	return util.ToJson(h)
+}
+
+// Latest the latest version of the template, unless it is deleted.
+func (h TenantTemplateHistory) Latest() (TenantTemplate, bool) {
+	if h.Deleted || len(h.Versions) == 0 {
+		return TenantTemplate{}, false
+	}
+	return h.Versions[len(h.Versions)-1], true
+}
+
+// Version a version of the template, unless it is deleted.
+func (h TenantTemplateHistory) Version(version int) (TenantTemplate, bool) {
+	if h.Deleted {
+		return TenantTemplate{}, false
+	}
+	for _, template := range h.Versions {
+		if template.Version == version {
+			return template, true
+		}
+	}
+	return TenantTemplate{}, false
+}
+
+// Next the history with config as the version after the last one, which undeletes
+// the template.
+func (h TenantTemplateHistory) Next(name string, config TemplateConfig) TenantTemplateHistory {
+	version := 1
+	if len(h.Versions) > 0 {
+		version = h.Versions[len(h.Versions)-1].Version + 1
+	}
+	versions := make([]TenantTemplate, len(h.Versions), len(h.Versions)+1)
+	copy(versions, h.Versions)
+	return TenantTemplateHistory{
+		Versions: append(versions, TenantTemplate{Name: name, Version: version, TemplateConfig: config}),
+	}
+}
+
+// TenantTemplateVersions versions of a template.
+type TenantTemplateVersions []TenantTemplate
+
+func (v TenantTemplateVersions) String() string {
+	return util.ToJson(v)
+}
+
+// TenantTemplates the latest version of every template, by name.
+type TenantTemplates map[string]TenantTemplate
+
+func (t TenantTemplates) String() string {
+	return util.ToJson(validation.SortedKeys(t))
+}
@@ -0,0 +1,250 @@
+package multitenancy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// Redacted what is printed and encoded in place of a secret value.
+const Redacted = "[REDACTED]"
+
+// SecretConfig where the value of a secret is read from, either a file, whose
+// trailing newline is dropped, or an environment variable. The value is read when the
+// tenant is created or replaced, so a rotated secret takes effect on the next update.
+type SecretConfig struct {
+	File *string `json:"file,omitempty"`
+	Env  *string `json:"env,omitempty"`
+}
+
+func (c SecretConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c SecretConfig) RefineJSONSchema(s *schema.Schema) {
+	s.OneOf = []*schema.Schema{
+		{Required: []string{"file"}, Properties: map[string]*schema.Schema{"file": {Type: "string"}}},
+		{Required: []string{"env"}, Properties: map[string]*schema.Schema{"env": {Type: "string"}}},
+	}
+	s.Property("file").MinLength = schema.Int(1)
+	s.Property("env").MinLength = schema.Int(1)
+}
+
+func (c SecretConfig) ValidateWith(v *validation.Validator) {
+	if (c.File == nil) == (c.Env == nil) {
+		v.Fail("fileOrEnvRequired")
+	}
+	if c.File != nil && *c.File == "" {
+		v.Field("file").Fail("mustNotBeEmpty")
+	}
+	if c.Env != nil && *c.Env == "" {
+		v.Field("env").Fail("mustNotBeEmpty")
+	}
+}
+
+// Resolve reads the value of the secret from its provider, wherever it is. It is
+// for the config of the operator; the secrets of tenants are resolved with
+// ResolveFrom.
+func (c SecretConfig) Resolve() (Secret, error) {
+	switch {
+	case c.File != nil:
+		value, err := os.ReadFile(*c.File)
+		if err != nil {
+			return Secret{}, fmt.Errorf(TRACE+" SecretConfig Resolve file: mustBeReadable \"%s\"", *c.File)
+		}
+		return Secret{value: strings.TrimSuffix(strings.TrimSuffix(string(value), "\n"), "\r")}, nil
+	case c.Env != nil:
+		value, found := os.LookupEnv(*c.Env)
+		if !found {
+			return Secret{}, fmt.Errorf(TRACE+" SecretConfig Resolve env: mustBeSet \"%s\"", *c.Env)
+		}
+		return Secret{value: value}, nil
+	}
+	return Secret{}, fmt.Errorf(TRACE + " SecretConfig Resolve: fileOrEnvRequired")
+}
+
+// ResolveFrom reads the value of the secret when sources allows its provider. A
+// provider that is not allowed is rejected before it is looked at, so the error does
+// not tell whether the file or the variable exists.
+func (c SecretConfig) ResolveFrom(sources SecretSources) (Secret, error) {
+	switch {
+	case c.File != nil:
+		if !sources.allowsFile(*c.File) {
+			return Secret{}, fmt.Errorf(TRACE+" SecretConfig Resolve file: mustBeInSecretDirs \"%s\"", *c.File)
+		}
+	case c.Env != nil:
+		if !sources.allowsEnv(*c.Env) {
+			return Secret{}, fmt.Errorf(TRACE+" SecretConfig Resolve env: mustHaveSecretEnvPrefix \"%s\"", *c.Env)
+		}
+	}
+	return c.Resolve()
+}
+
+// SecretSources where the secrets of tenants may be read from: the files under one
+// of Dirs and the environment variables whose name starts with one of EnvPrefixes.
+// The zero value allows none.
+type SecretSources struct {
+	Dirs        []string
+	EnvPrefixes []string
+}
+
+// ReadKey the value of a key read from file or env, when the sources allow it, as
+// the tenantresolver.Keys of a resolver.
+func (s SecretSources) ReadKey(file, env *string) ([]byte, error) {
+	secret, err := SecretConfig{File: file, Env: env}.ResolveFrom(s)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(secret.Reveal()), nil
+}
+
+// allowsFile whether path is under one of the dirs, once the symbolic links of both
+// are followed, so that a link does not lead out of them.
+func (s SecretSources) allowsFile(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		resolvedPath = filepath.Clean(path)
+	}
+	for _, dir := range s.Dirs {
+		resolvedDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if isUnder(filepath.Clean(path), filepath.Clean(dir)) && isUnder(resolvedPath, resolvedDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func isUnder(path, dir string) bool {
+	relativePath, err := filepath.Rel(dir, path)
+	return err == nil && relativePath != "." && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+func (s SecretSources) allowsEnv(name string) bool {
+	for _, prefix := range s.EnvPrefixes {
+		if prefix != "" && strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Secret a resolved secret value. It is redacted wherever it is printed or encoded,
+// so it never shows in management responses or logs; only Reveal returns it.
+type Secret struct {
+	value string
+}
+
+func (s Secret) Reveal() string {
+	return s.value
+}
+
+func (s Secret) String() string {
+	return Redacted
+}
+
+func (s Secret) GoString() string {
+	return Redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Redacted)
+}
+
+// TenantValues the variables and the resolved secrets of a tenant, as of the last
+// commit that created or replaced it.
+type TenantValues struct {
+	TenantID  string                     `json:"tenantId"`
+	Variables map[string]json.RawMessage `json:"variables"`
+	Secrets   map[string]Secret          `json:"secrets"`
+}
+
+func (v TenantValues) String() string {
+	return util.ToJson(v)
+}
+
+// Secret the value of a secret of the tenant.
+func (v *TenantValues) Secret(name string) (string, bool) {
+	secret, found := v.Secrets[name]
+	return secret.Reveal(), found
+}
+
+// Variable a variable of the tenant, decoded into T.
+func Variable[T any](values *TenantValues, name string) (T, error) {
+	var value T
+	variable, found := values.Variables[name]
+	if !found {
+		return value, fmt.Errorf(TRACE+" Variable name: mustExist \"%s\"", name)
+	}
+	if err := json.Unmarshal(variable, &value); err != nil {
+		return value, fmt.Errorf(TRACE+" Variable \"%s\": %s", name, err)
+	}
+	return value, nil
+}
+
+func newTenantValues(tenantID string, config TenantConfig, sources SecretSources) (*TenantValues, error) {
+	values := &TenantValues{
+		TenantID:  tenantID,
+		Variables: make(map[string]json.RawMessage, len(config.Variables)),
+		Secrets:   make(map[string]Secret, len(config.Secrets)),
+	}
+	for name, variable := range config.Variables {
+		variableJson, err := json.Marshal(variable)
+		if err != nil {
+			return nil, fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config Variables \"%s\": %s", name, err)
+		}
+		values.Variables[name] = variableJson
+	}
+	for name, secretConfig := range config.Secrets {
+		secret, err := secretConfig.ResolveFrom(sources)
+		if err != nil {
+			return nil, fmt.Errorf(TRACE+" MultiTenancySupport AddTenant config Secrets \"%s\": %s", name, err)
+		}
+		values.Secrets[name] = secret
+	}
+	return values, nil
+}
+
+// tenantValues the values of every tenant.
+type tenantValues map[string]*TenantValues
+
+// SetSecretSources replaces where the secrets of tenants may be read from. Tenants
+// already committed keep the values they resolved.
+func (m *MultiTenancySupport) SetSecretSources(sources SecretSources) {
+	m.secretSources.Store(sources)
+}
+
+// SecretSources where the secrets of tenants may be read from.
+func (m *MultiTenancySupport) SecretSources() SecretSources {
+	return m.secretSources.Load().(SecretSources)
+}
+
+// TenantValues the values of a tenant, as of the last commit.
+func (m *MultiTenancySupport) TenantValues(tenantID string) (*TenantValues, bool) {
+	return m.Snapshot().TenantValues(tenantID)
+}
+
+type tenantValuesContextKey struct{}
+
+// WithTenantValues a context that carries the values of the tenant a request is for.
+func WithTenantValues(ctx context.Context, values *TenantValues) context.Context {
+	return context.WithValue(ctx, tenantValuesContextKey{}, values)
+}
+
+// TenantValuesFromContext the values of the tenant a request is for.
+func TenantValuesFromContext(ctx context.Context) (*TenantValues, bool) {
+	values, found := ctx.Value(tenantValuesContextKey{}).(*TenantValues)
+	return values, found
+}
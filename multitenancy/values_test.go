@@ -0,0 +1,59 @@
+package multitenancy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretSources(t *testing.T) {
+	secretDir := t.TempDir()
+	secretFile := filepath.Join(secretDir, "db")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "other")
+	if err := os.WriteFile(outside, []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(secretDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TENANT_KEY", "key")
+	t.Setenv("SERVER_KEY", "server")
+	sources := SecretSources{Dirs: []string{secretDir}, EnvPrefixes: []string{"TENANT_"}}
+	file := func(path string) SecretConfig { return SecretConfig{File: &path} }
+	env := func(name string) SecretConfig { return SecretConfig{Env: &name} }
+
+	if secret, err := file(secretFile).ResolveFrom(sources); err != nil || secret.Reveal() != "s3cret" {
+		t.Fatal(secret.Reveal(), err)
+	}
+	if secret, err := env("TENANT_KEY").ResolveFrom(sources); err != nil || secret.Reveal() != "key" {
+		t.Fatal(secret.Reveal(), err)
+	}
+
+	for _, secretConfig := range []SecretConfig{
+		file(outside),
+		file(filepath.Join(secretDir, "..", filepath.Base(filepath.Dir(outside)), "other")),
+		file(filepath.Join(secretDir, "link")),
+		file("db"),
+		file(secretDir),
+		env("SERVER_KEY"),
+	} {
+		if _, err := secretConfig.ResolveFrom(sources); err == nil {
+			t.Errorf("%+v resolved", secretConfig)
+		}
+	}
+
+	// Whether a file outside of the dirs exists does not show.
+	_, existing := file(outside).ResolveFrom(sources)
+	_, missing := file(outside + ".missing").ResolveFrom(sources)
+	if !strings.Contains(existing.Error(), "mustBeInSecretDirs") || !strings.Contains(missing.Error(), "mustBeInSecretDirs") {
+		t.Fatal(existing, missing)
+	}
+
+	if _, err := env("TENANT_KEY").ResolveFrom(SecretSources{}); err == nil {
+		t.Fatal("the zero SecretSources allowed a secret")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	math "github.com/riotemergence/godynamicweb/math"
 	sort "github.com/riotemergence/godynamicweb/sort"
@@ -36,6 +38,13 @@ func (p *PathParts) String() string {
 	return buffer.String()
 }
 
+const (
+	// AnyScheme a MuxKey Scheme that matches both http and https requests.
+	AnyScheme = "*"
+	// AnyHost a MuxKey Host that matches requests for every host.
+	AnyHost = "*"
+)
+
 type MuxKey struct {
 	Connector string
 	Scheme    string
@@ -63,77 +72,173 @@ func (k *MuxKey) String() string {
 	return buffer.String()
 }
 
-type MuxEntry struct {
+func NewMuxKey(connector, scheme, host, path, method string) MuxKey {
+	return MuxKey{
+		Connector: connector,
+		Scheme:    scheme,
+		Host:      host,
+		Path:      *NewPathParts(path),
+		Method:    method,
+	}
+}
+
+func (k MuxKey) withSchemeAndHost(scheme, host string) MuxKey {
+	k.Scheme = scheme
+	k.Host = host
+	return k
+}
+
+type MuxEntry[T any] struct {
 	Key   MuxKey
-	Value interface{}
+	Value T
 }
 
-type MuxCatalog []MuxEntry
+type MuxEntries[T any] []MuxEntry[T]
 
-func NewMuxCatalog() *MuxCatalog {
-	muxCatalog := make(MuxCatalog, 0)
-	return &muxCatalog
+// MuxCatalog an immutable, sorted list of entries that is replaced as a whole
+// by committing a MuxCatalogTransaction. Readers never observe a partial change.
+type MuxCatalog[T any] struct {
+	writeMutex sync.Mutex
+	entries    atomic.Value
 }
 
-func (mc *MuxCatalog) Add(connector, scheme, host, path, method string, value interface{}) error {
-	//FIXME Some bug
-	muxEntry := MuxEntry{
-		Key: MuxKey{
-			Connector: connector,
-			Scheme:    scheme,
-			Host:      host,
-			Path:      *NewPathParts(path),
-			Method:    method,
-		},
+func NewMuxCatalog[T any]() *MuxCatalog[T] {
+	muxCatalog := &MuxCatalog[T]{}
+	muxCatalog.entries.Store(make(MuxEntries[T], 0))
+	return muxCatalog
+}
+
+// Entries the current catalog generation. It must not be modified.
+func (mc *MuxCatalog[T]) Entries() MuxEntries[T] {
+	return mc.entries.Load().(MuxEntries[T])
+}
+
+// Begin starts a transaction over a private copy of the current entries.
+// Only one transaction may be open at a time, so every Begin must be followed
+// by Commit or Rollback.
+func (mc *MuxCatalog[T]) Begin() *MuxCatalogTransaction[T] {
+	mc.writeMutex.Lock()
+	current := mc.Entries()
+	entries := make(MuxEntries[T], len(current))
+	copy(entries, current)
+	return &MuxCatalogTransaction[T]{
+		catalog: mc,
+		entries: entries,
+	}
+}
+
+// GetWithRequest finds the entry for a request. Host specific entries take precedence
+// over AnyHost ones, and for the same host, scheme specific entries take precedence
+// over AnyScheme ones.
+func (mc *MuxCatalog[T]) GetWithRequest(connectorName string, r *http.Request) (*MuxEntry[T], bool) {
+	return mc.Entries().GetWithRequest(connectorName, r)
+}
+
+// GetWithRequest finds the entry for a request among entries, which are sorted, as
+// MuxCatalog GetWithRequest does.
+func (entries MuxEntries[T]) GetWithRequest(connectorName string, r *http.Request) (*MuxEntry[T], bool) {
+	requestKey := NewMuxKeyFromRequest(connectorName, r)
+	candidateKeys := []MuxKey{
+		requestKey,
+		requestKey.withSchemeAndHost(AnyScheme, requestKey.Host),
+		requestKey.withSchemeAndHost(requestKey.Scheme, AnyHost),
+		requestKey.withSchemeAndHost(AnyScheme, AnyHost),
+	}
+
+	for _, candidateKey := range candidateKeys {
+		lo, _, found := sort.Search(len(entries), func(compareIndex int) int {
+			return CompareRequestKeyVsMuxKey(candidateKey, entries[compareIndex].Key)
+		})
+		if found {
+			entry := &MuxEntry[T]{}
+			*entry = entries[lo]
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+type MuxCatalogTransaction[T any] struct {
+	catalog *MuxCatalog[T]
+	entries MuxEntries[T]
+	done    bool
+}
+
+func (tx *MuxCatalogTransaction[T]) Add(connector, scheme, host, path, method string, value T) error {
+	return tx.AddEntry(MuxEntry[T]{
+		Key:   NewMuxKey(connector, scheme, host, path, method),
 		Value: value,
+	})
+}
+
+func (tx *MuxCatalogTransaction[T]) AddEntry(muxEntry MuxEntry[T]) error {
+	if tx.done {
+		return fmt.Errorf(TRACE + " MuxCatalogTransaction Add: mustNotBeFinished")
 	}
 
-	mcLen := len(*mc)
-	insertionPointIndex, _, found := sort.Search(mcLen,
+	insertionPointIndex, _, found := sort.Search(len(tx.entries),
 		func(compareIndex int) int {
-			return CompareMuxEntry(muxEntry, (*mc)[compareIndex])
+			return CompareMuxEntry(muxEntry, tx.entries[compareIndex])
 		},
 	)
 
 	if found {
-		conflictingEntry := (*mc)[insertionPointIndex]
-		return fmt.Errorf(TRACE+" MuxCatalog Add: mustNotConflictWithExistingEntry \"%s\"", conflictingEntry)
+		conflictingEntry := tx.entries[insertionPointIndex]
+		return fmt.Errorf(TRACE+" MuxCatalogTransaction Add: mustNotConflictWithExistingEntry \"%s\"", conflictingEntry.Key.String())
 	}
 
-	*mc = append(*mc, MuxEntry{})
-	copy((*mc)[insertionPointIndex+1:], (*mc)[insertionPointIndex:])
-	(*mc)[insertionPointIndex] = muxEntry
+	var zero MuxEntry[T]
+	tx.entries = append(tx.entries, zero)
+	copy(tx.entries[insertionPointIndex+1:], tx.entries[insertionPointIndex:])
+	tx.entries[insertionPointIndex] = muxEntry
 	return nil
 }
 
-func (mc *MuxCatalog) Remove(index int) {
-	*mc = append((*mc)[:index], (*mc)[index+1:]...)
-	//return mc
+func (tx *MuxCatalogTransaction[T]) Remove(index int) {
+	tx.entries = append(tx.entries[:index], tx.entries[index+1:]...)
 }
 
-func (mc *MuxCatalog) RemoveAll(removeWhen func(muxEntry MuxEntry) bool) {
-	temp := (*mc)[:0]
-	for _, v := range *mc {
-		if removeWhen(v) {
+func (tx *MuxCatalogTransaction[T]) RemoveAll(removeWhen func(muxEntry MuxEntry[T]) bool) {
+	temp := tx.entries[:0]
+	for _, v := range tx.entries {
+		if !removeWhen(v) {
 			temp = append(temp, v)
 		}
 	}
-	*mc = temp
+	tx.entries = temp
 }
 
-func (mc *MuxCatalog) GetWithRequest(connectorName string, r *http.Request) (*MuxEntry, bool) {
-	mcLen := len(*mc)
-	lo, _, found := sort.Search(mcLen, func(compareIndex int) int {
-		return CompareRequestVsMuxEntry(connectorName, r, (*mc)[compareIndex])
-	})
+// Replace discards every entry of the transaction in favour of entries, which must
+// come from a MuxCatalog or a MuxCatalogTransaction so they are already sorted.
+func (tx *MuxCatalogTransaction[T]) Replace(entries MuxEntries[T]) {
+	tx.entries = make(MuxEntries[T], len(entries))
+	copy(tx.entries, entries)
+}
+
+// Entries the entries as they will be after Commit.
+func (tx *MuxCatalogTransaction[T]) Entries() MuxEntries[T] {
+	return tx.entries
+}
 
-	if !found {
-		return nil, false
+// Commit atomically replaces the catalog entries with the transaction ones.
+func (tx *MuxCatalogTransaction[T]) Commit() error {
+	if tx.done {
+		return fmt.Errorf(TRACE + " MuxCatalogTransaction Commit: mustNotBeFinished")
 	}
-	entry := &MuxEntry{}
-	*entry = (*mc)[lo]
-	return entry, true
+	tx.done = true
+	tx.catalog.entries.Store(tx.entries)
+	tx.catalog.writeMutex.Unlock()
+	return nil
+}
 
+// Rollback discards the transaction. It is a no-op after Commit, so it can be deferred.
+func (tx *MuxCatalogTransaction[T]) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	tx.entries = nil
+	tx.catalog.writeMutex.Unlock()
 }
 
 func comparePaths(path1Parts, path2Parts []string, path1IsDynamic bool) int {
@@ -183,7 +288,7 @@ func comparePaths(path1Parts, path2Parts []string, path1IsDynamic bool) int {
 	return 0
 }
 
-func CompareMuxEntry(entry1, entry2 MuxEntry) int {
+func CompareMuxEntry[T any](entry1, entry2 MuxEntry[T]) int {
 	key1, key2 := entry1.Key, entry2.Key
 
 	comparisonResult := strings.Compare(key1.Connector, key2.Connector)
@@ -214,13 +319,8 @@ func CompareMuxEntry(entry1, entry2 MuxEntry) int {
 	return 0
 }
 
-func CompareRequestVsMuxEntry(reqConnectorName string, req *http.Request, muxEntry MuxEntry) int {
-	muxKey := muxEntry.Key
-	comparisonResult := strings.Compare(reqConnectorName, muxKey.Connector)
-	if comparisonResult != 0 {
-		return comparisonResult
-	}
-
+// NewMuxKeyFromRequest the key a request is looked up with.
+func NewMuxKeyFromRequest(connectorName string, req *http.Request) MuxKey {
 	//TODO Get Scheme
 	var reqScheme string
 	if req.TLS != nil {
@@ -231,25 +331,47 @@ func CompareRequestVsMuxEntry(reqConnectorName string, req *http.Request, muxEnt
 	if forwardedProto := req.Header.Get("X-Forwarded-Proto"); forwardedProto != "" {
 		reqScheme = forwardedProto
 	}
-	comparisonResult = strings.Compare(reqScheme, muxKey.Scheme)
-	if comparisonResult != 0 {
-		return comparisonResult
-	}
 
 	host := req.Host
 	if forwardedHost := req.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
 		host = forwardedHost
 	}
-	comparisonResult = strings.Compare(host, muxKey.Host)
+
+	return MuxKey{
+		Connector: connectorName,
+		Scheme:    reqScheme,
+		Host:      host,
+		Path:      *NewPathParts(req.URL.Path),
+		Method:    req.Method,
+	}
+}
+
+func CompareRequestVsMuxEntry[T any](reqConnectorName string, req *http.Request, muxEntry MuxEntry[T]) int {
+	return CompareRequestKeyVsMuxKey(NewMuxKeyFromRequest(reqConnectorName, req), muxEntry.Key)
+}
+
+func CompareRequestKeyVsMuxKey(requestKey, muxKey MuxKey) int {
+	comparisonResult := strings.Compare(requestKey.Connector, muxKey.Connector)
+	if comparisonResult != 0 {
+		return comparisonResult
+	}
+
+	comparisonResult = strings.Compare(requestKey.Scheme, muxKey.Scheme)
 	if comparisonResult != 0 {
 		return comparisonResult
 	}
-	comparisonResult = comparePaths(*NewPathParts(req.RequestURI), muxKey.Path, false)
+
+	comparisonResult = strings.Compare(requestKey.Host, muxKey.Host)
+	if comparisonResult != 0 {
+		return comparisonResult
+	}
+
+	comparisonResult = comparePaths(requestKey.Path, muxKey.Path, false)
 	if comparisonResult != 0 {
 		return comparisonResult
 	}
 
-	comparisonResult = strings.Compare(req.Method, muxKey.Method)
+	comparisonResult = strings.Compare(requestKey.Method, muxKey.Method)
 	if comparisonResult != 0 {
 		return comparisonResult
 	}
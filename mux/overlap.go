@@ -0,0 +1,83 @@
+package mux
+
+import "strings"
+
+type OverlapKind string
+
+const (
+	NoOverlap OverlapKind = ""
+	// DuplicateOverlap both keys match exactly the same requests.
+	DuplicateOverlap OverlapKind = "duplicate"
+	// ShadowingOverlap a key ending in "*" matches requests of the other one.
+	ShadowingOverlap OverlapKind = "shadowing"
+	// AmbiguityOverlap a path parameter of a key matches a literal part of the other one.
+	AmbiguityOverlap OverlapKind = "ambiguity"
+)
+
+// Overlap reports how two keys compete for the same requests. Keys of different
+// connectors, schemes, hosts or methods never overlap, even when one of them uses
+// AnyScheme or AnyHost, because the lookup precedence already decides between them.
+func Overlap(key1, key2 MuxKey) OverlapKind {
+	if key1.Connector != key2.Connector || key1.Scheme != key2.Scheme || key1.Host != key2.Host || key1.Method != key2.Method {
+		return NoOverlap
+	}
+
+	path1, path2 := trimEmptyPath(key1.Path), trimEmptyPath(key2.Path)
+	path1IsWildcard, path2IsWildcard := isWildcardPath(path1), isWildcardPath(path2)
+	if path1IsWildcard {
+		path1 = path1[:len(path1)-1]
+	}
+	if path2IsWildcard {
+		path2 = path2[:len(path2)-1]
+	}
+
+	if !path1IsWildcard && !path2IsWildcard && len(path1) != len(path2) {
+		return NoOverlap
+	}
+	if path1IsWildcard && !path2IsWildcard && len(path2) <= len(path1) {
+		return NoOverlap
+	}
+	if path2IsWildcard && !path1IsWildcard && len(path1) <= len(path2) {
+		return NoOverlap
+	}
+
+	ambiguous := false
+	commonLength := len(path1)
+	if len(path2) < commonLength {
+		commonLength = len(path2)
+	}
+	for pathPartIndex := 0; pathPartIndex < commonLength; pathPartIndex++ {
+		path1Part, path2Part := path1[pathPartIndex], path2[pathPartIndex]
+		path1PartIsDynamic, path2PartIsDynamic := isDynamicPathPart(path1Part), isDynamicPathPart(path2Part)
+		switch {
+		case path1PartIsDynamic && path2PartIsDynamic:
+		case path1PartIsDynamic || path2PartIsDynamic:
+			ambiguous = true
+		case path1Part != path2Part:
+			return NoOverlap
+		}
+	}
+
+	if path1IsWildcard != path2IsWildcard || len(path1) != len(path2) {
+		return ShadowingOverlap
+	}
+	if ambiguous {
+		return AmbiguityOverlap
+	}
+	return DuplicateOverlap
+}
+
+func trimEmptyPath(path PathParts) PathParts {
+	if len(path) == 1 && len(path[0]) == 0 {
+		return PathParts{}
+	}
+	return path
+}
+
+func isWildcardPath(path PathParts) bool {
+	return len(path) > 0 && path[len(path)-1] == "*"
+}
+
+func isDynamicPathPart(pathPart string) bool {
+	return strings.HasPrefix(pathPart, "{") && strings.HasSuffix(pathPart, "}")
+}
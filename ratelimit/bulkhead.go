@@ -0,0 +1,152 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bulkhead allows MaxInFlight requests at once. Up to MaxQueued more wait at most
+// QueueTimeout for one of them to finish, in the order they came; the rest are
+// rejected right away. The limits can change while requests are in flight, see
+// SetLimits.
+type Bulkhead struct {
+	// OnChange when set, is called with the changes of the requests in flight and
+	// queued, for gauges.
+	OnChange func(inFlightDelta, queuedDelta int)
+
+	mutex        sync.Mutex
+	maxInFlight  int
+	maxQueued    int
+	queueTimeout time.Duration
+	inFlight     int
+	queued       int
+	// waiters the queued requests, first come first; closing the channel of one
+	// hands it the slot of a request that finished.
+	waiters []chan struct{}
+}
+
+func NewBulkhead(maxInFlight int, maxQueued int, queueTimeout time.Duration) *Bulkhead {
+	return &Bulkhead{
+		maxInFlight:  maxInFlight,
+		maxQueued:    maxQueued,
+		queueTimeout: queueTimeout,
+	}
+}
+
+// BulkheadUsage the requests in flight and queued in a Bulkhead.
+type BulkheadUsage struct {
+	InFlight    int `json:"inFlight"`
+	MaxInFlight int `json:"maxInFlight"`
+	Queued      int `json:"queued"`
+	MaxQueued   int `json:"maxQueued"`
+}
+
+// SetLimits changes the limits of the bulkhead, keeping the requests in flight and
+// queued. A raised MaxInFlight admits the queued requests it makes room for; a
+// lowered one admits no more until enough of the requests in flight finish.
+func (b *Bulkhead) SetLimits(maxInFlight int, maxQueued int, queueTimeout time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.maxInFlight = maxInFlight
+	b.maxQueued = maxQueued
+	b.queueTimeout = queueTimeout
+	for b.inFlight < b.maxInFlight && len(b.waiters) > 0 {
+		b.inFlight++
+		b.handOver()
+	}
+}
+
+// Limits the limits of the bulkhead, as set last.
+func (b *Bulkhead) Limits() (maxInFlight int, maxQueued int, queueTimeout time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.maxInFlight, b.maxQueued, b.queueTimeout
+}
+
+// Acquire a slot, waiting in the queue when there is room, until ctx is done or the
+// queue timeout. The release function must be called once the request is served.
+func (b *Bulkhead) Acquire(ctx context.Context) (release func(), acquired bool) {
+	b.mutex.Lock()
+	if b.inFlight < b.maxInFlight {
+		b.inFlight++
+		b.mutex.Unlock()
+		b.notify(1, 0)
+		return b.releaseFn(), true
+	}
+	if b.queued >= b.maxQueued {
+		b.mutex.Unlock()
+		return nil, false
+	}
+	b.queued++
+	waiter := make(chan struct{})
+	b.waiters = append(b.waiters, waiter)
+	timer := time.NewTimer(b.queueTimeout)
+	b.mutex.Unlock()
+	b.notify(0, 1)
+
+	defer timer.Stop()
+	select {
+	case <-waiter:
+		b.notify(1, -1)
+		return b.releaseFn(), true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	b.mutex.Lock()
+	for i, queued := range b.waiters {
+		if queued == waiter {
+			b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+			b.queued--
+			b.mutex.Unlock()
+			b.notify(0, -1)
+			return nil, false
+		}
+	}
+	// The slot was handed over as the wait ended.
+	b.mutex.Unlock()
+	b.notify(1, -1)
+	return b.releaseFn(), true
+}
+
+// handOver gives a slot to the first queued request. The mutex must be held.
+func (b *Bulkhead) handOver() {
+	waiter := b.waiters[0]
+	b.waiters = b.waiters[1:]
+	b.queued--
+	close(waiter)
+}
+
+func (b *Bulkhead) releaseFn() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			if b.inFlight <= b.maxInFlight && len(b.waiters) > 0 {
+				b.handOver()
+			} else {
+				b.inFlight--
+			}
+			b.mutex.Unlock()
+			b.notify(-1, 0)
+		})
+	}
+}
+
+func (b *Bulkhead) notify(inFlightDelta, queuedDelta int) {
+	if b.OnChange != nil {
+		b.OnChange(inFlightDelta, queuedDelta)
+	}
+}
+
+func (b *Bulkhead) Usage() BulkheadUsage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return BulkheadUsage{
+		InFlight:    b.inFlight,
+		MaxInFlight: b.maxInFlight,
+		Queued:      b.queued,
+		MaxQueued:   b.maxQueued,
+	}
+}
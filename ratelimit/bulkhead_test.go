@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBulkheadSetLimits(t *testing.T) {
+	bulkhead := NewBulkhead(1, 1, time.Second)
+	release, acquired := bulkhead.Acquire(context.Background())
+	if !acquired {
+		t.Fatal("first request rejected")
+	}
+
+	handedOver := make(chan func())
+	go func() {
+		queuedRelease, queuedAcquired := bulkhead.Acquire(context.Background())
+		if !queuedAcquired {
+			queuedRelease = nil
+		}
+		handedOver <- queuedRelease
+	}()
+	for bulkhead.Usage().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, acquired := bulkhead.Acquire(context.Background()); acquired {
+		t.Fatal("request over the queue acquired")
+	}
+
+	// Raising the limit admits the queued request, keeping the one in flight.
+	bulkhead.SetLimits(2, 1, time.Second)
+	queuedRelease := <-handedOver
+	if queuedRelease == nil {
+		t.Fatal("queued request rejected")
+	}
+	if usage := bulkhead.Usage(); usage.InFlight != 2 || usage.Queued != 0 || usage.MaxInFlight != 2 {
+		t.Fatal(usage)
+	}
+
+	// Lowering it admits no more until the requests in flight are below it.
+	bulkhead.SetLimits(1, 0, time.Second)
+	release()
+	if _, acquired := bulkhead.Acquire(context.Background()); acquired {
+		t.Fatal("request over the lowered limit acquired")
+	}
+	queuedRelease()
+	queuedRelease()
+	if usage := bulkhead.Usage(); usage.InFlight != 0 {
+		t.Fatal(usage)
+	}
+	if _, acquired := bulkhead.Acquire(context.Background()); !acquired {
+		t.Fatal("request under the lowered limit rejected")
+	}
+}
+
+func TestBulkheadQueueTimeout(t *testing.T) {
+	bulkhead := NewBulkhead(1, 1, 10*time.Millisecond)
+	release, _ := bulkhead.Acquire(context.Background())
+	defer release()
+	if _, acquired := bulkhead.Acquire(context.Background()); acquired {
+		t.Fatal("queued request acquired while the slot was held")
+	}
+	if usage := bulkhead.Usage(); usage.Queued != 0 || usage.InFlight != 1 {
+		t.Fatal(usage)
+	}
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+// start the beginning of the period that contains t, in UTC.
+func (p Period) start(t time.Time) time.Time {
+	t = t.UTC()
+	if p == Monthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (p Period) next(start time.Time) time.Time {
+	if p == Monthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Quota counts requests in calendar periods and allows up to Limit of them.
+type Quota struct {
+	Period Period
+
+	mutex       sync.Mutex
+	limit       int64
+	used        int64
+	periodStart time.Time
+}
+
+func NewQuota(period Period, limit int64) *Quota {
+	return &Quota{
+		Period: period,
+		limit:  limit,
+	}
+}
+
+// SetLimit changes the limit, keeping the usage of the current period.
+func (q *Quota) SetLimit(limit int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.limit = limit
+}
+
+// Take counts a request if the quota is not exhausted.
+func (q *Quota) Take(now time.Time) Status {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.roll(now)
+
+	status := Status{Limit: q.limit}
+	if q.used < q.limit {
+		q.used++
+		status.Allowed = true
+	}
+	status.Remaining = q.limit - q.used
+	status.Reset = q.Period.next(q.periodStart).Sub(now)
+	return status
+}
+
+// Usage reports the status without counting a request.
+type Usage struct {
+	Period      Period    `json:"period"`
+	Limit       int64     `json:"limit"`
+	Used        int64     `json:"used"`
+	PeriodStart time.Time `json:"periodStart"`
+	Reset       time.Time `json:"reset"`
+}
+
+func (q *Quota) Usage(now time.Time) Usage {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.roll(now)
+	return Usage{
+		Period:      q.Period,
+		Limit:       q.limit,
+		Used:        q.used,
+		PeriodStart: q.periodStart,
+		Reset:       q.Period.next(q.periodStart),
+	}
+}
+
+func (q *Quota) roll(now time.Time) {
+	periodStart := q.Period.start(now)
+	if !periodStart.Equal(q.periodStart) {
+		q.periodStart = periodStart
+		q.used = 0
+	}
+}
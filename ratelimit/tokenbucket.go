@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket allows Burst requests at once, refilled at Rate requests per second.
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: float64(burst),
+	}
+}
+
+// Status the outcome of taking from a limiter, in the terms of the RateLimit headers.
+type Status struct {
+	Allowed   bool          `json:"allowed"`
+	Limit     int64         `json:"limit"`
+	Remaining int64         `json:"remaining"`
+	Reset     time.Duration `json:"-"`
+}
+
+// Take consumes a token if there is one.
+func (b *TokenBucket) Take(now time.Time) Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+
+	status := Status{Limit: int64(b.Burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		status.Allowed = true
+	}
+	status.Remaining = int64(math.Floor(b.tokens))
+	if b.Rate > 0 {
+		missingTokens := float64(b.Burst) - b.tokens
+		status.Reset = time.Duration(missingTokens / b.Rate * float64(time.Second))
+	}
+	return status
+}
+
+// Peek reports the status without consuming a token.
+func (b *TokenBucket) Peek(now time.Time) Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	return Status{
+		Allowed:   b.tokens >= 1,
+		Limit:     int64(b.Burst),
+		Remaining: int64(math.Floor(b.tokens)),
+	}
+}
+
+// full reports whether the bucket is back to its burst, so it can be forgotten.
+func (b *TokenBucket) full(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	return b.tokens >= float64(b.Burst)
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		elapsed := now.Sub(b.last).Seconds()
+		if elapsed > 0 {
+			b.tokens = math.Min(float64(b.Burst), b.tokens+elapsed*b.Rate)
+		}
+	}
+	b.last = now
+}
+
+// KeyedTokenBuckets one TokenBucket per key, such as a client IP. When MaxKeys is
+// reached, the buckets that are full again are forgotten; if none is, new keys share
+// a single overflow bucket.
+type KeyedTokenBuckets struct {
+	Rate    float64
+	Burst   int
+	MaxKeys int
+
+	mutex    sync.Mutex
+	buckets  map[string]*TokenBucket
+	overflow *TokenBucket
+}
+
+func NewKeyedTokenBuckets(rate float64, burst int, maxKeys int) *KeyedTokenBuckets {
+	return &KeyedTokenBuckets{
+		Rate:     rate,
+		Burst:    burst,
+		MaxKeys:  maxKeys,
+		buckets:  make(map[string]*TokenBucket),
+		overflow: NewTokenBucket(rate, burst),
+	}
+}
+
+func (k *KeyedTokenBuckets) Take(key string, now time.Time) Status {
+	return k.bucket(key, now).Take(now)
+}
+
+// Len the number of keys being tracked.
+func (k *KeyedTokenBuckets) Len() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return len(k.buckets)
+}
+
+func (k *KeyedTokenBuckets) bucket(key string, now time.Time) *TokenBucket {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if bucket, found := k.buckets[key]; found {
+		return bucket
+	}
+
+	if k.MaxKeys > 0 && len(k.buckets) >= k.MaxKeys {
+		for bucketKey, bucket := range k.buckets {
+			if bucket.full(now) {
+				delete(k.buckets, bucketKey)
+			}
+		}
+		if len(k.buckets) >= k.MaxKeys {
+			return k.overflow
+		}
+	}
+
+	bucket := NewTokenBucket(k.Rate, k.Burst)
+	k.buckets[key] = bucket
+	return bucket
+}
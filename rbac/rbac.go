@@ -0,0 +1,241 @@
+package rbac
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/rbac"
+
+// Roles of the principals of the management API.
+const (
+	// Admin does everything, including stopping the server and changing connectors
+	// and certificates.
+	Admin = "admin"
+	// Operator reads everything and changes tenants and templates.
+	Operator = "operator"
+	// ReadOnly reads everything.
+	ReadOnly = "read-only"
+	// TenantAdminPrefix prefixes the tenant of a tenant-admin:{tenantId} role, which
+	// reads and changes that tenant only.
+	TenantAdminPrefix = "tenant-admin:"
+)
+
+func TenantAdmin(tenantID string) string {
+	return TenantAdminPrefix + tenantID
+}
+
+func validateRole(role string) error {
+	switch role {
+	case Admin, Operator, ReadOnly:
+		return nil
+	}
+	if strings.HasPrefix(role, TenantAdminPrefix) && len(role) > len(TenantAdminPrefix) {
+		return nil
+	}
+	return validation.Invalidf("mustBeRole", "%q", role)
+}
+
+// PrincipalConfig a caller of the management API: its roles, and how it
+// authenticates, with a bearer token, a client certificate, or both.
+type PrincipalConfig struct {
+	Roles []string `json:"roles"`
+	// Token where the bearer token of the principal is read from.
+	Token *multitenancy.SecretConfig `json:"token,omitempty"`
+	// Subjects the common names of the client certificates of the principal, verified
+	// against the clientCaFile of the management connector.
+	Subjects []string `json:"subjects,omitempty"`
+}
+
+func (c PrincipalConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c PrincipalConfig) RefineJSONSchema(s *schema.Schema) {
+	s.Property("roles").MinItems = schema.Int(1)
+	s.Property("roles").Items = &schema.Schema{
+		Type: "string",
+		AnyOf: []*schema.Schema{
+			{Enum: []interface{}{Admin, Operator, ReadOnly}},
+			{Pattern: "^" + TenantAdminPrefix + ".+$"},
+		},
+	}
+	s.Property("subjects").Items = &schema.Schema{Type: "string", MinLength: schema.Int(1)}
+	s.AnyOf = []*schema.Schema{
+		{Required: []string{"token"}},
+		{Required: []string{"subjects"}, Properties: map[string]*schema.Schema{"subjects": {MinItems: schema.Int(1)}}},
+	}
+}
+
+func (c PrincipalConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("roles", c.Roles != nil) && len(c.Roles) == 0 {
+		v.Field("roles").Fail("mustNotBeEmpty")
+	}
+	for i, role := range c.Roles {
+		v.Field("roles").Index(i).Add(validateRole(role))
+	}
+	if c.Token == nil && len(c.Subjects) == 0 {
+		v.Fail("tokenOrSubjectsRequired")
+	}
+	if c.Token != nil {
+		v.Field("token").Check(c.Token)
+	}
+	for i, subject := range c.Subjects {
+		if subject == "" {
+			v.Field("subjects").Index(i).Fail("mustNotBeEmpty")
+		}
+	}
+}
+
+// Config the principals of the management API, by name.
+type Config struct {
+	Principals map[string]PrincipalConfig `json:"principals"`
+}
+
+func (c Config) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c Config) ValidateWith(v *validation.Validator) {
+	if !v.Required("principals", c.Principals != nil) {
+		return
+	}
+	subjects := make(map[string]string)
+	for _, name := range validation.SortedKeys(c.Principals) {
+		principalValidator := v.Field("principals").Field(name)
+		principalValidator.Check(c.Principals[name])
+		for i, subject := range c.Principals[name].Subjects {
+			if other, found := subjects[subject]; found {
+				principalValidator.Field("subjects").Index(i).Failf("mustBeUnique", "also a subject of \"%s\"", other)
+				continue
+			}
+			subjects[subject] = name
+		}
+	}
+}
+
+func (c Config) String() string {
+	return util.ToJson(c)
+}
+
+// Build the Policy of the config, reading the tokens of the principals.
+func (c Config) Build() (*Policy, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	policy := &Policy{
+		subjects: make(map[string]Principal),
+	}
+	for _, name := range validation.SortedKeys(c.Principals) {
+		config := c.Principals[name]
+		principal := Principal{Name: name, Roles: config.Roles}
+		if config.Token != nil {
+			token, err := config.Token.Resolve()
+			if err != nil {
+				return nil, fmt.Errorf(TRACE+" Config Build principals \"%s\" token: %s", name, err)
+			}
+			policy.tokens = append(policy.tokens, principalToken{principal: principal, token: []byte(token.Reveal())})
+		}
+		for _, subject := range config.Subjects {
+			policy.subjects[subject] = principal
+		}
+	}
+	return policy, nil
+}
+
+type principalToken struct {
+	principal Principal
+	token     []byte
+}
+
+// Policy authenticates the callers of the management API.
+type Policy struct {
+	tokens   []principalToken
+	subjects map[string]Principal
+}
+
+// Authenticate the principal of the bearer token of the request, or else of its
+// verified client certificate.
+func (p *Policy) Authenticate(r *http.Request) (Principal, bool) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		scheme, token, _ := strings.Cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, false
+		}
+		// Every token is compared, so the time taken does not tell which one matched.
+		var authenticated *Principal
+		for i := range p.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), p.tokens[i].token) == 1 && authenticated == nil {
+				authenticated = &p.tokens[i].principal
+			}
+		}
+		if authenticated == nil {
+			return Principal{}, false
+		}
+		return *authenticated, true
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		principal, found := p.subjects[r.TLS.VerifiedChains[0][0].Subject.CommonName]
+		return principal, found
+	}
+	return Principal{}, false
+}
+
+// Principal an authenticated caller.
+type Principal struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+func (p Principal) String() string {
+	return util.ToJson(p)
+}
+
+func (p Principal) Has(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Principal) IsAdmin() bool {
+	return p.Has(Admin)
+}
+
+// CanOperate changes tenants and templates.
+func (p Principal) CanOperate() bool {
+	return p.Has(Admin) || p.Has(Operator)
+}
+
+// CanRead reads everything.
+func (p Principal) CanRead() bool {
+	return p.CanOperate() || p.Has(ReadOnly)
+}
+
+func (p Principal) CanReadTenant(tenantID string) bool {
+	return p.CanRead() || p.Has(TenantAdmin(tenantID))
+}
+
+func (p Principal) CanChangeTenant(tenantID string) bool {
+	return p.CanOperate() || p.Has(TenantAdmin(tenantID))
+}
+
+// Tenants the tenants the principal administers through tenant-admin roles.
+func (p Principal) Tenants() []string {
+	tenantIDs := make([]string, 0)
+	for _, role := range p.Roles {
+		if strings.HasPrefix(role, TenantAdminPrefix) {
+			tenantIDs = append(tenantIDs, strings.TrimPrefix(role, TenantAdminPrefix))
+		}
+	}
+	return tenantIDs
+}
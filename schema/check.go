@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// Check validates a JSON document against s. Only the keywords Schema has are checked,
+// and the formats the schemas use: "uri", "ipv4" and "ipv6".
+func (s *Schema) Check(document []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var instance interface{}
+	if err := decoder.Decode(&instance); err != nil {
+		return fmt.Errorf(TRACE+" Schema Check: %s", err)
+	}
+	v := validation.NewValidator()
+	s.check(v, instance)
+	return v.Err()
+}
+
+func (s *Schema) check(v *validation.Validator, instance interface{}) {
+	if s.Type != nil && !matchesType(s.Type, instance) {
+		v.Failf("type", "%v", s.Type)
+		return
+	}
+	if s.Enum != nil && !inEnum(s.Enum, instance) {
+		v.Fail("enum")
+	}
+	if s.AnyOf != nil {
+		matched := false
+		for _, alternative := range s.AnyOf {
+			alternativeValidator := validation.NewValidator()
+			alternative.check(alternativeValidator, instance)
+			if alternativeValidator.Err() == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.Fail("anyOf")
+		}
+	}
+	if s.OneOf != nil {
+		matched := 0
+		for _, alternative := range s.OneOf {
+			alternativeValidator := validation.NewValidator()
+			alternative.check(alternativeValidator, instance)
+			if alternativeValidator.Err() == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.Fail("oneOf")
+		}
+	}
+
+	switch value := instance.(type) {
+	case map[string]interface{}:
+		s.checkObject(v, value)
+	case []interface{}:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			v.Fail("minItems")
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				s.Items.check(v.Index(i), item)
+			}
+		}
+	case string:
+		s.checkString(v, value)
+	case json.Number:
+		f, _ := value.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			v.Fail("minimum")
+		}
+		if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+			v.Fail("exclusiveMinimum")
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			v.Fail("maximum")
+		}
+	}
+}
+
+func (s *Schema) checkObject(v *validation.Validator, object map[string]interface{}) {
+	for _, name := range s.Required {
+		if _, found := object[name]; !found {
+			v.Field(name).Fail("required")
+		}
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if s.PropertyNames != nil {
+			s.PropertyNames.check(v.Field(name), name)
+		}
+		if property, found := s.Properties[name]; found {
+			property.check(v.Field(name), object[name])
+			continue
+		}
+		switch additional := s.AdditionalProperties.(type) {
+		case bool:
+			if !additional {
+				v.Field(name).Fail("additionalProperties")
+			}
+		case *Schema:
+			additional.check(v.Field(name), object[name])
+		}
+	}
+}
+
+func (s *Schema) checkString(v *validation.Validator, value string) {
+	if s.MinLength != nil && utf8.RuneCountInString(value) < *s.MinLength {
+		v.Fail("minLength")
+	}
+	if s.Pattern != "" {
+		if matched, err := regexp.MatchString(s.Pattern, value); err != nil || !matched {
+			v.Fail("pattern")
+		}
+	}
+	switch s.Format {
+	case "uri":
+		if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+			v.Fail("format")
+		}
+	case "ipv4":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			v.Fail("format")
+		}
+	case "ipv6":
+		if ip := net.ParseIP(value); ip == nil || ip.To4() != nil {
+			v.Fail("format")
+		}
+	}
+}
+
+func matchesType(schemaType interface{}, instance interface{}) bool {
+	switch t := schemaType.(type) {
+	case string:
+		return matchesTypeName(t, instance)
+	case []string:
+		for _, name := range t {
+			if matchesTypeName(name, instance) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesTypeName(name string, instance interface{}) bool {
+	switch value := instance.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case json.Number:
+		if name == "number" {
+			return true
+		}
+		f, err := value.Float64()
+		return name == "integer" && err == nil && f == math.Trunc(f)
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	}
+	return false
+}
+
+func inEnum(enum []interface{}, instance interface{}) bool {
+	instanceJson, _ := json.Marshal(instance)
+	for _, allowed := range enum {
+		if allowedJson, _ := json.Marshal(allowed); bytes.Equal(allowedJson, instanceJson) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/schema"
+
+// Draft202012 the meta-schema of the generated schemas.
+const Draft202012 = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema the subset of JSON Schema draft 2020-12 the config types are described with.
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type a type name, or a list of type names.
+	Type       interface{}        `json:"type,omitempty"`
+	Enum       []interface{}      `json:"enum,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	// AdditionalProperties a *Schema, or false.
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	PropertyNames        *Schema     `json:"propertyNames,omitempty"`
+	Items                *Schema     `json:"items,omitempty"`
+	MinItems             *int        `json:"minItems,omitempty"`
+	MinLength            *int        `json:"minLength,omitempty"`
+	Minimum              *float64    `json:"minimum,omitempty"`
+	ExclusiveMinimum     *float64    `json:"exclusiveMinimum,omitempty"`
+	Maximum              *float64    `json:"maximum,omitempty"`
+	Pattern              string      `json:"pattern,omitempty"`
+	Format               string      `json:"format,omitempty"`
+	ContentEncoding      string      `json:"contentEncoding,omitempty"`
+	AnyOf                []*Schema   `json:"anyOf,omitempty"`
+	OneOf                []*Schema   `json:"oneOf,omitempty"`
+}
+
+func (s Schema) String() string {
+	return util.ToJson(s)
+}
+
+// Describer a type that describes its own schema, such as a string that must be an
+// URL.
+type Describer interface {
+	JSONSchema() *Schema
+}
+
+// Refiner a struct that adds to its generated schema the constraints its fields do not
+// tell, such as a number that must be positive.
+type Refiner interface {
+	RefineJSONSchema(s *Schema)
+}
+
+// Generate the schema of the type of value, from its JSON tags. The required members
+// of a struct are the ones its ValidateWith reports as "required" when they are
+// missing, so that they are always the ones Validate requires.
+func Generate(value interface{}, title string) *Schema {
+	s := generate(reflect.TypeOf(value))
+	s.Schema = Draft202012
+	s.Title = title
+	return s
+}
+
+var (
+	describerType = reflect.TypeOf((*Describer)(nil)).Elem()
+	refinerType   = reflect.TypeOf((*Refiner)(nil)).Elem()
+	bytesType     = reflect.TypeOf([]byte(nil))
+	rawType       = reflect.TypeOf(json.RawMessage(nil))
+)
+
+func generate(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Implements(describerType) {
+		return reflect.Zero(t).Interface().(Describer).JSONSchema()
+	}
+	if t == rawType {
+		return &Schema{}
+	}
+	if t == bytesType {
+		return &Schema{Type: "string", ContentEncoding: "base64"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: Float(0)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: generate(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: generate(t.Elem())}
+	case reflect.Struct:
+		return generateStruct(t)
+	}
+	return &Schema{}
+}
+
+func generateStruct(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: false,
+	}
+	required := requiredFields(t)
+	addFields(s, t, required)
+	s.Required = make([]string, 0, len(required))
+	for name := range required {
+		if _, found := s.Properties[name]; found {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	if len(s.Required) == 0 {
+		s.Required = nil
+	}
+	if t.Implements(refinerType) {
+		reflect.Zero(t).Interface().(Refiner).RefineJSONSchema(s)
+	}
+	return s
+}
+
+func addFields(s *Schema, t reflect.Type, required map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addFields(s, field.Type, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fieldSchema := generate(field.Type)
+		if !required[name] && isNullable(field.Type) {
+			fieldSchema = nullable(fieldSchema)
+		}
+		s.Properties[name] = fieldSchema
+	}
+}
+
+// requiredFields the members ValidateWith reports as required in a zero value.
+func requiredFields(t reflect.Type) map[string]bool {
+	required := make(map[string]bool)
+	validatable, ok := reflect.Zero(t).Interface().(validation.Validatable)
+	if !ok {
+		return required
+	}
+	v := validation.NewValidator()
+	validatable.ValidateWith(v)
+	for _, err := range v.Errors() {
+		token := strings.TrimPrefix(err.Pointer, "/")
+		if err.Code == "required" && err.Pointer != "" && !strings.Contains(token, "/") {
+			required[strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")] = true
+		}
+	}
+	return required
+}
+
+func isNullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+// nullable s, also allowing null, as Go encodes a missing optional member.
+func nullable(s *Schema) *Schema {
+	switch t := s.Type.(type) {
+	case string:
+		if s.Enum == nil && s.AnyOf == nil && s.OneOf == nil {
+			s.Type = []string{t, "null"}
+			return s
+		}
+	case nil:
+		return s
+	}
+	return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+}
+
+// Property the schema of a member of an object schema, nil when there is none. A
+// nullable member is returned without null.
+func (s *Schema) Property(name string) *Schema {
+	property := s.Properties[name]
+	if property != nil && property.AnyOf != nil && len(property.AnyOf) == 2 && property.AnyOf[1].Type == "null" {
+		return property.AnyOf[0]
+	}
+	return property
+}
+
+func Float(f float64) *float64 {
+	return &f
+}
+
+func Int(i int) *int {
+	return &i
+}
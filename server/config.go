@@ -1,11 +1,15 @@
 package server
 
 import (
-	"net"
-
+	"crypto/x509"
 	"fmt"
+	"net"
+	"os"
 
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/tenantresolver"
 	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
 )
 
 const (
@@ -18,15 +22,25 @@ type BindIpAddress string
 
 // Set setter for ExistingFile
 func (addr BindIpAddress) Validate() error {
+	return validation.Validate(addr)
+}
+
+func (addr BindIpAddress) JSONSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:  "string",
+		AnyOf: []*schema.Schema{{Format: "ipv4"}, {Format: "ipv6"}},
+	}
+}
+
+func (addr BindIpAddress) ValidateWith(v *validation.Validator) {
 	if string(addr) == "" {
-		return fmt.Errorf(TRACE + " BindIpAddress: required")
+		v.Fail("required")
+		return
 	}
 	ip := net.ParseIP(string(addr))
 	if ip == nil {
-		return fmt.Errorf(TRACE + " BindIpAddress: validity")
+		v.Failf("validity", "%q", string(addr))
 	}
-
-	return nil
 }
 
 // TCPPort a string representing a existing file path
@@ -34,16 +48,29 @@ type TCPPort uint16
 
 // Set setter for ExistingFile
 func (tp TCPPort) Validate() error {
+	return validation.Validate(tp)
+}
+
+func (tp TCPPort) JSONSchema() *schema.Schema {
+	return &schema.Schema{Type: "integer", Minimum: schema.Float(1), Maximum: schema.Float(65535)}
+}
+
+func (tp TCPPort) ValidateWith(v *validation.Validator) {
 	if tp < 1 || tp > 65535 {
-		return fmt.Errorf(TRACE + " TCPPort: rangeValidity")
+		v.Failf("rangeValidity", "%d", tp)
 	}
-	return nil
 }
 
 type ConnectorConfig struct {
 	BindAddress *BindIpAddress `json:"bindAddress"`
 	Port        *TCPPort       `json:"port"`
 	TLS         *bool          `json:"tls"`
+	// ClientCaFile the PEM certificates client certificates are verified against; a
+	// client without a certificate is still accepted.
+	ClientCaFile *string `json:"clientCaFile,omitempty"`
+	// TenantResolver finds the tenant of the requests to the server endpoints of the
+	// connector that do not have their own.
+	TenantResolver *tenantresolver.Config `json:"tenantResolver,omitempty"`
 }
 
 func NewConnectorConfig(bindAddress string, port uint16, tls bool) *ConnectorConfig {
@@ -59,22 +86,47 @@ func (c ConnectorConfig) String() string {
 }
 
 func (c ConnectorConfig) Validate() error {
-	if c.BindAddress == nil {
-		return fmt.Errorf(TRACE + " ConnectorConfig BindAddress: required")
+	return validation.Validate(c)
+}
+
+func (c ConnectorConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("bindAddress", c.BindAddress != nil) {
+		v.Field("bindAddress").Check(c.BindAddress)
+	}
+	if v.Required("port", c.Port != nil) {
+		v.Field("port").Check(c.Port)
 	}
-	if err := c.BindAddress.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" ConnectorConfig BindAddress: %s", err)
+	v.Required("tls", c.TLS != nil)
+	if c.ClientCaFile != nil {
+		if c.TLS != nil && !*c.TLS {
+			v.Field("clientCaFile").Fail("requiresTls")
+		}
+		if _, err := c.ClientCAs(); err != nil {
+			v.Field("clientCaFile").Failf("mustBePemCertificates", "%q", *c.ClientCaFile)
+		}
 	}
-	if c.Port == nil {
-		return fmt.Errorf(TRACE + " ConnectorConfig Port: required")
+	if c.TenantResolver != nil {
+		v.Field("tenantResolver").Check(c.TenantResolver)
+		if c.TenantResolver.Kind != nil && *c.TenantResolver.Kind == "clientCertificate" && c.ClientCaFile == nil {
+			v.Field("tenantResolver").Fail("requiresClientCaFile")
+		}
+	}
+}
+
+// ClientCAs the certificates of ClientCaFile, nil when it is not set.
+func (c ConnectorConfig) ClientCAs() (*x509.CertPool, error) {
+	if c.ClientCaFile == nil {
+		return nil, nil
 	}
-	if err := c.Port.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" ConnectorConfig Port: %s", err)
+	pem, err := os.ReadFile(*c.ClientCaFile)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" ConnectorConfig ClientCAs clientCaFile: mustBeReadable \"%s\"", *c.ClientCaFile)
 	}
-	if c.TLS == nil {
-		return fmt.Errorf(TRACE + " ConnectorConfig TLS: required")
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf(TRACE+" ConnectorConfig ClientCAs clientCaFile: mustBePemCertificates \"%s\"", *c.ClientCaFile)
 	}
-	return nil
+	return pool, nil
 }
 
 type ConnectorsConfig map[string]ConnectorConfig
@@ -88,12 +140,13 @@ func (c ConnectorsConfig) String() string {
 }
 
 func (c ConnectorsConfig) Validate() error {
-	for k, v := range c {
-		if err := v.Validate(); err != nil {
-			return fmt.Errorf(TRACE+" ConnectorsConfig \"%s\": %s", k, err)
-		}
+	return validation.Validate(c)
+}
+
+func (c ConnectorsConfig) ValidateWith(v *validation.Validator) {
+	for _, k := range validation.SortedKeys(c) {
+		v.Field(k).Check(c[k])
 	}
-	return nil
 }
 
 type ServerConfig struct {
@@ -111,11 +164,11 @@ func (c ServerConfig) String() string {
 }
 
 func (sc ServerConfig) Validate() error {
-	if sc.Connectors == nil {
-		return fmt.Errorf(TRACE + " ServerConfig Connectors: required")
-	}
-	if err := sc.Connectors.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" ServerConfig Connectors: %s", err)
+	return validation.Validate(sc)
+}
+
+func (sc ServerConfig) ValidateWith(v *validation.Validator) {
+	if v.Required("connectors", sc.Connectors != nil) {
+		v.Field("connectors").Check(sc.Connectors)
 	}
-	return nil
 }
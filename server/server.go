@@ -11,10 +11,17 @@ import (
 
 const TRACE = "github.com/riotemergence/godynamicweb/server"
 
+// ALPNChallengeProtocol the protocol of the TLS-ALPN-01 validations, RFC 8737.
+const ALPNChallengeProtocol = "acme-tls/1"
+
 type Server struct {
 	Config                     *ServerConfig
 	DoneAndErrorChannel        chan error
 	RunningEndpointsConnectors map[string]*Connector
+	// TLSALPN01Certificate when set, the challenge certificate of a TLS-ALPN-01
+	// validation hello, if one is in progress for its server name. ALPNChallengeProtocol
+	// is only offered then.
+	TLSALPN01Certificate func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, bool)
 }
 
 type Connector struct {
@@ -83,6 +90,13 @@ func (s *Server) AddConnector(connectorName string, config ConnectorConfig, mux
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		TLSConfig:      tlsConfig,
+		// A validation is over once the handshake presented the challenge
+		// certificate; no request is served on it.
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){
+			ALPNChallengeProtocol: func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+				conn.Close()
+			},
+		},
 	}
 
 	var connectorServerListener net.Listener
@@ -90,7 +104,15 @@ func (s *Server) AddConnector(connectorName string, config ConnectorConfig, mux
 		var err error
 		if config.TLS != nil && *config.TLS {
 			tlsConfig = &tls.Config{
-				GetCertificate: getCertificateFunc,
+				GetCertificate:     getCertificateFunc,
+				GetConfigForClient: s.getTLSALPN01Config,
+				NextProtos:         []string{"http/1.1"},
+			}
+			if tlsConfig.ClientCAs, err = config.ClientCAs(); err != nil {
+				return err
+			}
+			if tlsConfig.ClientCAs != nil {
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 			connectorServerListener, err = tls.Listen("tcp", connectorServerAddr, tlsConfig)
 			if err != nil {
@@ -189,6 +211,23 @@ func (s *Server) AddConnector(connectorName string, config ConnectorConfig, mux
 // 	}
 // }
 
+// getTLSALPN01Config the config of a TLS-ALPN-01 validation hello, that negotiates
+// ALPNChallengeProtocol with the challenge certificate. Any other hello gets the
+// config of the connector.
+func (s *Server) getTLSALPN01Config(clientHello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if s.TLSALPN01Certificate == nil || len(clientHello.SupportedProtos) != 1 || clientHello.SupportedProtos[0] != ALPNChallengeProtocol {
+		return nil, nil
+	}
+	certificate, found := s.TLSALPN01Certificate(clientHello)
+	if !found {
+		return nil, nil
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{*certificate},
+		NextProtos:   []string{ALPNChallengeProtocol},
+	}, nil
+}
+
 func (s *Server) RemoveConnector(connectorName string) error {
 	c, ok := s.RunningEndpointsConnectors[connectorName]
 	if !ok {
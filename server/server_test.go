@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func selfSignedCertificate(t *testing.T, name string) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{certificateBytes}, PrivateKey: key}
+}
+
+// freePort a port the system had free just now: one it bound for port 0, read back
+// from the listener.
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestALPNChallengeProtocol(t *testing.T) {
+	certificate := selfSignedCertificate(t, "a.test")
+	challengeCertificate := selfSignedCertificate(t, "challenge.test")
+	s := NewServer()
+	s.TLSALPN01Certificate = func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+		return challengeCertificate, clientHello.ServerName == "challenge.test"
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "served")
+	})
+	port := freePort(t)
+	address := "127.0.0.1:" + strconv.Itoa(int(port))
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return certificate, nil }
+	if err := s.AddConnector("secure", *NewConnectorConfig("127.0.0.1", port, true), handler, getCertificate); err != nil {
+		t.Fatal(err)
+	}
+	defer s.RemoveConnector("secure")
+
+	// No validation is in progress for a.test: acme-tls/1 is not offered.
+	if conn, err := tls.Dial("tcp", address, &tls.Config{ServerName: "a.test", NextProtos: []string{ALPNChallengeProtocol}, InsecureSkipVerify: true}); err == nil {
+		protocol := conn.ConnectionState().NegotiatedProtocol
+		conn.Close()
+		if protocol == ALPNChallengeProtocol {
+			t.Fatal("acme-tls/1 negotiated without a validation in progress")
+		}
+	}
+
+	// A validation gets the challenge certificate, and no request is served.
+	conn, err := tls.Dial("tcp", address, &tls.Config{ServerName: "challenge.test", NextProtos: []string{ALPNChallengeProtocol}, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != ALPNChallengeProtocol || state.PeerCertificates[0].Subject.CommonName != "challenge.test" {
+		t.Fatalf("negotiated %q with %s", state.NegotiatedProtocol, state.PeerCertificates[0].Subject)
+	}
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: challenge.test\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if response, _ := io.ReadAll(conn); len(response) > 0 {
+		t.Fatalf("served on a validation connection: %q", response)
+	}
+
+	// Other clients still get HTTP.
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	response, err := client.Get("https://" + address + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if body, _ := io.ReadAll(response.Body); string(body) != "served" {
+		t.Fatalf("served %q", body)
+	}
+}
@@ -0,0 +1,238 @@
+package tenantresolver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// DefaultHeader the header the "header" resolver reads by default.
+const DefaultHeader = "X-Tenant-ID"
+
+// headerParams the "header" resolver: the tenant is the value of a request header,
+// which a gateway in front of the server is trusted to set.
+type headerParams struct {
+	Name *string `json:"name,omitempty"`
+}
+
+func newHeader(params json.RawMessage, keys Keys) (Resolver, error) {
+	var p headerParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	name := DefaultHeader
+	if p.Name != nil {
+		if *p.Name == "" {
+			v := validation.NewValidator()
+			v.Field("name").Fail("mustNotBeEmpty")
+			return nil, v.Err()
+		}
+		name = *p.Name
+	}
+	return ResolverFunc(func(r *http.Request) (string, bool) {
+		tenantID := strings.TrimSpace(r.Header.Get(name))
+		return tenantID, tenantID != ""
+	}), nil
+}
+
+// jwtClaimParams the "jwtClaim" resolver: the tenant is a claim of the bearer token,
+// a JWT signed with HS256, HS384 or HS512 under the key read from KeyFile or KeyEnv,
+// which must be among the sources the resolver may read keys from.
+// A token with an invalid signature, expired or not yet valid resolves no tenant.
+type jwtClaimParams struct {
+	// Claim "tenant" by default.
+	Claim   *string `json:"claim,omitempty"`
+	KeyFile *string `json:"keyFile,omitempty"`
+	KeyEnv  *string `json:"keyEnv,omitempty"`
+}
+
+var jwtHashes = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+func newJwtClaim(params json.RawMessage, keys Keys) (Resolver, error) {
+	var p jwtClaimParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	v := validation.NewValidator()
+	claim := "tenant"
+	if p.Claim != nil {
+		if *p.Claim == "" {
+			v.Field("claim").Fail("mustNotBeEmpty")
+		}
+		claim = *p.Claim
+	}
+	var key []byte
+	switch {
+	case (p.KeyFile == nil) == (p.KeyEnv == nil):
+		v.Fail("keyFileOrKeyEnvRequired")
+	default:
+		field := "keyFile"
+		if p.KeyEnv != nil {
+			field = "keyEnv"
+		}
+		var err error
+		if key, err = keys(p.KeyFile, p.KeyEnv); err != nil {
+			v.Field(field).Failf("mustBeResolvable", "%s", err)
+		}
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, validation.Invalid("keyMustNotBeEmpty")
+	}
+
+	return ResolverFunc(func(r *http.Request) (string, bool) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found {
+			return "", false
+		}
+		claims, valid := verifyJwt(strings.TrimSpace(token), key, time.Now())
+		if !valid {
+			return "", false
+		}
+		tenantID, _ := claims[claim].(string)
+		return tenantID, tenantID != ""
+	}), nil
+}
+
+// verifyJwt the claims of a compact JWS token with a valid HMAC signature under key,
+// that is valid at now.
+func verifyJwt(token string, key []byte, now time.Time) (map[string]interface{}, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if !decodeJwtPart(parts[0], &header) {
+		return nil, false
+	}
+	newHash, found := jwtHashes[header.Alg]
+	if !found {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, false
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, false
+	}
+
+	var claims map[string]interface{}
+	if !decodeJwtPart(parts[1], &claims) {
+		return nil, false
+	}
+	if exp, found := claims["exp"].(float64); found && now.Unix() >= int64(exp) {
+		return nil, false
+	}
+	if nbf, found := claims["nbf"].(float64); found && now.Unix() < int64(nbf) {
+		return nil, false
+	}
+	return claims, true
+}
+
+func decodeJwtPart(part string, v interface{}) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	return err == nil && json.Unmarshal(decoded, v) == nil
+}
+
+// clientCertificateParams the "clientCertificate" resolver: the tenant is a field of
+// the client certificate, which the connector verified against its clientCaFile.
+type clientCertificateParams struct {
+	// Field "commonName" by default, or "organization", "organizationalUnit",
+	// "dnsName", "emailAddress" or "uri", whose first value is used.
+	Field *string `json:"field,omitempty"`
+}
+
+func newClientCertificate(params json.RawMessage, keys Keys) (Resolver, error) {
+	var p clientCertificateParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	field := "commonName"
+	if p.Field != nil {
+		field = *p.Field
+	}
+	switch field {
+	case "commonName", "organization", "organizationalUnit", "dnsName", "emailAddress", "uri":
+	default:
+		v := validation.NewValidator()
+		v.Field("field").Failf("mustBeKnownField", "%q", field)
+		return nil, v.Err()
+	}
+
+	return ResolverFunc(func(r *http.Request) (string, bool) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return "", false
+		}
+		certificate := r.TLS.VerifiedChains[0][0]
+		values := make([]string, 0, 1)
+		switch field {
+		case "commonName":
+			values = append(values, certificate.Subject.CommonName)
+		case "organization":
+			values = certificate.Subject.Organization
+		case "organizationalUnit":
+			values = certificate.Subject.OrganizationalUnit
+		case "dnsName":
+			values = certificate.DNSNames
+		case "emailAddress":
+			values = certificate.EmailAddresses
+		case "uri":
+			for _, uri := range certificate.URIs {
+				values = append(values, uri.String())
+			}
+		}
+		if len(values) == 0 || values[0] == "" {
+			return "", false
+		}
+		return values[0], true
+	}), nil
+}
+
+// pathPrefixParams the "pathPrefix" resolver: the tenant is the path segment that
+// follows Prefix, such as "acme" in /t/acme/orders for the prefix "/t/".
+type pathPrefixParams struct {
+	Prefix *string `json:"prefix"`
+}
+
+func newPathPrefix(params json.RawMessage, keys Keys) (Resolver, error) {
+	var p pathPrefixParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	v := validation.NewValidator()
+	if v.Required("prefix", p.Prefix != nil) && (!strings.HasPrefix(*p.Prefix, "/") || !strings.HasSuffix(*p.Prefix, "/")) {
+		v.Field("prefix").Failf("mustStartAndEndWithSlash", "%q", *p.Prefix)
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	prefix := *p.Prefix
+
+	return ResolverFunc(func(r *http.Request) (string, bool) {
+		rest, found := strings.CutPrefix(r.URL.Path, prefix)
+		if !found {
+			return "", false
+		}
+		tenantID, _, _ := strings.Cut(rest, "/")
+		return tenantID, tenantID != ""
+	}), nil
+}
@@ -0,0 +1,148 @@
+package tenantresolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/tenantresolver"
+
+// Resolver finds the tenant a request is for from the request itself, for the URLs
+// shared by several tenants.
+type Resolver interface {
+	Resolve(r *http.Request) (tenantID string, found bool)
+}
+
+// ResolverFunc a function that is a Resolver.
+type ResolverFunc func(r *http.Request) (tenantID string, found bool)
+
+func (f ResolverFunc) Resolve(r *http.Request) (string, bool) {
+	return f(r)
+}
+
+// Factory builds a resolver from its JSON params, reading the keys it needs with
+// keys. Invalid params are reported as validation.Errors, with pointers relative to
+// the params.
+type Factory func(params json.RawMessage, keys Keys) (Resolver, error)
+
+// Keys reads a key a resolver verifies requests with, from either a file or an
+// environment variable. It fails for the ones the resolver is not allowed to read.
+type Keys func(file, env *string) ([]byte, error)
+
+// validationKeys the Keys of a resolver that is only validated: it reads nothing, so
+// that validating a config does not tell what the server can read.
+func validationKeys(file, env *string) ([]byte, error) {
+	return []byte("validation"), nil
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Factory{
+		"header":            newHeader,
+		"jwtClaim":          newJwtClaim,
+		"clientCertificate": newClientCertificate,
+		"pathPrefix":        newPathPrefix,
+	}
+)
+
+// Register adds a resolver kind that configs can reference.
+func Register(kind string, factory Factory) error {
+	if kind == "" {
+		return fmt.Errorf(TRACE + " Register kind: mustNotBeEmpty")
+	}
+	if factory == nil {
+		return fmt.Errorf(TRACE + " Register factory: mustNotBeEmpty")
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, found := registry[kind]; found {
+		return fmt.Errorf(TRACE+" Register kind: alreadyExists \"%s\"", kind)
+	}
+	registry[kind] = factory
+	return nil
+}
+
+// Kinds the registered resolver kinds.
+func Kinds() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	kinds := make([]string, 0, len(registry))
+	for kind := range registry {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func factoryOf(kind string) (Factory, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	factory, found := registry[kind]
+	return factory, found
+}
+
+// Config a resolver, referenced by kind, and its params.
+type Config struct {
+	Kind   *string         `json:"kind"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+func (c Config) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c Config) RefineJSONSchema(s *schema.Schema) {
+	kinds := Kinds()
+	s.Property("kind").Enum = make([]interface{}, len(kinds))
+	for i, kind := range kinds {
+		s.Property("kind").Enum[i] = kind
+	}
+	s.Properties["params"] = &schema.Schema{Type: []string{"object", "null"}}
+}
+
+func (c Config) ValidateWith(v *validation.Validator) {
+	if !v.Required("kind", c.Kind != nil) {
+		return
+	}
+	factory, found := factoryOf(*c.Kind)
+	if !found {
+		v.Field("kind").Failf("mustBeRegisteredResolver", "%q", *c.Kind)
+		return
+	}
+	if _, err := factory(params(c.Params), validationKeys); err != nil {
+		v.Field("params").Add(err)
+	}
+}
+
+// Build the resolver of c, whose keys are read with keys.
+func (c Config) Build(keys Keys) (Resolver, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	factory, _ := factoryOf(*c.Kind)
+	return factory(params(c.Params), keys)
+}
+
+func params(p json.RawMessage) json.RawMessage {
+	if len(p) == 0 || bytes.Equal(p, []byte("null")) {
+		return json.RawMessage("{}")
+	}
+	return p
+}
+
+// decodeParams decodes params strictly, so that a misspelled param is reported.
+func decodeParams(params json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return validation.Invalidf("mustBeValidParams", "%s", err)
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package util
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 
 	"github.com/gorilla/mux"
+	"github.com/riotemergence/godynamicweb/format"
+	"github.com/riotemergence/godynamicweb/validation"
 )
 
 func ToJson(i interface{}) string {
@@ -25,11 +29,88 @@ func KeySet(m map[string]interface{}) []string {
 	return keys
 }
 
+// JsonError an error that is rendered to clients as a JSON document.
+type JsonError interface {
+	error
+	ToJson() string
+}
+
+// Problem an RFC 9457 problem details document.
+type Problem struct {
+	Type   string            `json:"type"`
+	Title  string            `json:"title"`
+	Status int               `json:"status"`
+	Detail string            `json:"detail,omitempty"`
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+func (p Problem) String() string {
+	return ToJson(p)
+}
+
+// WriteProblem replies with p as application/problem+json.
+func WriteProblem(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	fmt.Fprint(w, p.String())
+}
+
+// Error replies with err as plain text, or as JSON if it is a JsonError. Validation
+// errors are replied as a problem document listing every invalid value, with the
+// status 422 Unprocessable Entity whatever code is.
+func Error(w http.ResponseWriter, err error, code int) {
+	var validationErrors validation.Errors
+	if errors.As(err, &validationErrors) {
+		WriteProblem(w, Problem{
+			Type:   "about:blank",
+			Title:  http.StatusText(http.StatusUnprocessableEntity),
+			Status: http.StatusUnprocessableEntity,
+			Errors: validationErrors,
+		})
+		return
+	}
+	var jsonError JsonError
+	if errors.As(err, &jsonError) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		fmt.Fprint(w, jsonError.ToJson())
+		return
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// DecodeBody decodes the request body into v in the format of its Content-Type.
+func DecodeBody(r *http.Request, v interface{}) error {
+	f, err := format.FromContentType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return format.Decode(f, body, v)
+}
+
+// Write writes the JSON of s, as YAML if the Accept header of the request prefers it.
+// What is not JSON is written as it is.
+func Write(w http.ResponseWriter, r *http.Request, s fmt.Stringer) {
+	body := []byte(s.String())
+	if f := format.FromAccept(r.Header.Get("Accept")); f != format.JSON && json.Valid(body) {
+		if converted, err := format.FromJSON(f, body); err == nil {
+			w.Header().Set("Content-Type", f.ContentType())
+			w.Write(converted)
+			return
+		}
+	}
+	w.Write(body)
+}
+
 func Get(w http.ResponseWriter, r *http.Request, pathParameterName string, extractParameterFn func(string) (fmt.Stringer, bool)) {
 	pathParameters := mux.Vars(r)
 	pathParameterValue := pathParameters[pathParameterName]
 	if s, ok := extractParameterFn(pathParameterValue); ok {
-		fmt.Fprint(w, s.String())
+		Write(w, r, s)
 		return
 	}
 	http.NotFound(w, r)
@@ -45,17 +126,20 @@ func Delete(w http.ResponseWriter, r *http.Request, pathParameterName string, ex
 	return execFn(pathParameterValue)
 }
 
-func Put(w http.ResponseWriter, r *http.Request, pathParameterName string, existsParameterFn func(string) bool, createFn func(string) error, updateFn func(string) error, bodyParamPtr interface{}) error {
-	dec := json.NewDecoder(r.Body)
-	if err := dec.Decode(bodyParamPtr); err != nil {
-		http.Error(w, "Invalid JSON Body", http.StatusConflict)
+func Put(w http.ResponseWriter, r *http.Request, pathParameterName string, existsParameterFn func(string) bool, createFn func(string) error, updateFn func(string) (fmt.Stringer, error), bodyParamPtr interface{}) error {
+	if _, err := format.FromContentType(r.Header.Get("Content-Type")); err != nil {
+		http.Error(w, "Unsupported Content-Type", http.StatusUnsupportedMediaType)
+		return err
+	}
+	if err := DecodeBody(r, bodyParamPtr); err != nil {
+		http.Error(w, "Invalid Body", http.StatusConflict)
 		return err
 	}
 	pathParameters := mux.Vars(r)
 	pathParameterValue := pathParameters[pathParameterName]
 	if ok := existsParameterFn(pathParameterValue); !ok {
 		if err := createFn(pathParameterValue); err != nil {
-			http.Error(w, err.Error(), http.StatusConflict)
+			Error(w, err, http.StatusConflict)
 			return err
 		}
 		w.WriteHeader(http.StatusCreated)
@@ -63,10 +147,15 @@ func Put(w http.ResponseWriter, r *http.Request, pathParameterName string, exist
 
 	}
 
-	if err := updateFn(pathParameterValue); err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+	result, err := updateFn(pathParameterValue)
+	if err != nil {
+		Error(w, err, http.StatusConflict)
 		return err
 	}
+	if result != nil {
+		Write(w, r, result)
+		return nil
+	}
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
@@ -0,0 +1,164 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const TRACE = "github.com/riotemergence/godynamicweb/validation"
+
+// Error one invalid value of a config document.
+type Error struct {
+	// Pointer the RFC 6901 JSON pointer of the value, "" for the whole document.
+	Pointer string `json:"pointer"`
+	// Code what is wrong, in camelCase, such as "required" or "mustBePositive".
+	Code string `json:"code"`
+	// Detail the offending value, or why it is invalid, when Code alone does not tell.
+	Detail string `json:"detail,omitempty"`
+}
+
+func (e Error) String() string {
+	s := e.Code
+	if e.Pointer != "" {
+		s = e.Pointer + ": " + s
+	}
+	if e.Detail != "" {
+		s += " " + e.Detail
+	}
+	return s
+}
+
+// Errors every invalid value of a config document, in the order they were found.
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.String()
+	}
+	return TRACE + " invalid: " + strings.Join(messages, "; ")
+}
+
+// Invalid the error of a whole value, such as a string that is not an URL.
+func Invalid(code string) Errors {
+	return Errors{{Code: code}}
+}
+
+// Invalidf is Invalid with a detail.
+func Invalidf(code string, format string, a ...interface{}) Errors {
+	return Errors{{Code: code, Detail: fmt.Sprintf(format, a...)}}
+}
+
+// Validatable a config type that reports all its invalid values to a Validator.
+type Validatable interface {
+	ValidateWith(v *Validator)
+}
+
+// Validate the errors of value, or nil when it is valid.
+func Validate(value Validatable) error {
+	v := NewValidator()
+	value.ValidateWith(v)
+	return v.Err()
+}
+
+// Validator collects the errors of a config document. The Validator of a field or an
+// item, returned by Field and Index, adds its errors to the same list under the
+// pointer of that field or item.
+type Validator struct {
+	pointer string
+	errors  *Errors
+}
+
+func NewValidator() *Validator {
+	return &Validator{errors: &Errors{}}
+}
+
+// Field the Validator of a member of an object, named after its JSON tag or its key.
+func (v *Validator) Field(name string) *Validator {
+	return &Validator{
+		pointer: v.pointer + "/" + escape(name),
+		errors:  v.errors,
+	}
+}
+
+// Index the Validator of an item of an array.
+func (v *Validator) Index(index int) *Validator {
+	return v.Field(strconv.Itoa(index))
+}
+
+// Pointer the JSON pointer of the value being validated.
+func (v *Validator) Pointer() string {
+	return v.pointer
+}
+
+// Fail adds an error at the pointer of v.
+func (v *Validator) Fail(code string) {
+	*v.errors = append(*v.errors, Error{Pointer: v.pointer, Code: code})
+}
+
+// Failf is Fail with a detail.
+func (v *Validator) Failf(code string, format string, a ...interface{}) {
+	*v.errors = append(*v.errors, Error{Pointer: v.pointer, Code: code, Detail: fmt.Sprintf(format, a...)})
+}
+
+// Required adds a "required" error to the field name when it is not present, and
+// tells whether it is.
+func (v *Validator) Required(name string, present bool) bool {
+	if !present {
+		v.Field(name).Fail("required")
+	}
+	return present
+}
+
+// Check validates value at the pointer of v.
+func (v *Validator) Check(value Validatable) {
+	value.ValidateWith(v)
+}
+
+// Add adds the errors of an error returned by a Validate function, under the
+// pointer of v. Any other error is added with the code "invalid".
+func (v *Validator) Add(err error) {
+	if err == nil {
+		return
+	}
+	var errs Errors
+	if !errors.As(err, &errs) {
+		v.Failf("invalid", "%s", err)
+		return
+	}
+	for _, e := range errs {
+		e.Pointer = v.pointer + e.Pointer
+		*v.errors = append(*v.errors, e)
+	}
+}
+
+// Errors the errors found so far, including the ones of other pointers.
+func (v *Validator) Errors() Errors {
+	return *v.errors
+}
+
+// Err the errors found, or nil when there is none.
+func (v *Validator) Err() error {
+	if len(*v.errors) == 0 {
+		return nil
+	}
+	return *v.errors
+}
+
+// SortedKeys the keys of a map, so that its errors are always reported in the same
+// order.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
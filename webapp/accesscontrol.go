@@ -0,0 +1,119 @@
+package webapp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/rbac"
+)
+
+type principalContextKey struct{}
+
+// permission whether a principal may call a management route, given its path
+// parameters.
+type permission func(principal rbac.Principal, pathParameters map[string]string) bool
+
+func adminPermission(principal rbac.Principal, pathParameters map[string]string) bool {
+	return principal.IsAdmin()
+}
+
+func operatePermission(principal rbac.Principal, pathParameters map[string]string) bool {
+	return principal.CanOperate()
+}
+
+func readPermission(principal rbac.Principal, pathParameters map[string]string) bool {
+	return principal.CanRead()
+}
+
+func readTenantPermission(principal rbac.Principal, pathParameters map[string]string) bool {
+	return principal.CanReadTenant(pathParameters["tenantId"])
+}
+
+func changeTenantPermission(principal rbac.Principal, pathParameters map[string]string) bool {
+	return principal.CanChangeTenant(pathParameters["tenantId"])
+}
+
+// anyPrincipalPermission lets every authenticated principal in; the handler narrows
+// what a tenant admin sees or changes.
+func anyPrincipalPermission(principal rbac.Principal, pathParameters map[string]string) bool {
+	return true
+}
+
+// SetManagementAccessControl requires the callers of the management connectors to
+// authenticate as one of the principals of config, and checks their roles on every
+// route. Until it is called, or the settings have an access control, every caller is
+// rejected, unless the settings allow anonymous management.
+func (webApp *WebApp) SetManagementAccessControl(config rbac.Config) error {
+	policy, err := config.Build()
+	if err != nil {
+		return fmt.Errorf(TRACE+" WebApp SetManagementAccessControl config: %w", err)
+	}
+	webApp.accessPolicy.Store(policy)
+	return nil
+}
+
+// authenticationMiddleware rejects the callers that are not principals of the access
+// policy, recording them in the audit log, and records the principal of the others in
+// the request context. Without an access policy, every caller is rejected unless the
+// settings allow anonymous management.
+func (webApp *WebApp) authenticationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := webApp.accessPolicy.Load().(*rbac.Policy)
+		principal := rbac.Principal{Name: callerIdentity(r), Roles: []string{rbac.Admin}}
+		authenticated := webApp.currentSettings().anonymousManagement
+		if policy != nil {
+			principal, authenticated = policy.Authenticate(r)
+		}
+		if !authenticated {
+			webApp.auditAuthenticationFailure(r)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="management"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+	})
+}
+
+// callerIdentity the common name of the verified client certificate of the caller,
+// "anonymous" when there is none. It names the anonymous callers, and the callers
+// that failed to authenticate.
+func callerIdentity(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		if commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName; commonName != "" {
+			return commonName
+		}
+	}
+	return "anonymous"
+}
+
+// authorize serves the route with handler when the principal of the request has the
+// permission, or answers 403 Forbidden.
+func authorize(p permission, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p(requestPrincipal(r), mux.Vars(r)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// checkOperatorFields rejects a config of tenantID setting fields only an operator
+// may set, see multitenancy.TenantConfig.ValidateOperatorFields, unless the principal
+// of the request is one.
+func (webApp *WebApp) checkOperatorFields(r *http.Request, tenantID string, config multitenancy.TenantConfig) error {
+	if requestPrincipal(r).CanOperate() {
+		return nil
+	}
+	return config.ValidateOperatorFields(tenantID, webApp.multiTenancySupport.Tenants())
+}
+
+// requestPrincipal the principal authenticationMiddleware authenticated, a principal
+// without roles for a request that did not go through it.
+func requestPrincipal(r *http.Request) rbac.Principal {
+	principal, _ := r.Context().Value(principalContextKey{}).(rbac.Principal)
+	return principal
+}
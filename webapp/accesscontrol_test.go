@@ -0,0 +1,101 @@
+package webapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/audit"
+	"github.com/riotemergence/godynamicweb/server"
+)
+
+func TestManagementAccessControl(t *testing.T) {
+	t.Setenv("MANAGEMENT_ROOT", "root-token")
+	t.Setenv("MANAGEMENT_A", "a-token")
+	w := NewWebApp()
+	w.AddTenantServerEndpointSlot("api", "GET", func(webApp *WebApp, tenantID string, rw http.ResponseWriter, r *http.Request) {})
+	if err := w.CreateServerConnector("http", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.DeleteServerConnector("http")
+	if err := w.CreateServerManagementConnector("management", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.server.RemoveConnector("management")
+	call := func(token, method, path, body string) (int, string) {
+		t.Helper()
+		request, _ := http.NewRequest(method, "http://"+connectorAddress(w, "management")+path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		read, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(read)
+	}
+
+	// Without an access control, every caller is rejected, and recorded.
+	if code, _ := call("", "GET", "/tenants", ""); code != http.StatusUnauthorized {
+		t.Fatal(code)
+	}
+	entries, _, err := w.AuditLog().Query(audit.Filter{Action: audit.Authenticate})
+	if err != nil || len(entries) != 1 || entries[0].Resource != "tenants" || entries[0].Caller != "anonymous" || entries[0].Status != http.StatusUnauthorized {
+		t.Fatal(entries, err)
+	}
+
+	var settings SettingsConfig
+	if err := json.Unmarshal([]byte(`{"accessControl":{"principals":{
+		"root":{"roles":["admin"],"token":{"env":"MANAGEMENT_ROOT"}},
+		"a":{"roles":["tenant-admin:a"],"token":{"env":"MANAGEMENT_A"}}}}}`), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ApplySettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := call("bad", "PUT", "/tenants/a", `{}`); code != http.StatusUnauthorized {
+		t.Fatal(code)
+	}
+
+	if code, body := call("root-token", "PUT", "/tenants/b", `{"name":"b","serverEndpoints":{"api":{"url":"http://b.test/api","connector":"http"}}}`); code != http.StatusCreated {
+		t.Fatal(code, body)
+	}
+
+	servedDir, otherDir := t.TempDir(), t.TempDir()
+	tenant := func(rootFs string, extra string) string {
+		return fmt.Sprintf(`{"name":"a","serverEndpoints":{"api":{"url":"http://a.test/api","connector":"http"%s}},
+			"fileServerEndpoints":[{"url":"http://a.test/files/","connector":"http","rootFs":%q}]}`, extra, rootFs)
+	}
+	for _, test := range []struct {
+		token   string
+		body    string
+		code    int
+		pointer string
+	}{
+		{"a-token", tenant(servedDir, ""), http.StatusUnprocessableEntity, "/fileServerEndpoints/0/rootFs"},
+		{"root-token", tenant(servedDir, ""), http.StatusCreated, ""},
+		// What an operator set stays, the tenant admin changes the rest.
+		{"a-token", tenant(servedDir, `,"middleware":[{"name":"headers","params":{"set":{"X-A":"a"}}}]`), http.StatusOK, ""},
+		{"a-token", tenant(otherDir, ""), http.StatusUnprocessableEntity, "/fileServerEndpoints/0/rootFs"},
+		{"a-token", tenant(servedDir, `,"tenantResolver":{"kind":"header"}`), http.StatusUnprocessableEntity, "/serverEndpoints/api/tenantResolver"},
+		{"a-token", strings.Replace(tenant(servedDir, ""), `"name":"a",`, `"name":"a","secrets":{"key":{"env":"MANAGEMENT_ROOT"}},`, 1), http.StatusUnprocessableEntity, "/secrets/key"},
+		{"root-token", tenant(otherDir, ""), http.StatusOK, ""},
+		// Nor does the tenant admin take the hosts of other tenants, or any host.
+		{"a-token", strings.Replace(tenant(otherDir, ""), "http://a.test/api", "http://b.test/a/api", 1), http.StatusUnprocessableEntity, "/serverEndpoints/api/url"},
+		{"a-token", strings.Replace(tenant(otherDir, ""), "http://a.test/api", "//*/api", 1), http.StatusUnprocessableEntity, "/serverEndpoints/api/url"},
+	} {
+		code, body := call(test.token, "PUT", "/tenants/a", test.body)
+		if code != test.code || !strings.Contains(body, test.pointer) {
+			t.Fatal(test.token, test.body, code, body)
+		}
+	}
+	if tenant := w.multiTenancySupport.Tenants()["a"]; *(*tenant.ServerEndpoints)["api"].Url != "http://a.test/api" {
+		t.Fatal(tenant)
+	}
+}
@@ -0,0 +1,103 @@
+package webapp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/riotemergence/godynamicweb/acme"
+	"github.com/riotemergence/godynamicweb/mux"
+)
+
+// EnableAcme issues and renews certificates for the hostnames of the tenant endpoint
+// urls that no tenant or global certificate covers. Hostnames served by a plaintext
+// connector are validated with HTTP-01, the others with TLS-ALPN-01.
+func (webApp *WebApp) EnableAcme(config acme.AcmeConfig) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp EnableAcme: statusMustBeStatusSlotReservationOrStatusRunning")
+	}
+	webApp.acmeMutex.Lock()
+	defer webApp.acmeMutex.Unlock()
+	if webApp.acmeManager() != nil {
+		return fmt.Errorf(TRACE + " WebApp EnableAcme: alreadyEnabled")
+	}
+
+	manager, err := acme.NewManager(config, webApp.acmeHostnames)
+	if err != nil {
+		return fmt.Errorf(TRACE+" WebApp EnableAcme config: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	webApp.acme.Store(manager)
+	webApp.acmeCancel = cancel
+	go manager.Run(ctx)
+	return nil
+}
+
+// stopAcme stops the renewals of the ACME manager. The certificates it issued keep
+// being served.
+func (webApp *WebApp) stopAcme() {
+	webApp.acmeMutex.Lock()
+	defer webApp.acmeMutex.Unlock()
+	if webApp.acmeCancel != nil {
+		webApp.acmeCancel()
+		webApp.acmeCancel = nil
+	}
+}
+
+// tlsAlpn01Certificate the challenge certificate of a TLS-ALPN-01 validation in
+// progress, none unless ACME is enabled.
+func (webApp *WebApp) tlsAlpn01Certificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+	manager := webApp.acmeManager()
+	if manager == nil {
+		return nil, false
+	}
+	return manager.GetTLSALPN01Certificate(clientHello)
+}
+
+// acmeManager the ACME manager, nil unless EnableAcme was called.
+func (webApp *WebApp) acmeManager() *acme.Manager {
+	manager, _ := webApp.acme.Load().(*acme.Manager)
+	return manager
+}
+
+// refreshAcme makes the ACME manager pick up the hostnames of changed tenants.
+func (webApp *WebApp) refreshAcme() {
+	if manager := webApp.acmeManager(); manager != nil {
+		manager.Refresh()
+	}
+}
+
+func (webApp *WebApp) acmeHostnames() map[string]acme.ChallengeType {
+	tenantCertificates := webApp.multiTenancySupport.CertificateIndex()
+	globalCertificates := webApp.X509Certificates()
+
+	hostnames := make(map[string]acme.ChallengeType)
+	for _, entry := range webApp.multiTenancySupport.MuxCatalog.Entries() {
+		hostname := strings.ToLower(entry.Key.Host)
+		if host, _, err := net.SplitHostPort(hostname); err == nil {
+			hostname = host
+		}
+		if hostname == mux.AnyHost || hostname == "" || strings.Contains(hostname, "*") || net.ParseIP(hostname) != nil {
+			continue
+		}
+		if _, found := tenantCertificates.GetExact(hostname); found {
+			continue
+		}
+		if _, found := globalCertificates.GetExact(hostname); found {
+			continue
+		}
+
+		connectorConfig, found := (*webApp.server.Config.Connectors)[entry.Key.Connector]
+		if !found {
+			continue
+		}
+		if connectorConfig.TLS == nil || !*connectorConfig.TLS {
+			hostnames[hostname] = acme.HTTP01
+		} else if _, found := hostnames[hostname]; !found {
+			hostnames[hostname] = acme.TLSALPN01
+		}
+	}
+	return hostnames
+}
@@ -0,0 +1,290 @@
+package webapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/riotemergence/godynamicweb/audit"
+)
+
+// DefaultAuditMemoryEntries how many entries the audit log keeps in memory until
+// OpenAuditLog is called.
+const DefaultAuditMemoryEntries = 1000
+
+// maxAuditErrorLen how much of the response of a failed operation is recorded.
+const maxAuditErrorLen = 1024
+
+// AuditFailedHeader is set on the response of an operation that could not be recorded
+// in the audit log. The operation was performed all the same.
+const AuditFailedHeader = "Audit-Failed"
+
+// auditRedactedMembers the members of the audited documents holding secrets: the
+// private keys of the certificates, and the secrets of the tenants.
+var auditRedactedMembers = []string{"pkey", "secrets"}
+
+// auditSnapshots the state of the resource of a management route, by path template,
+// recorded before and after the operation.
+var auditSnapshots = map[string]func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool){
+	"/connectors/{connectorName}": func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool) {
+		connector, found := (*webApp.server.Config.Connectors)[pathParameters["connectorName"]]
+		return connector, found
+	},
+	"/tenants/{tenantId}": func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool) {
+		tenant, found := webApp.multiTenancySupport.Tenants()[pathParameters["tenantId"]]
+		return tenant, found
+	},
+	"/tenants/{tenantId}/state": func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool) {
+		return webApp.multiTenancySupport.GetTenantState(pathParameters["tenantId"])
+	},
+	"/templates/{templateName}": func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool) {
+		return webApp.GetTemplate(pathParameters["templateName"])
+	},
+	"/x509/{x509Cn}": func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool) {
+		return webApp.X509Certificates().GetExact(pathParameters["x509Cn"])
+	},
+	"/generations/{generation}/rollback": func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool) {
+		return webApp.multiTenancySupport.Tenants(), true
+	},
+}
+
+// OpenAuditLog records the management operations in sinks from now on, instead of in
+// memory only. The audit log is queried through the first sink that is an
+// audit.Querier.
+func (webApp *WebApp) OpenAuditLog(sinks ...audit.Sink) error {
+	previous := webApp.auditLog.Swap(audit.NewLog(sinks...))
+	if err := previous.(*audit.Log).Close(); err != nil {
+		return fmt.Errorf(TRACE+" WebApp OpenAuditLog: %s", err)
+	}
+	return nil
+}
+
+func (webApp *WebApp) AuditLog() *audit.Log {
+	return webApp.auditLog.Load().(*audit.Log)
+}
+
+// auditMiddleware records every management request that is not a read in the audit
+// log, whatever its outcome. The response is held until the entry is appended, so that
+// a failed append is flagged with AuditFailedHeader.
+func (webApp *WebApp) auditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pathTemplate, _ := mux.CurrentRoute(r).GetPathTemplate()
+		record := &auditRecord{
+			entry: audit.Entry{
+				Time:     time.Now(),
+				Caller:   requestPrincipal(r).Name,
+				SourceIp: remoteIp(r),
+			},
+			snapshot:       auditSnapshots[pathTemplate],
+			pathParameters: mux.Vars(r),
+		}
+		record.entry.Resource, record.entry.Action = auditOperation(r.Method, pathTemplate, r.URL.Path)
+		existed := record.snapshotBefore(webApp)
+		if record.entry.Action == "" {
+			record.entry.Action = audit.Create
+			if existed {
+				record.entry.Action = audit.Replace
+			}
+		}
+
+		auditWriter := &auditResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(auditWriter, r.WithContext(context.WithValue(r.Context(), auditRecordContextKey{}, record)))
+
+		entry := record.entry
+		entry.Status = auditWriter.status()
+		entry.Outcome = audit.Success
+		if entry.Status >= http.StatusBadRequest {
+			entry.Outcome = audit.Failure
+			entry.Error = strings.TrimSpace(auditWriter.errorBody())
+		}
+		if record.snapshot != nil {
+			if after, found := record.snapshot(webApp, record.pathParameters); found {
+				entry.After = webApp.redactAudited(after)
+			}
+		}
+		if err := webApp.AuditLog().Append(entry); err != nil {
+			fmt.Println(TRACE+" WebApp auditMiddleware:", err)
+			w.Header().Set(AuditFailedHeader, "true")
+		}
+		auditWriter.flush()
+	})
+}
+
+type auditRecordContextKey struct{}
+
+// auditRecord the entry auditMiddleware records for a request, and the snapshot of
+// its resource.
+type auditRecord struct {
+	entry          audit.Entry
+	snapshot       func(webApp *WebApp, pathParameters map[string]string) (interface{}, bool)
+	pathParameters map[string]string
+}
+
+// snapshotBefore records the resource before the operation, and whether it existed.
+func (record *auditRecord) snapshotBefore(webApp *WebApp) bool {
+	record.entry.Before = nil
+	if record.snapshot == nil {
+		return false
+	}
+	before, existed := record.snapshot(webApp, record.pathParameters)
+	if existed {
+		record.entry.Before = webApp.redactAudited(before)
+	}
+	return existed
+}
+
+// auditResource names the resource of a request whose path does not, such as the
+// tenant of an import, to be recorded as by the route of pathTemplate, with
+// pathParameters. It must be called before the operation.
+func (webApp *WebApp) auditResource(r *http.Request, resource string, pathTemplate string, pathParameters map[string]string) {
+	record, found := r.Context().Value(auditRecordContextKey{}).(*auditRecord)
+	if !found {
+		return
+	}
+	record.entry.Resource = resource
+	record.snapshot, record.pathParameters = auditSnapshots[pathTemplate], pathParameters
+	record.snapshotBefore(webApp)
+}
+
+// auditAuthenticationFailure records a management request rejected by
+// authenticationMiddleware, whatever its method.
+func (webApp *WebApp) auditAuthenticationFailure(r *http.Request) {
+	entry := audit.Entry{
+		Time:     time.Now(),
+		Caller:   callerIdentity(r),
+		SourceIp: remoteIp(r),
+		Action:   audit.Authenticate,
+		Outcome:  audit.Failure,
+		Status:   http.StatusUnauthorized,
+		Error:    http.StatusText(http.StatusUnauthorized),
+	}
+	entry.Resource, _ = auditOperation(r.Method, "", r.URL.Path)
+	if err := webApp.AuditLog().Append(entry); err != nil {
+		fmt.Println(TRACE+" WebApp auditAuthenticationFailure:", err)
+	}
+}
+
+// auditOperation the resource of a request and its action. A POST to a path ending
+// with a verb, such as /generations/3/rollback, performs that action on the resource
+// before it; the action of any other PUT or POST is left to be told by whether the
+// resource existed.
+func auditOperation(method string, pathTemplate string, path string) (resource string, action string) {
+	resource = strings.Trim(path, "/")
+	if resource == "" {
+		resource = "server"
+	}
+	switch method {
+	case http.MethodDelete:
+		return resource, audit.Delete
+	case http.MethodPost:
+		segments := strings.Split(strings.Trim(pathTemplate, "/"), "/")
+		if last := segments[len(segments)-1]; len(segments) > 1 && !strings.HasPrefix(last, "{") {
+			return resource[:strings.LastIndex(resource, "/")], last
+		}
+	}
+	return resource, ""
+}
+
+func (webApp *WebApp) redactAudited(document interface{}) []byte {
+	redacted, err := audit.Redact(document, auditRedactedMembers...)
+	if err != nil {
+		fmt.Println(TRACE+" WebApp redactAudited:", err)
+		return nil
+	}
+	return redacted
+}
+
+// remoteIp the address the request came from, whatever X-Forwarded-For says: the
+// management connector is not meant to be proxied.
+func remoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditResponseWriter holds the response of a management operation until flush, the
+// headers aside, which are set on the ResponseWriter right away.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *auditResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// errorBody the start of the response, to be recorded as the error of a failed
+// operation.
+func (w *auditResponseWriter) errorBody() string {
+	if w.body.Len() > maxAuditErrorLen {
+		return string(w.body.Bytes()[:maxAuditErrorLen])
+	}
+	return w.body.String()
+}
+
+// flush sends the response held.
+func (w *auditResponseWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status())
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		fmt.Println(TRACE+" WebApp auditMiddleware:", err)
+	}
+}
+
+func (w *auditResponseWriter) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
+
+// auditFilter the filter of the query parameters since and until, as RFC 3339
+// timestamps, resource, action, caller and limit.
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Resource: query.Get("resource"),
+		Action:   query.Get("action"),
+		Caller:   query.Get("caller"),
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return audit.Filter{}, fmt.Errorf("Invalid %s", name)
+			}
+			*t = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return audit.Filter{}, fmt.Errorf("Invalid limit")
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
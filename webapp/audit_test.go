@@ -0,0 +1,67 @@
+package webapp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/audit"
+	"github.com/riotemergence/godynamicweb/server"
+)
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Append(entry audit.Entry) error {
+	return errors.New("diskFull")
+}
+
+func (failingAuditSink) Close() error {
+	return nil
+}
+
+// allowAnonymousManagement lets the test call the management connectors without
+// authenticating.
+func allowAnonymousManagement(t *testing.T, w *WebApp) {
+	t.Helper()
+	anonymousManagement := true
+	if err := w.ApplySettings(SettingsConfig{AnonymousManagement: &anonymousManagement}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditFailureIsFlagged(t *testing.T) {
+	w := NewWebApp()
+	if err := w.SetServerConfigurationSlot(""); err != nil {
+		t.Fatal(err)
+	}
+	allowAnonymousManagement(t, w)
+	if err := w.CreateServerManagementConnector("management", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.server.RemoveConnector("management")
+	call := func(path string, body string) *http.Response {
+		t.Helper()
+		request, _ := http.NewRequest("PUT", "http://"+connectorAddress(w, "management")+path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		io.ReadAll(response.Body)
+		return response
+	}
+
+	tenant := `{"name":"acme","serverEndpoints":{}}`
+	if response := call("/tenants/acme", tenant); response.StatusCode != http.StatusCreated || response.Header.Get(AuditFailedHeader) != "" {
+		t.Fatal(response.StatusCode, response.Header)
+	}
+	if err := w.OpenAuditLog(failingAuditSink{}); err != nil {
+		t.Fatal(err)
+	}
+	if response := call("/tenants/acme", tenant); response.StatusCode != http.StatusOK || response.Header.Get(AuditFailedHeader) != "true" {
+		t.Fatal(response.StatusCode, response.Header)
+	}
+}
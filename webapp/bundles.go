@@ -0,0 +1,154 @@
+package webapp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/riotemergence/godynamicweb/bundle"
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/validation"
+)
+
+// SetImportFilesDir the directory the file-server content of imported bundles is
+// written to, one directory per import. Until it is set, bundles with file servers can
+// not be imported.
+func (webApp *WebApp) SetImportFilesDir(dir string) error {
+	if err := multitenancy.ExistingDir(dir).Validate(); err != nil {
+		return fmt.Errorf(TRACE+" WebApp SetImportFilesDir dir: %w", err)
+	}
+	webApp.importFilesDir = dir
+	return nil
+}
+
+// ExportTenant the bundle of a tenant, see bundle.Export.
+func (webApp *WebApp) ExportTenant(tenantID string, passphrase string, withFiles bool) (bundle.Bundle, error) {
+	config, found := webApp.multiTenancySupport.Tenants()[tenantID]
+	if !found {
+		return bundle.Bundle{}, fmt.Errorf(TRACE+" WebApp ExportTenant tenantID: mustExist \"%s\"", tenantID)
+	}
+	return bundle.Export(tenantID, config, passphrase, withFiles)
+}
+
+// TenantExistsError a bundle that can not be imported because its tenant already
+// exists, and the import does not replace it.
+type TenantExistsError struct {
+	TenantID string
+}
+
+func (e *TenantExistsError) Error() string {
+	return fmt.Sprintf(TRACE+" WebApp ImportTenant tenant \"%s\": mustNotExist", e.TenantID)
+}
+
+// ImportTenant creates the tenant of a bundle, or replaces it when request allows it,
+// after remapping its hostnames and connectors and checking its routes against the
+// live ones. It returns the tenant and whether it was created; the error is a
+// *TenantExistsError or a *multitenancy.TenantConflictsError when the tenant is in
+// the way of another.
+func (webApp *WebApp) ImportTenant(request bundle.ImportRequest) (string, bool, error) {
+	return webApp.importTenant(request, nil)
+}
+
+// importTenant imports the bundle of request as ImportTenant does, after checking the
+// tenant with check, when not nil.
+func (webApp *WebApp) importTenant(request bundle.ImportRequest, check func(tenantID string, config multitenancy.TenantConfig) error) (string, bool, error) {
+	if err := request.Validate(); err != nil {
+		return "", false, fmt.Errorf(TRACE+" WebApp ImportTenant request: %w", err)
+	}
+	tenantID := request.ImportedTenantID()
+	config, err := request.Bundle.Open(request.Passphrase)
+	if err != nil {
+		return tenantID, false, fmt.Errorf(TRACE+" WebApp ImportTenant bundle: %w", err)
+	}
+	if err := request.Bundle.Remap(&config, request.Hostnames, request.Connectors); err != nil {
+		return tenantID, false, fmt.Errorf(TRACE+" WebApp ImportTenant request: %w", err)
+	}
+	if err := webApp.dropBundleRootFs(request.Bundle, &config); err != nil {
+		return tenantID, false, fmt.Errorf(TRACE+" WebApp ImportTenant request: %w", err)
+	}
+	_, exists := webApp.multiTenancySupport.Tenants()[tenantID]
+	if exists && !request.Replace {
+		return tenantID, false, &TenantExistsError{TenantID: tenantID}
+	}
+	if check != nil {
+		if err := check(tenantID, config); err != nil {
+			return tenantID, false, fmt.Errorf(TRACE+" WebApp ImportTenant bundle tenant: %w", err)
+		}
+	}
+
+	filesDir, err := webApp.writeImportedFiles(tenantID, request.Bundle, &config)
+	if err != nil {
+		return tenantID, false, err
+	}
+	imported := false
+	defer func() {
+		if !imported && filesDir != "" {
+			os.RemoveAll(filesDir)
+		}
+	}()
+
+	conflicts, err := webApp.ValidateTenant(tenantID, config)
+	if err != nil {
+		return tenantID, false, fmt.Errorf(TRACE+" WebApp ImportTenant bundle tenant: %w", err)
+	}
+	if len(conflicts) > 0 {
+		return tenantID, false, &multitenancy.TenantConflictsError{TenantID: tenantID, Conflicts: conflicts}
+	}
+	if exists {
+		_, err = webApp.UpdateTenant(tenantID, config)
+	} else {
+		err = webApp.CreateTenant(tenantID, config)
+	}
+	if err != nil {
+		return tenantID, false, err
+	}
+	imported = true
+	return tenantID, !exists, nil
+}
+
+// dropBundleRootFs clears the rootFs of the file servers of config: the rootFs of a
+// bundle is never trusted, the file servers are only ever pointed at the content the
+// bundle carries, by writeImportedFiles. A file server without content is rejected, as
+// is any content until SetImportFilesDir is called.
+func (webApp *WebApp) dropBundleRootFs(b *bundle.Bundle, config *multitenancy.TenantConfig) error {
+	v := validation.NewValidator()
+	if len(b.Files) > 0 && webApp.importFilesDir == "" {
+		v.Field("bundle").Field("files").Fail("importFilesDirMustBeSet")
+	}
+	if config.FileServerEndpoints != nil {
+		withFiles := make(map[int]bool, len(b.Files))
+		for _, files := range b.Files {
+			withFiles[files.Endpoint] = true
+		}
+		for i := range *config.FileServerEndpoints {
+			(*config.FileServerEndpoints)[i].RootFs = nil
+			if !withFiles[i] {
+				v.Field("bundle").Field("tenant").Field("fileServerEndpoints").Index(i).Field("rootFs").Fail("mustBeImportedWithFiles")
+			}
+		}
+	}
+	return v.Err()
+}
+
+// writeImportedFiles writes the file-server content of the bundle in a new directory
+// of the import files dir, and points the file servers at it. It returns the
+// directory, "" when nothing was written.
+func (webApp *WebApp) writeImportedFiles(tenantID string, b *bundle.Bundle, config *multitenancy.TenantConfig) (string, error) {
+	if len(b.Files) == 0 {
+		return "", nil
+	}
+	filesDir, err := os.MkdirTemp(webApp.importFilesDir, tenantID+"-")
+	if err != nil {
+		return "", fmt.Errorf(TRACE+" WebApp ImportTenant files: %s", err)
+	}
+	for _, files := range b.Files {
+		rootFs := filepath.Join(filesDir, strconv.Itoa(files.Endpoint))
+		if err := files.WriteFiles(rootFs); err != nil {
+			os.RemoveAll(filesDir)
+			return "", fmt.Errorf(TRACE+" WebApp ImportTenant files: %w", err)
+		}
+		(*config.FileServerEndpoints)[files.Endpoint].RootFs = (*multitenancy.ExistingDir)(&rootFs)
+	}
+	return filesDir, nil
+}
@@ -0,0 +1,93 @@
+package webapp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/audit"
+	"github.com/riotemergence/godynamicweb/bundle"
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/server"
+)
+
+func TestImportTenantFiles(t *testing.T) {
+	w := NewWebApp()
+	allowAnonymousManagement(t, w)
+	if err := w.SetServerConfigurationSlot(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreateServerConnector("http", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.DeleteServerConnector("http")
+	if err := w.CreateServerManagementConnector("management", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.server.RemoveConnector("management")
+	importBundle := func(b bundle.Bundle) (int, string) {
+		t.Helper()
+		request, _ := json.Marshal(bundle.ImportRequest{Bundle: &b})
+		response, err := http.Post("http://"+connectorAddress(w, "management")+"/tenants/import", "application/json", strings.NewReader(string(request)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		read, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(read)
+	}
+
+	rootFs := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rootFs, "index.html"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var config multitenancy.TenantConfig
+	if err := json.Unmarshal([]byte(`{"name":"a","serverEndpoints":{},
+		"fileServerEndpoints":[{"url":"http://a.test/files/*","connector":"http","rootFs":"`+rootFs+`"}]}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	withFiles, err := bundle.Export("a", config, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutFiles, err := bundle.Export("a", config, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code, body := importBundle(withFiles); code != http.StatusUnprocessableEntity || !strings.Contains(body, "importFilesDirMustBeSet") {
+		t.Fatal(code, body)
+	}
+	importFilesDir := t.TempDir()
+	if err := w.SetImportFilesDir(importFilesDir); err != nil {
+		t.Fatal(err)
+	}
+	// The rootFs of the bundle is not trusted, even where it exists.
+	if code, body := importBundle(withoutFiles); code != http.StatusUnprocessableEntity || !strings.Contains(body, "/bundle/tenant/fileServerEndpoints/0/rootFs") {
+		t.Fatal(code, body)
+	}
+	if code, body := importBundle(withFiles); code != http.StatusCreated {
+		t.Fatal(code, body)
+	}
+	imported := w.multiTenancySupport.Tenants()["a"]
+	if served := string(*(*imported.FileServerEndpoints)[0].RootFs); !strings.HasPrefix(served, importFilesDir) {
+		t.Fatal(served)
+	}
+
+	entries, _, err := w.AuditLog().Query(audit.Filter{Action: "import"})
+	if err != nil || len(entries) != 3 {
+		t.Fatal(entries, err)
+	}
+	for _, entry := range entries {
+		if entry.Resource != "tenants/a" {
+			t.Fatal(entry)
+		}
+	}
+	if last := entries[len(entries)-1]; last.Outcome != audit.Success || last.Before != nil || !strings.Contains(string(last.After), importFilesDir) {
+		t.Fatal(last)
+	}
+}
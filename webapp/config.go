@@ -1,10 +1,14 @@
 package webapp
 
 import (
-	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
 
 	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/rbac"
 	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/validation"
 	"github.com/riotemergence/godynamicweb/x509"
 )
 
@@ -13,39 +17,93 @@ type WebAppConfig struct {
 	Version    *string                    `json:"version"`
 	Connectors *server.ConnectorsConfig   `json:"connectors"`
 	Tenants    *multitenancy.TenantConfig `json:"tenants"`
-	X509       *x509.X509Config           `json:"tenants"`
+	X509       *x509.X509Config           `json:"x509"`
+	Settings   *SettingsConfig            `json:"settings,omitempty"`
 }
 
 func (c WebAppConfig) Validate() error {
-	if c.Name == nil {
-		return fmt.Errorf(TRACE + " WebAppConfig Name: required")
-	}
+	return validation.Validate(c)
+}
 
-	if c.Version == nil {
-		return fmt.Errorf(TRACE + " WebAppConfig Name: required")
+func (c WebAppConfig) ValidateWith(v *validation.Validator) {
+	v.Required("name", c.Name != nil)
+	v.Required("version", c.Version != nil)
+	if v.Required("connectors", c.Connectors != nil) {
+		v.Field("connectors").Check(c.Connectors)
 	}
-
-	if c.Connectors == nil {
-		return fmt.Errorf(TRACE + " WebAppConfig Connectors: required")
+	if v.Required("tenants", c.Tenants != nil) {
+		v.Field("tenants").Check(c.Tenants)
 	}
-
-	if err := c.Connectors.Validate(); err != nil {
-		return err
+	if c.X509 != nil {
+		v.Field("x509").Check(c.X509)
 	}
-
-	if c.Tenants == nil {
-		return fmt.Errorf(TRACE + " WebAppConfig Tenants: required")
+	if c.Settings != nil {
+		v.Field("settings").Check(c.Settings)
 	}
+}
 
-	if err := c.Tenants.Validate(); err != nil {
-		return err
-	}
+// SettingsConfig the settings of the WebApp itself, rather than of its connectors or
+// tenants, applied with ApplySettings.
+type SettingsConfig struct {
+	// TrustedProxies the addresses, or CIDR ranges, of the proxies whose
+	// X-Forwarded-For header is believed. The client address of any other request
+	// is the one it came from.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+	// SecretDirs the absolute paths of the directories whose files tenants may read
+	// their secrets from.
+	SecretDirs []string `json:"secretDirs,omitempty"`
+	// SecretEnvPrefixes the prefixes of the environment variables tenants may read
+	// their secrets from. With neither, tenants can not have secrets.
+	SecretEnvPrefixes []string `json:"secretEnvPrefixes,omitempty"`
+	// AccessControl the principals allowed to call the management connectors. Without
+	// it, every call is rejected, unless AnonymousManagement.
+	AccessControl *rbac.Config `json:"accessControl,omitempty"`
+	// AnonymousManagement lets every caller of the management connectors in as an
+	// admin, when there is no AccessControl. For development only.
+	AnonymousManagement *bool `json:"anonymousManagement,omitempty"`
+}
 
-	if c.X509 != nil {
-		if err := c.X509.Validate(); err != nil {
-			return err
+func (c SettingsConfig) Validate() error {
+	return validation.Validate(c)
+}
+
+func (c SettingsConfig) ValidateWith(v *validation.Validator) {
+	for i, trustedProxy := range c.TrustedProxies {
+		if _, err := parseIpNet(trustedProxy); err != nil {
+			v.Field("trustedProxies").Index(i).Failf("mustBeIpOrCidr", "%q", trustedProxy)
+		}
+	}
+	for i, secretDir := range c.SecretDirs {
+		if !filepath.IsAbs(secretDir) {
+			v.Field("secretDirs").Index(i).Failf("mustBeAbsolute", "%q", secretDir)
 		}
 	}
+	for i, secretEnvPrefix := range c.SecretEnvPrefixes {
+		if secretEnvPrefix == "" {
+			v.Field("secretEnvPrefixes").Index(i).Fail("mustNotBeEmpty")
+		}
+	}
+	if c.AccessControl != nil {
+		v.Field("accessControl").Check(c.AccessControl)
+		if c.AnonymousManagement != nil && *c.AnonymousManagement {
+			v.Field("anonymousManagement").Fail("mustNotBeSetWithAccessControl")
+		}
+	}
+}
 
-	return nil
+// parseIpNet an address as the range of itself, or a CIDR range.
+func parseIpNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
 }
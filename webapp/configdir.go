@@ -0,0 +1,225 @@
+package webapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/configdir"
+	"github.com/riotemergence/godynamicweb/format"
+	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/util"
+)
+
+// configDirState the connectors and tenants applied from the config directory, the
+// only ones its changes may delete.
+type configDirState struct {
+	mutex   sync.Mutex
+	watcher *configdir.Watcher
+	// cancel stops the watcher.
+	cancel     context.CancelFunc
+	connectors map[string]server.ConnectorConfig
+	tenants    map[string]bool
+	// settings whether the settings were applied from the config directory.
+	settings bool
+	status   ConfigDirStatus
+}
+
+// ConfigDirStatus the outcome of the last change of the config directory.
+type ConfigDirStatus struct {
+	Dir        string                `json:"dir"`
+	AppliedAt  time.Time             `json:"appliedAt"`
+	Connectors []string              `json:"connectors"`
+	Tenants    []string              `json:"tenants"`
+	Errors     []configdir.FileError `json:"errors"`
+}
+
+func (s ConfigDirStatus) String() string {
+	return util.ToJson(s)
+}
+
+// WatchConfigDir applies the connectors and tenants declared in dir, then polls it every
+// interval and applies only what changed. Invalid files, and changes that fail, are
+// reported in the status and skipped; the rest is applied.
+func (webApp *WebApp) WatchConfigDir(dir string, interval time.Duration) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp WatchConfigDir: statusMustBeStatusSlotReservationOrStatusRunning")
+	}
+	if interval <= 0 {
+		return fmt.Errorf(TRACE + " WebApp WatchConfigDir interval: mustBePositive")
+	}
+
+	if _, err := configdir.Load(dir); err != nil {
+		return err
+	}
+
+	webApp.configDir.mutex.Lock()
+	if webApp.configDir.watcher != nil {
+		webApp.configDir.mutex.Unlock()
+		return fmt.Errorf(TRACE + " WebApp WatchConfigDir: alreadyWatching")
+	}
+	watcher := configdir.NewWatcher(dir, interval, webApp.ApplyConfigDir)
+	watcher.OnError = func(err error) {
+		fmt.Println(TRACE+" WebApp WatchConfigDir:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	webApp.configDir.watcher = watcher
+	webApp.configDir.cancel = cancel
+	webApp.configDir.mutex.Unlock()
+
+	watcher.Poll()
+	go watcher.Run(ctx)
+	return nil
+}
+
+// stopConfigDir stops watching the config directory, if it is watched. What was
+// applied from it stays.
+func (webApp *WebApp) stopConfigDir() {
+	webApp.configDir.mutex.Lock()
+	defer webApp.configDir.mutex.Unlock()
+	if webApp.configDir.cancel != nil {
+		webApp.configDir.cancel()
+		webApp.configDir.cancel = nil
+		webApp.configDir.watcher = nil
+	}
+}
+
+// ConfigDirStatus the outcome of the last change of the watched config directory.
+func (webApp *WebApp) ConfigDirStatus() (ConfigDirStatus, bool) {
+	webApp.configDir.mutex.Lock()
+	defer webApp.configDir.mutex.Unlock()
+	return webApp.configDir.status, webApp.configDir.watcher != nil
+}
+
+// ApplyConfigDir brings the settings, the running connectors and tenants to the
+// snapshot: the ones applied from an earlier snapshot are deleted when their file is
+// gone, and the others are created or updated when their file changed. An invalid
+// file leaves its settings, connector or tenant as it is.
+func (webApp *WebApp) ApplyConfigDir(snapshot *configdir.Snapshot) {
+	state := &webApp.configDir
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.connectors == nil {
+		state.connectors = make(map[string]server.ConnectorConfig)
+		state.tenants = make(map[string]bool)
+	}
+
+	errors := append([]configdir.FileError(nil), snapshot.Errors...)
+	report := func(path string, err error) {
+		fmt.Println(TRACE+" WebApp ApplyConfigDir", path+":", err)
+		errors = append(errors, configdir.FileError{Path: path, Error: err.Error()})
+	}
+	desiredConnectors := *snapshot.Server.Connectors
+	desiredTenants := snapshot.MultiTenancy.Tenants
+
+	// The settings go first, as the connectors and tenants are checked against them.
+	if snapshot.Settings != nil {
+		var settingsConfig SettingsConfig
+		err := format.DecodeStrict(format.JSON, snapshot.Settings, &settingsConfig)
+		if err == nil {
+			err = webApp.ApplySettings(settingsConfig)
+		}
+		if err != nil {
+			report(snapshot.SettingsPath, err)
+		} else {
+			state.settings = true
+		}
+	} else if snapshot.SettingsPath == "" && state.settings {
+		if err := webApp.ApplySettings(SettingsConfig{}); err != nil {
+			report(snapshot.Dir, err)
+		} else {
+			state.settings = false
+		}
+	}
+
+	for _, tenantID := range sortedNames(state.tenants) {
+		if _, found := desiredTenants[tenantID]; found || snapshot.InvalidTenants[tenantID] {
+			continue
+		}
+		if err := webApp.DeleteTenant(tenantID); err != nil {
+			report(snapshot.TenantPath(tenantID), err)
+			continue
+		}
+		delete(state.tenants, tenantID)
+	}
+
+	for connectorName, applied := range state.connectors {
+		if snapshot.InvalidConnectors[connectorName] {
+			continue
+		}
+		desired, found := desiredConnectors[connectorName]
+		if found && reflect.DeepEqual(desired, applied) {
+			continue
+		}
+		if found {
+			// A connector that fails to change keeps its previous config.
+			if err := webApp.ReplaceServerConnector(connectorName, desired); err != nil {
+				report(snapshot.ConnectorPath(connectorName), err)
+				if _, running := webApp.server.RunningEndpointsConnectors[connectorName]; !running {
+					delete(state.connectors, connectorName)
+				}
+				continue
+			}
+			state.connectors[connectorName] = desired
+			continue
+		}
+		if err := webApp.DeleteServerConnector(connectorName); err != nil {
+			report(snapshot.ConnectorPath(connectorName), err)
+			continue
+		}
+		delete(state.connectors, connectorName)
+	}
+	for _, connectorName := range sortedNames(desiredConnectors) {
+		desired := desiredConnectors[connectorName]
+		if _, found := state.connectors[connectorName]; found {
+			continue
+		}
+		if err := webApp.CreateServerConnector(connectorName, desired); err != nil {
+			report(snapshot.ConnectorPath(connectorName), err)
+			continue
+		}
+		state.connectors[connectorName] = desired
+	}
+
+	for _, tenantID := range sortedNames(desiredTenants) {
+		desired := desiredTenants[tenantID]
+		var err error
+		if running, found := webApp.multiTenancySupport.Tenants()[tenantID]; !found {
+			err = webApp.CreateTenant(tenantID, desired)
+		} else if !sameJson(running, desired) {
+			_, err = webApp.UpdateTenant(tenantID, desired)
+		}
+		if err != nil {
+			report(snapshot.TenantPath(tenantID), err)
+			continue
+		}
+		state.tenants[tenantID] = true
+	}
+
+	state.status = ConfigDirStatus{
+		Dir:        snapshot.Dir,
+		AppliedAt:  time.Now(),
+		Connectors: sortedNames(state.connectors),
+		Tenants:    sortedNames(state.tenants),
+		Errors:     errors,
+	}
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sameJson(a, b interface{}) bool {
+	aJson, aErr := json.Marshal(a)
+	bJson, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && string(aJson) == string(bJson)
+}
@@ -0,0 +1,96 @@
+package webapp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/configdir"
+	"github.com/riotemergence/godynamicweb/server"
+)
+
+// freePort a port the system had free just now: one it bound for port 0, read back
+// from the listener.
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestConfigDirKeepsConnectorThatFailsToChange(t *testing.T) {
+	w := NewWebApp()
+	if err := w.SetServerConfigurationSlot(""); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	connectorPath := filepath.Join(dir, configdir.ConnectorsDir, "plain.json")
+	if err := os.MkdirAll(filepath.Dir(connectorPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	apply := func(port uint16) ConfigDirStatus {
+		t.Helper()
+		connectorJson := `{"bindAddress":"127.0.0.1","port":` + strconv.Itoa(int(port)) + `,"tls":false}`
+		if err := os.WriteFile(connectorPath, []byte(connectorJson), 0600); err != nil {
+			t.Fatal(err)
+		}
+		snapshot, err := configdir.Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.ApplyConfigDir(snapshot)
+		status, _ := w.ConfigDirStatus()
+		return status
+	}
+
+	port := freePort(t)
+	if status := apply(port); len(status.Errors) != 0 {
+		t.Fatal(status)
+	}
+	defer w.DeleteServerConnector("plain")
+
+	// The new port is taken: the connector stays on the previous one.
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	status := apply(uint16(taken.Addr().(*net.TCPAddr).Port))
+	if len(status.Errors) != 1 || len(status.Connectors) != 1 {
+		t.Fatal(status)
+	}
+	if moved := *(*w.server.Config.Connectors)["plain"].Port; moved != server.TCPPort(port) {
+		t.Fatalf("connector moved to %d", moved)
+	}
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(int(port)))
+	if err != nil {
+		t.Fatal("connector not listening:", err)
+	}
+	conn.Close()
+}
+
+func TestStopConfigDir(t *testing.T) {
+	w := NewWebApp()
+	if err := w.SetServerConfigurationSlot(""); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := w.WatchConfigDir(dir, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	w.stopConfigDir()
+	if _, watching := w.ConfigDirStatus(); watching {
+		t.Fatal("still watching")
+	}
+	// Once stopped, the directory can be watched again.
+	if err := w.WatchConfigDir(dir, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	w.stopConfigDir()
+}
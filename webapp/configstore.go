@@ -0,0 +1,214 @@
+package webapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/riotemergence/godynamicweb/configstore"
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/validation"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+// OpenConfigStore restores the connectors, templates, global certificates and tenants
+// kept in the store,
+// then writes every later change of them through to it before making it visible.
+// It is called once, after the endpoint slots are reserved and before connectors or
+// tenants are created in code, as those would be restored too.
+func (webApp *WebApp) OpenConfigStore(store configstore.ConfigStore) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp OpenConfigStore: statusMustBeStatusSlotReservationOrStatusRunning")
+	}
+	if webApp.configStore != nil {
+		return fmt.Errorf(TRACE + " WebApp OpenConfigStore: alreadyOpen")
+	}
+
+	connectorDocuments, err := store.Load(configstore.Connectors)
+	if err != nil {
+		return fmt.Errorf(TRACE+" WebApp OpenConfigStore: %s", err)
+	}
+	connectorNames := make([]string, 0, len(connectorDocuments))
+	for connectorName := range connectorDocuments {
+		connectorNames = append(connectorNames, connectorName)
+	}
+	sort.Strings(connectorNames)
+	for _, connectorName := range connectorNames {
+		var connectorConfig server.ConnectorConfig
+		if err := json.Unmarshal(connectorDocuments[connectorName], &connectorConfig); err != nil {
+			return fmt.Errorf(TRACE+" WebApp OpenConfigStore connectors \"%s\": %s", connectorName, err)
+		}
+		if running, found := (*webApp.server.Config.Connectors)[connectorName]; found && reflect.DeepEqual(running, connectorConfig) {
+			continue
+		}
+		if err := webApp.CreateServerConnector(connectorName, connectorConfig); err != nil {
+			return fmt.Errorf(TRACE+" WebApp OpenConfigStore connectors \"%s\": %s", connectorName, err)
+		}
+	}
+
+	templateDocuments, err := store.Load(configstore.Templates)
+	if err != nil {
+		return fmt.Errorf(TRACE+" WebApp OpenConfigStore: %s", err)
+	}
+	for templateName, templateDocument := range templateDocuments {
+		var history multitenancy.TenantTemplateHistory
+		if err := json.Unmarshal(templateDocument, &history); err != nil {
+			return fmt.Errorf(TRACE+" WebApp OpenConfigStore templates \"%s\": %s", templateName, err)
+		}
+		webApp.templatesMutex.Lock()
+		webApp.templates[templateName] = history
+		webApp.templatesMutex.Unlock()
+	}
+
+	if err := webApp.restoreX509Certificates(store); err != nil {
+		return err
+	}
+
+	tenantDocuments, err := store.Load(configstore.Tenants)
+	if err != nil {
+		return fmt.Errorf(TRACE+" WebApp OpenConfigStore: %s", err)
+	}
+	tenants := make(multitenancy.TenantsConfig, len(tenantDocuments))
+	for tenantID, tenantDocument := range tenantDocuments {
+		var tenantConfig multitenancy.TenantConfig
+		if err := json.Unmarshal(tenantDocument, &tenantConfig); err != nil {
+			return fmt.Errorf(TRACE+" WebApp OpenConfigStore tenants \"%s\": %s", tenantID, err)
+		}
+		tenants[tenantID] = tenantConfig
+	}
+	if len(tenants) > 0 {
+		if err := webApp.ReplaceTenants(tenants); err != nil {
+			return fmt.Errorf(TRACE+" WebApp OpenConfigStore tenants: %s", err)
+		}
+	}
+
+	webApp.configStore = store
+	webApp.multiTenancySupport.BeforeCommit = webApp.storeTenants
+	return nil
+}
+
+// storeTenants writes the tenants that a transaction creates, changes or removes.
+func (webApp *WebApp) storeTenants(before, after multitenancy.TenantsConfig) error {
+	changes := make([]configstore.Change, 0)
+	for tenantID, tenantConfig := range after {
+		change, err := configstore.Put(configstore.Tenants, tenantID, tenantConfig)
+		if err != nil {
+			return err
+		}
+		if previous, found := before[tenantID]; found {
+			if previousJson, err := json.Marshal(previous); err == nil && bytes.Equal(previousJson, change.Document) {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+	for tenantID := range before {
+		if _, found := after[tenantID]; !found {
+			changes = append(changes, configstore.Delete(configstore.Tenants, tenantID))
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return webApp.configStore.Apply(changes...)
+}
+
+func (webApp *WebApp) storeConnector(connectorName string, connectorConfig server.ConnectorConfig) error {
+	if webApp.configStore == nil {
+		return nil
+	}
+	change, err := configstore.Put(configstore.Connectors, connectorName, connectorConfig)
+	if err != nil {
+		return err
+	}
+	return webApp.configStore.Apply(change)
+}
+
+func (webApp *WebApp) deleteStoredConnector(connectorName string) error {
+	if webApp.configStore == nil {
+		return nil
+	}
+	return webApp.configStore.Apply(configstore.Delete(configstore.Connectors, connectorName))
+}
+
+// storedX509Certificate a global certificate, as kept in the store.
+type storedX509Certificate struct {
+	x509.X509Config
+	Default bool `json:"default,omitempty"`
+}
+
+// x509StoreName the name a global certificate is kept under.
+func x509StoreName(certificate *x509.Certificate) string {
+	return certificate.Names[0]
+}
+
+func (webApp *WebApp) restoreX509Certificates(store configstore.ConfigStore) error {
+	x509Documents, err := store.Load(configstore.X509)
+	if err != nil {
+		return fmt.Errorf(TRACE+" WebApp OpenConfigStore: %s", err)
+	}
+	return webApp.updateX509Certificates(func(certificates *x509.CertificateIndex) error {
+		for _, name := range validation.SortedKeys(x509Documents) {
+			var stored storedX509Certificate
+			if err := json.Unmarshal(x509Documents[name], &stored); err != nil {
+				return fmt.Errorf(TRACE+" WebApp OpenConfigStore x509 \"%s\": %s", name, err)
+			}
+			certificate, err := stored.Load()
+			if err != nil {
+				return fmt.Errorf(TRACE+" WebApp OpenConfigStore x509 \"%s\": %s", name, err)
+			}
+			if replaced, found := certificates.GetExact(name); found {
+				certificates.Remove(replaced)
+			}
+			if err := webApp.addX509Certificate(certificates, certificate); err != nil {
+				return fmt.Errorf(TRACE+" WebApp OpenConfigStore x509 \"%s\": %s", name, err)
+			}
+			if stored.Default {
+				certificates.SetDefault(certificate)
+			}
+		}
+		return nil
+	})
+}
+
+// storeX509Certificates writes the global certificates that an update adds, replaces
+// or removes, and the change of the default one.
+func (webApp *WebApp) storeX509Certificates(before, after *x509.CertificateIndex) error {
+	if webApp.configStore == nil {
+		return nil
+	}
+	beforeDefault, _ := before.Default()
+	afterDefault, _ := after.Default()
+	changes := make([]configstore.Change, 0)
+	put := make(map[string]bool)
+	for _, certificate := range after.Certificates() {
+		if !containsCertificate(before, certificate) || (certificate == beforeDefault) != (certificate == afterDefault) {
+			change, err := configstore.Put(configstore.X509, x509StoreName(certificate), storedX509Certificate{
+				X509Config: certificate.Config,
+				Default:    certificate == afterDefault,
+			})
+			if err != nil {
+				return err
+			}
+			changes = append(changes, change)
+			put[x509StoreName(certificate)] = true
+		}
+	}
+	for _, certificate := range before.Certificates() {
+		if !containsCertificate(after, certificate) && !put[x509StoreName(certificate)] {
+			changes = append(changes, configstore.Delete(configstore.X509, x509StoreName(certificate)))
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return webApp.configStore.Apply(changes...)
+}
+
+func containsCertificate(certificates *x509.CertificateIndex, certificate *x509.Certificate) bool {
+	indexed, found := certificates.GetExact(certificate.Names[0])
+	return found && indexed == certificate
+}
@@ -0,0 +1,86 @@
+package webapp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	gox509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/configstore"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+func selfSignedX509Config(t *testing.T, name string) x509.X509Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &gox509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificateBytes, err := gox509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := gox509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x509.X509Config{
+		PKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}),
+		Cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes}),
+	}
+}
+
+func TestConfigStoreKeepsX509Certificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	open := func() *WebApp {
+		w := NewWebApp()
+		if err := w.SetServerConfigurationSlot(""); err != nil {
+			t.Fatal(err)
+		}
+		store, err := configstore.NewFileConfigStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.OpenConfigStore(store); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	w := open()
+	for _, name := range []string{"a.test", "b.test", "c.test"} {
+		if err := w.ReplaceX509Certificate(name, selfSignedX509Config(t, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.SetDefaultX509Certificate("b.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.DeleteX509Certificate("c.test"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := open().X509Certificates()
+	if len(restored.Certificates()) != 2 {
+		t.Fatalf("restored %s", restored.Certificates())
+	}
+	if _, found := restored.GetExact("c.test"); found {
+		t.Fatal("deleted certificate restored")
+	}
+	if defaultCertificate, found := restored.Default(); !found || defaultCertificate.CommonName != "b.test" {
+		t.Fatal("default certificate not restored")
+	}
+}
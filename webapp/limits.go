@@ -0,0 +1,300 @@
+package webapp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/ratelimit"
+	"github.com/riotemergence/godynamicweb/util"
+)
+
+const maxClientIpsPerTenant = 10000
+
+type tenantLimiter struct {
+	config    *multitenancy.LimitsConfig
+	tenant    *ratelimit.TokenBucket
+	endpoints map[string]*ratelimit.TokenBucket
+	clientIps *ratelimit.KeyedTokenBuckets
+	// bulkheads by endpoint key, and tenantBulkhead for the whole tenant.
+	bulkheads map[string]*ratelimit.Bulkhead
+}
+
+// tenantBulkhead the bulkheads key of the concurrency limit of the whole tenant.
+const tenantBulkhead = "tenant"
+
+// tenantQuotas survive config changes, so updating a tenant does not reset its usage.
+type tenantQuotas struct {
+	daily   *ratelimit.Quota
+	monthly *ratelimit.Quota
+}
+
+type tenantLimiters struct {
+	mutex    sync.Mutex
+	limiters map[string]*tenantLimiter
+	quotas   map[string]*tenantQuotas
+	// bulkheads the bulkheads of every tenant, by tenant then by name. Like the
+	// quotas, they survive config changes, keeping the requests in flight and queued.
+	bulkheads map[string]map[string]*ratelimit.Bulkhead
+	// onBulkheadChange when set, returns the Bulkhead OnChange hook of a bulkhead of
+	// a tenant.
+	onBulkheadChange func(tenantID string, bulkhead string) func(inFlightDelta, queuedDelta int)
+}
+
+func newTenantLimiters() *tenantLimiters {
+	return &tenantLimiters{
+		limiters:  make(map[string]*tenantLimiter),
+		quotas:    make(map[string]*tenantQuotas),
+		bulkheads: make(map[string]map[string]*ratelimit.Bulkhead),
+	}
+}
+
+// get the limiter of a tenant, rebuilding it when its config changed.
+func (l *tenantLimiters) get(tenantID string, config *multitenancy.LimitsConfig) (*tenantLimiter, *tenantQuotas) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limiter, found := l.limiters[tenantID]
+	if found && limiter.config == config {
+		return limiter, l.quotas[tenantID]
+	}
+
+	limiter = &tenantLimiter{
+		config:    config,
+		endpoints: make(map[string]*ratelimit.TokenBucket),
+		bulkheads: make(map[string]*ratelimit.Bulkhead),
+	}
+	if config.Tenant != nil {
+		limiter.tenant = ratelimit.NewTokenBucket(*config.Tenant.RequestsPerSecond, config.Tenant.BurstOrDefault())
+	}
+	for endpointKey, endpointConfig := range config.Endpoints {
+		limiter.endpoints[endpointKey] = ratelimit.NewTokenBucket(*endpointConfig.RequestsPerSecond, endpointConfig.BurstOrDefault())
+	}
+	if config.ClientIp != nil {
+		limiter.clientIps = ratelimit.NewKeyedTokenBuckets(*config.ClientIp.RequestsPerSecond, config.ClientIp.BurstOrDefault(), maxClientIpsPerTenant)
+	}
+	concurrencyConfigs := make(map[string]multitenancy.ConcurrencyConfig, len(config.EndpointConcurrency)+1)
+	for endpointKey, concurrencyConfig := range config.EndpointConcurrency {
+		concurrencyConfigs[endpointKey] = concurrencyConfig
+	}
+	if config.Concurrency != nil {
+		concurrencyConfigs[tenantBulkhead] = *config.Concurrency
+	}
+	for name, concurrencyConfig := range concurrencyConfigs {
+		limiter.bulkheads[name] = l.updateBulkhead(l.bulkheads[tenantID][name], tenantID, name, concurrencyConfig)
+	}
+	l.bulkheads[tenantID] = limiter.bulkheads
+	l.limiters[tenantID] = limiter
+
+	quotas, found := l.quotas[tenantID]
+	if !found {
+		quotas = &tenantQuotas{}
+		l.quotas[tenantID] = quotas
+	}
+	quotas.daily = updateQuota(quotas.daily, ratelimit.Daily, config.Quota, func(c *multitenancy.QuotaConfig) *int64 { return c.Daily })
+	quotas.monthly = updateQuota(quotas.monthly, ratelimit.Monthly, config.Quota, func(c *multitenancy.QuotaConfig) *int64 { return c.Monthly })
+	return limiter, quotas
+}
+
+// updateBulkhead the bulkhead with the limits of config, bulkhead itself when there is
+// one, so that the requests in flight and queued count against the new limits.
+func (l *tenantLimiters) updateBulkhead(bulkhead *ratelimit.Bulkhead, tenantID string, name string, config multitenancy.ConcurrencyConfig) *ratelimit.Bulkhead {
+	if bulkhead != nil {
+		bulkhead.SetLimits(*config.MaxInFlight, config.MaxQueuedOrDefault(), config.QueueTimeoutOrDefault())
+		return bulkhead
+	}
+	bulkhead = ratelimit.NewBulkhead(*config.MaxInFlight, config.MaxQueuedOrDefault(), config.QueueTimeoutOrDefault())
+	if l.onBulkheadChange != nil {
+		bulkhead.OnChange = l.onBulkheadChange(tenantID, name)
+	}
+	return bulkhead
+}
+
+func updateQuota(quota *ratelimit.Quota, period ratelimit.Period, config *multitenancy.QuotaConfig, limitFn func(*multitenancy.QuotaConfig) *int64) *ratelimit.Quota {
+	if config == nil || limitFn(config) == nil {
+		return nil
+	}
+	limit := *limitFn(config)
+	if quota == nil {
+		return ratelimit.NewQuota(period, limit)
+	}
+	quota.SetLimit(limit)
+	return quota
+}
+
+// retain forgets the limiters, the quotas and the bulkheads of the tenants that are
+// gone.
+func (l *tenantLimiters) retain(tenants multitenancy.TenantsConfig) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for tenantID := range l.limiters {
+		if _, found := tenants[tenantID]; !found {
+			delete(l.limiters, tenantID)
+		}
+	}
+	for tenantID := range l.quotas {
+		if _, found := tenants[tenantID]; !found {
+			delete(l.quotas, tenantID)
+		}
+	}
+	for tenantID := range l.bulkheads {
+		if _, found := tenants[tenantID]; !found {
+			delete(l.bulkheads, tenantID)
+		}
+	}
+}
+
+// take applies every limit of the tenant to a request, stopping at the first one
+// exceeded. The status is the most restrictive one, to be sent as RateLimit headers.
+// The limits go from the narrowest to the widest, so a client over its own limit does
+// not spend the tokens of the endpoint or of the tenant.
+func (l *tenantLimiters) take(tenantID string, config *multitenancy.LimitsConfig, endpointKey string, clientIp string, now time.Time) (ratelimit.Status, bool) {
+	limiter, quotas := l.get(tenantID, config)
+
+	takeFns := make([]func() ratelimit.Status, 0, 5)
+	if limiter.clientIps != nil {
+		takeFns = append(takeFns, func() ratelimit.Status { return limiter.clientIps.Take(clientIp, now) })
+	}
+	if endpointBucket, found := limiter.endpoints[endpointKey]; found {
+		takeFns = append(takeFns, func() ratelimit.Status { return endpointBucket.Take(now) })
+	}
+	if limiter.tenant != nil {
+		takeFns = append(takeFns, func() ratelimit.Status { return limiter.tenant.Take(now) })
+	}
+	if quotas.daily != nil {
+		takeFns = append(takeFns, func() ratelimit.Status { return quotas.daily.Take(now) })
+	}
+	if quotas.monthly != nil {
+		takeFns = append(takeFns, func() ratelimit.Status { return quotas.monthly.Take(now) })
+	}
+
+	var mostRestrictive *ratelimit.Status
+	for _, takeFn := range takeFns {
+		status := takeFn()
+		if !status.Allowed {
+			return status, false
+		}
+		if mostRestrictive == nil || status.Remaining < mostRestrictive.Remaining {
+			mostRestrictive = &status
+		}
+	}
+	if mostRestrictive == nil {
+		return ratelimit.Status{Allowed: true}, true
+	}
+	return *mostRestrictive, true
+}
+
+// acquire a slot of the endpoint bulkhead then of the tenant one, waiting in their
+// queues. The bulkhead that rejected the request is returned when not acquired.
+func (l *tenantLimiters) acquire(ctx context.Context, tenantID string, config *multitenancy.LimitsConfig, endpointKey string) (release func(), rejectedBy *ratelimit.Bulkhead, acquired bool) {
+	limiter, _ := l.get(tenantID, config)
+
+	releaseFns := make([]func(), 0, 2)
+	release = func() {
+		for i := len(releaseFns) - 1; i >= 0; i-- {
+			releaseFns[i]()
+		}
+	}
+	for _, name := range []string{endpointKey, tenantBulkhead} {
+		bulkhead, found := limiter.bulkheads[name]
+		if !found {
+			continue
+		}
+		releaseFn, acquired := bulkhead.Acquire(ctx)
+		if !acquired {
+			release()
+			return nil, bulkhead, false
+		}
+		releaseFns = append(releaseFns, releaseFn)
+	}
+	return release, nil, true
+}
+
+// writeBulkheadRejection answers 503 Service Unavailable to a request that found the
+// bulkhead full, asking to retry after its queue timeout.
+func writeBulkheadRejection(w http.ResponseWriter, bulkhead *ratelimit.Bulkhead) {
+	_, _, queueTimeout := bulkhead.Limits()
+	retryAfter := int(math.Ceil(queueTimeout.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+type TenantUsage struct {
+	Tenant    *ratelimit.Status           `json:"tenant,omitempty"`
+	Endpoints map[string]ratelimit.Status `json:"endpoints,omitempty"`
+	ClientIps int                         `json:"clientIps"`
+	Quotas    []ratelimit.Usage           `json:"quotas"`
+	// Bulkheads by endpoint key, or "tenant" for the whole tenant.
+	Bulkheads map[string]ratelimit.BulkheadUsage `json:"bulkheads,omitempty"`
+}
+
+func (u TenantUsage) String() string {
+	return util.ToJson(u)
+}
+
+func (l *tenantLimiters) usage(tenantID string, config *multitenancy.LimitsConfig, now time.Time) TenantUsage {
+	usage := TenantUsage{
+		Endpoints: make(map[string]ratelimit.Status),
+		Quotas:    make([]ratelimit.Usage, 0),
+	}
+	if config == nil {
+		return usage
+	}
+
+	limiter, quotas := l.get(tenantID, config)
+	if limiter.tenant != nil {
+		status := limiter.tenant.Peek(now)
+		usage.Tenant = &status
+	}
+	for endpointKey, endpointBucket := range limiter.endpoints {
+		usage.Endpoints[endpointKey] = endpointBucket.Peek(now)
+	}
+	if limiter.clientIps != nil {
+		usage.ClientIps = limiter.clientIps.Len()
+	}
+	if len(limiter.bulkheads) > 0 {
+		usage.Bulkheads = make(map[string]ratelimit.BulkheadUsage, len(limiter.bulkheads))
+		for name, bulkhead := range limiter.bulkheads {
+			usage.Bulkheads[name] = bulkhead.Usage()
+		}
+	}
+	if quotas.daily != nil {
+		usage.Quotas = append(usage.Quotas, quotas.daily.Usage(now))
+	}
+	if quotas.monthly != nil {
+		usage.Quotas = append(usage.Quotas, quotas.monthly.Usage(now))
+	}
+	return usage
+}
+
+// writeRateLimitHeaders sets the RateLimit-* headers, and answers 429 Too Many
+// Requests when the request is not allowed.
+func writeRateLimitHeaders(w http.ResponseWriter, status ratelimit.Status) {
+	if status.Limit == 0 && status.Allowed {
+		return
+	}
+	resetSeconds := strconv.Itoa(int(math.Ceil(status.Reset.Seconds())))
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(status.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(status.Remaining, 10))
+	w.Header().Set("RateLimit-Reset", resetSeconds)
+	if !status.Allowed {
+		w.Header().Set("Retry-After", resetSeconds)
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	}
+}
+
+func (webApp *WebApp) GetTenantUsage(tenantID string) (TenantUsage, error) {
+	config, found := webApp.multiTenancySupport.Tenants()[tenantID]
+	if !found {
+		return TenantUsage{}, fmt.Errorf(TRACE+" WebApp GetTenantUsage tenantID: mustExist \"%s\"", tenantID)
+	}
+	return webApp.tenantLimiters.usage(tenantID, config.Limits, time.Now()), nil
+}
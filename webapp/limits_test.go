@@ -0,0 +1,89 @@
+package webapp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/server"
+)
+
+func TestBulkheadsAcrossUpdates(t *testing.T) {
+	w := NewWebApp()
+	block := make(chan struct{})
+	w.AddTenantServerEndpointSlot("slow", "GET", func(webApp *WebApp, tenantID string, rw http.ResponseWriter, r *http.Request) {
+		<-block
+		io.WriteString(rw, "ok")
+	})
+	if err := w.CreateServerConnector("plain", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.DeleteServerConnector("plain")
+	address := connectorAddress(w, "plain")
+	tenantConfig := func(burst int) multitenancy.TenantConfig {
+		var config multitenancy.TenantConfig
+		configJson := `{"name":"acme","serverEndpoints":{"slow":{"url":"http://acme.test/slow","connector":"plain"}},
+			"limits":{"tenant":{"requestsPerSecond":0.001,"burst":` + strconv.Itoa(burst) + `},
+				"endpointConcurrency":{"server/slow":{"maxInFlight":1}}}}`
+		if err := json.Unmarshal([]byte(configJson), &config); err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+	if err := w.CreateTenant("acme", tenantConfig(1)); err != nil {
+		t.Fatal(err)
+	}
+	get := func() int {
+		request, _ := http.NewRequest("GET", "http://"+address+"/slow", nil)
+		request.Host = "acme.test"
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		defer response.Body.Close()
+		io.ReadAll(response.Body)
+		return response.StatusCode
+	}
+	inFlight := func() int {
+		usage, err := w.GetTenantUsage("acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return usage.Bulkheads["server/slow"].InFlight
+	}
+
+	blocked := make(chan int)
+	go func() { blocked <- get() }()
+	for inFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The update keeps the request in flight, so the bulkhead stays full.
+	if _, err := w.UpdateTenant("acme", tenantConfig(2)); err != nil {
+		t.Fatal(err)
+	}
+	if inFlight() != 1 {
+		t.Fatal(inFlight())
+	}
+	// The requests the bulkhead rejects spend no tokens.
+	for i := 0; i < 3; i++ {
+		if code := get(); code != http.StatusServiceUnavailable {
+			t.Fatal(code)
+		}
+	}
+
+	close(block)
+	if code := <-blocked; code != http.StatusOK {
+		t.Fatal(code)
+	}
+	for _, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := get(); code != expected {
+			t.Fatal(code, expected)
+		}
+	}
+}
@@ -1,25 +1,32 @@
 package webapp
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/gorilla/mux"
+	"github.com/riotemergence/godynamicweb/bundle"
+	"github.com/riotemergence/godynamicweb/format"
+	"github.com/riotemergence/godynamicweb/metrics"
 	"github.com/riotemergence/godynamicweb/multitenancy"
 	"github.com/riotemergence/godynamicweb/server"
 	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/x509"
 )
 
 func (webApp *WebApp) retrieveServerHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, webApp.server.String())
+	util.Write(w, r, webApp.server)
 }
 
 func (webApp *WebApp) deleteServerHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "Shutting down connector")
-	webApp.server.Stop()
+	webApp.Stop()
 }
 
 func (webApp *WebApp) listServerConnectorsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, webApp.server.Config.Connectors)
+	util.Write(w, r, webApp.server.Config.Connectors)
 }
 
 func (webApp *WebApp) createOrReplaceServerConnectorHandler(w http.ResponseWriter, r *http.Request) {
@@ -36,9 +43,9 @@ func (webApp *WebApp) createOrReplaceServerConnectorHandler(w http.ResponseWrite
 			}
 			return nil
 		},
-		func(connectorName string) error {
+		func(connectorName string) (fmt.Stringer, error) {
 			fmt.Println("Update")
-			return fmt.Errorf("Update not Permitted")
+			return nil, fmt.Errorf("Update not Permitted")
 		},
 		&c,
 	)
@@ -61,53 +68,497 @@ func (webApp *WebApp) deleteServerConnectorHandler(w http.ResponseWriter, r *htt
 			return webApp.DeleteServerConnector(connectorName)
 		})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+		util.Error(w, err, http.StatusConflict)
 	}
 }
 
+// listTenantsHandler lists every tenant, or only its own ones to a tenant admin.
 func (webApp *WebApp) listTenantsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, webApp.multiTenancySupport.Config.Tenants)
+	principal := requestPrincipal(r)
+	if principal.CanRead() {
+		util.Write(w, r, webApp.multiTenancySupport.Tenants())
+		return
+	}
+	tenants := make(multitenancy.TenantsConfig)
+	for _, tenantID := range principal.Tenants() {
+		if config, found := webApp.multiTenancySupport.Tenants()[tenantID]; found {
+			tenants[tenantID] = config
+		}
+	}
+	util.Write(w, r, tenants)
 }
 
 func (webApp *WebApp) createOrReplaceTenantHandler(w http.ResponseWriter, r *http.Request) {
 	var c multitenancy.TenantConfig
-	util.Put(w, r, "tenantID",
+	util.Put(w, r, "tenantId",
 		func(tenantID string) bool {
-			_, found := webApp.multiTenancySupport.Config.Tenants[tenantID]
+			_, found := webApp.multiTenancySupport.Tenants()[tenantID]
 			return found
 		},
 		func(tenantID string) error {
 			fmt.Println("Create")
+			if err := webApp.checkOperatorFields(r, tenantID, c); err != nil {
+				return err
+			}
 			if err := webApp.CreateTenant(tenantID, c); err != nil {
 				return err
 			}
 			return nil
 		},
-		func(tenantID string) error {
+		func(tenantID string) (fmt.Stringer, error) {
 			fmt.Println("Update")
-			return fmt.Errorf("Update not Permitted")
+			if err := webApp.checkOperatorFields(r, tenantID, c); err != nil {
+				return nil, err
+			}
+			diff, err := webApp.UpdateTenant(tenantID, c)
+			if err != nil {
+				return nil, err
+			}
+			return diff, nil
 		},
 		&c,
 	)
 }
 
 func (webApp *WebApp) retrieveTenantHandler(w http.ResponseWriter, r *http.Request) {
-	util.Get(w, r, "tenantID", func(tenantID string) (fmt.Stringer, bool) {
-		r, ok := webApp.multiTenancySupport.Config.Tenants[tenantID]
+	util.Get(w, r, "tenantId", func(tenantID string) (fmt.Stringer, bool) {
+		r, ok := webApp.multiTenancySupport.Tenants()[tenantID]
 		return r, ok
 	})
 }
 
 func (webApp *WebApp) deleteTenantHandler(w http.ResponseWriter, r *http.Request) {
-	err := util.Delete(w, r, "tenantID",
+	err := util.Delete(w, r, "tenantId",
 		func(tenantID string) bool {
-			_, ok := webApp.multiTenancySupport.Config.Tenants[tenantID]
+			_, ok := webApp.multiTenancySupport.Tenants()[tenantID]
 			return ok
 		},
 		func(tenantID string) error {
 			return webApp.DeleteTenant(tenantID)
 		})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusConflict)
+		util.Error(w, err, http.StatusConflict)
+	}
+}
+
+func (webApp *WebApp) validateTenantHandler(w http.ResponseWriter, r *http.Request) {
+	var c multitenancy.TenantConfig
+	if err := util.DecodeBody(r, &c); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+
+	tenantID := mux.Vars(r)["tenantId"]
+	conflicts, err := webApp.ValidateTenant(tenantID, c)
+	if err != nil {
+		util.Error(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(conflicts) > 0 {
+		w.WriteHeader(http.StatusConflict)
+	}
+	fmt.Fprint(w, util.ToJson(multitenancy.TenantConflictsError{
+		TenantID:  tenantID,
+		Conflicts: conflicts,
+	}))
+}
+
+// exportTenantHandler the bundle of the tenant, with the private keys encrypted with
+// the X-Bundle-Passphrase header, and the file-server content when files=true.
+func (webApp *WebApp) exportTenantHandler(w http.ResponseWriter, r *http.Request) {
+	tenantID := mux.Vars(r)["tenantId"]
+	if _, found := webApp.multiTenancySupport.Tenants()[tenantID]; !found {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := webApp.ExportTenant(tenantID, r.Header.Get("X-Bundle-Passphrase"), r.URL.Query().Get("files") == "true")
+	if err != nil {
+		util.Error(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", tenantID+".bundle.json"))
+	util.Write(w, r, b)
+}
+
+type importedTenant struct {
+	TenantID string `json:"tenantId"`
+	Created  bool   `json:"created"`
+}
+
+func (t importedTenant) String() string {
+	return util.ToJson(t)
+}
+
+func (webApp *WebApp) importTenantHandler(w http.ResponseWriter, r *http.Request) {
+	var request bundle.ImportRequest
+	if err := util.DecodeBody(r, &request); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+	if request.Bundle != nil {
+		importedTenantID := request.ImportedTenantID()
+		webApp.auditResource(r, "tenants/"+importedTenantID, "/tenants/{tenantId}", map[string]string{"tenantId": importedTenantID})
+		if !requestPrincipal(r).CanChangeTenant(importedTenantID) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	tenantID, created, err := webApp.importTenant(request, func(tenantID string, config multitenancy.TenantConfig) error {
+		return webApp.checkOperatorFields(r, tenantID, config)
+	})
+	var existsErr *TenantExistsError
+	var conflictsErr *multitenancy.TenantConflictsError
+	switch {
+	case errors.As(err, &existsErr) || errors.As(err, &conflictsErr):
+		util.Error(w, err, http.StatusConflict)
+		return
+	case err != nil:
+		util.Error(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	util.Write(w, r, importedTenant{TenantID: tenantID, Created: created})
+}
+
+func (webApp *WebApp) retrieveTenantStateHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "tenantId", func(tenantID string) (fmt.Stringer, bool) {
+		return webApp.multiTenancySupport.GetTenantState(tenantID)
+	})
+}
+
+func (webApp *WebApp) updateTenantStateHandler(w http.ResponseWriter, r *http.Request) {
+	var c multitenancy.TenantStateConfig
+	if err := util.DecodeBody(r, &c); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+
+	tenantID := mux.Vars(r)["tenantId"]
+	if _, found := webApp.multiTenancySupport.Tenants()[tenantID]; !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := webApp.SetTenantState(tenantID, c); err != nil {
+		util.Error(w, err, http.StatusConflict)
+		return
+	}
+	state, _ := webApp.multiTenancySupport.GetTenantState(tenantID)
+	util.Write(w, r, state)
+}
+
+func (webApp *WebApp) retrieveTenantUsageHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "tenantId", func(tenantID string) (fmt.Stringer, bool) {
+		usage, err := webApp.GetTenantUsage(tenantID)
+		return usage, err == nil
+	})
+}
+
+func (webApp *WebApp) retrieveTenantValuesHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "tenantId", func(tenantID string) (fmt.Stringer, bool) {
+		return webApp.multiTenancySupport.TenantValues(tenantID)
+	})
+}
+
+func (webApp *WebApp) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	webApp.tenantMetrics.registry.Write(w)
+}
+
+func (webApp *WebApp) listGenerationsHandler(w http.ResponseWriter, r *http.Request) {
+	util.Write(w, r, webApp.multiTenancySupport.Generations())
+}
+
+func (webApp *WebApp) diffGenerationsHandler(w http.ResponseWriter, r *http.Request) {
+	pathParameters := mux.Vars(r)
+	from, err := strconv.ParseUint(pathParameters["from"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid from generation", http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.ParseUint(pathParameters["to"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid to generation", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := webApp.multiTenancySupport.DiffGenerations(from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	util.Write(w, r, diff)
+}
+
+func (webApp *WebApp) rollbackGenerationHandler(w http.ResponseWriter, r *http.Request) {
+	generation, err := strconv.ParseUint(mux.Vars(r)["generation"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid generation", http.StatusBadRequest)
+		return
+	}
+
+	if _, found := webApp.multiTenancySupport.GetGeneration(generation); !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	current, err := webApp.RollbackTenants(generation)
+	if err != nil {
+		util.Error(w, err, http.StatusConflict)
+		return
+	}
+	util.Write(w, r, current)
+}
+
+type x509CertificatesInfo struct {
+	Default      string              `json:"default,omitempty"`
+	Certificates []*x509.Certificate `json:"certificates"`
+}
+
+func (i x509CertificatesInfo) String() string {
+	return util.ToJson(i)
+}
+
+func (webApp *WebApp) listX509CertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	certificates := webApp.X509Certificates()
+	info := x509CertificatesInfo{
+		Certificates: certificates.Certificates(),
+	}
+	if defaultCertificate, found := certificates.Default(); found {
+		info.Default = defaultCertificate.Names[0]
+	}
+	util.Write(w, r, info)
+}
+
+func (webApp *WebApp) createOrReplaceX509CertificateHandler(w http.ResponseWriter, r *http.Request) {
+	var c x509.X509Config
+	if err := util.DecodeBody(r, &c); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+
+	commonName := mux.Vars(r)["x509Cn"]
+	_, found := webApp.X509Certificates().GetExact(commonName)
+	if err := webApp.ReplaceX509Certificate(commonName, c); err != nil {
+		util.Error(w, err, http.StatusConflict)
+		return
+	}
+	if r.URL.Query().Get("default") == "true" {
+		if err := webApp.SetDefaultX509Certificate(commonName); err != nil {
+			util.Error(w, err, http.StatusConflict)
+			return
+		}
+	}
+	if !found {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (webApp *WebApp) retrieveX509CertificateHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "x509Cn", func(commonName string) (fmt.Stringer, bool) {
+		return webApp.X509Certificates().GetExact(commonName)
+	})
+}
+
+func (webApp *WebApp) deleteX509CertificateHandler(w http.ResponseWriter, r *http.Request) {
+	err := util.Delete(w, r, "x509Cn",
+		func(commonName string) bool {
+			_, found := webApp.X509Certificates().GetExact(commonName)
+			return found
+		},
+		func(commonName string) error {
+			return webApp.DeleteX509Certificate(commonName)
+		})
+	if err != nil {
+		util.Error(w, err, http.StatusConflict)
+	}
+}
+
+func (webApp *WebApp) listAuditEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if principal := requestPrincipal(r); !principal.CanRead() {
+		filter.Scope = make([]string, 0)
+		for _, tenantID := range principal.Tenants() {
+			filter.Scope = append(filter.Scope, "tenants/"+tenantID)
+		}
+	}
+	entries, queryable, err := webApp.AuditLog().Query(filter)
+	if !queryable {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		util.Error(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.Write(w, r, entries)
+}
+
+func (webApp *WebApp) retrieveAcmeHandler(w http.ResponseWriter, r *http.Request) {
+	acmeManager := webApp.acmeManager()
+	if acmeManager == nil {
+		http.NotFound(w, r)
+		return
+	}
+	util.Write(w, r, acmeManager.Statuses())
+}
+
+func (webApp *WebApp) retrieveConfigDirHandler(w http.ResponseWriter, r *http.Request) {
+	status, watching := webApp.ConfigDirStatus()
+	if !watching {
+		http.NotFound(w, r)
+		return
+	}
+	util.Write(w, r, status)
+}
+
+func (webApp *WebApp) listSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	util.Write(w, r, configSchemas(ConfigSchemas()))
+}
+
+func (webApp *WebApp) retrieveSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "schemaName", func(schemaName string) (fmt.Stringer, bool) {
+		s, found := ConfigSchemas()[schemaName]
+		if found && format.FromAccept(r.Header.Get("Accept")) == format.JSON {
+			w.Header().Set("Content-Type", "application/schema+json")
+		}
+		return s, found
+	})
+}
+
+func (webApp *WebApp) listTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	util.Write(w, r, webApp.Templates())
+}
+
+func (webApp *WebApp) createOrReplaceTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var c multitenancy.TemplateConfig
+	util.Put(w, r, "templateName",
+		func(templateName string) bool {
+			_, found := webApp.GetTemplate(templateName)
+			return found
+		},
+		func(templateName string) error {
+			_, err := webApp.PutTemplate(templateName, c)
+			return err
+		},
+		func(templateName string) (fmt.Stringer, error) {
+			template, err := webApp.PutTemplate(templateName, c)
+			if err != nil {
+				return nil, err
+			}
+			return template, nil
+		},
+		&c,
+	)
+}
+
+func (webApp *WebApp) retrieveTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "templateName", func(templateName string) (fmt.Stringer, bool) {
+		return webApp.GetTemplate(templateName)
+	})
+}
+
+func (webApp *WebApp) listTemplateVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "templateName", func(templateName string) (fmt.Stringer, bool) {
+		return webApp.TemplateVersions(templateName)
+	})
+}
+
+func (webApp *WebApp) retrieveTemplateVersionHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	util.Get(w, r, "templateName", func(templateName string) (fmt.Stringer, bool) {
+		return webApp.GetTemplateVersion(templateName, version)
+	})
+}
+
+func (webApp *WebApp) retrieveTemplateParametersHandler(w http.ResponseWriter, r *http.Request) {
+	util.Get(w, r, "templateName", func(templateName string) (fmt.Stringer, bool) {
+		template, found := webApp.GetTemplate(templateName)
+		if !found {
+			return nil, false
+		}
+		return template.ParametersSchema(), true
+	})
+}
+
+func (webApp *WebApp) deleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	err := util.Delete(w, r, "templateName",
+		func(templateName string) bool {
+			_, found := webApp.GetTemplate(templateName)
+			return found
+		},
+		func(templateName string) error {
+			return webApp.DeleteTemplate(templateName)
+		})
+	if err != nil {
+		util.Error(w, err, http.StatusConflict)
+	}
+}
+
+// createOrReplaceTenantFromTemplateHandler renders the template of the query, at its
+// latest version or at the version of the query, with the parameters of the body, then
+// creates the tenant, or replaces it when it exists.
+func (webApp *WebApp) createOrReplaceTenantFromTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var parameters map[string]interface{}
+	if err := util.DecodeBody(r, &parameters); err != nil {
+		http.Error(w, "Invalid Body", http.StatusBadRequest)
+		return
+	}
+
+	pathParameters := mux.Vars(r)
+	templateName := pathParameters["templateName"]
+	version := 0
+	if versionParameter := r.URL.Query().Get("version"); versionParameter != "" {
+		var err error
+		if version, err = strconv.Atoi(versionParameter); err != nil || version < 1 {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, found := webApp.GetTemplate(templateName); !found {
+		http.Error(w, "Unknown template", http.StatusNotFound)
+		return
+	}
+	if _, found := webApp.GetTemplateVersion(templateName, version); version != 0 && !found {
+		http.Error(w, "Unknown template version", http.StatusNotFound)
+		return
+	}
+	c, err := webApp.RenderTemplate(templateName, version, parameters)
+	if err != nil {
+		util.Error(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	tenantID := pathParameters["tenantId"]
+	if err := webApp.checkOperatorFields(r, tenantID, c); err != nil {
+		util.Error(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+	if _, found := webApp.multiTenancySupport.Tenants()[tenantID]; found {
+		diff, err := webApp.updateTenant(tenantID, c)
+		if err != nil {
+			util.Error(w, err, http.StatusConflict)
+			return
+		}
+		util.Write(w, r, diff)
+		return
+	}
+	if err := webApp.createTenant(tenantID, c); err != nil {
+		util.Error(w, err, http.StatusConflict)
+		return
 	}
+	w.WriteHeader(http.StatusCreated)
 }
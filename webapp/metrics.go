@@ -0,0 +1,158 @@
+package webapp
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/metrics"
+	"github.com/riotemergence/godynamicweb/multitenancy"
+)
+
+// DefaultMaxTenantMetricLabels how many tenants get their own metric series before
+// the rest are reported as metrics.OverflowLabelValue.
+const DefaultMaxTenantMetricLabels = 1000
+
+type tenantMetrics struct {
+	registry      *metrics.Registry
+	tenants       *metrics.CardinalityGuard
+	unrouted      *metrics.CounterVec
+	requests      *metrics.CounterVec
+	duration      *metrics.HistogramVec
+	requestBytes  *metrics.CounterVec
+	responseBytes *metrics.CounterVec
+	inFlight      *metrics.GaugeVec
+	queued        *metrics.GaugeVec
+}
+
+func newTenantMetrics(maxTenantLabels int) *tenantMetrics {
+	registry := metrics.NewRegistry()
+	endpointLabels := []string{"connector", "tenant", "endpoint", "kind"}
+	return &tenantMetrics{
+		registry: registry,
+		tenants:  metrics.NewCardinalityGuard(maxTenantLabels),
+		unrouted: registry.NewCounterVec("godynamicweb_unrouted_requests_total",
+			"Requests that matched no tenant route, or no tenant its resolver may serve.", "connector"),
+		requests: registry.NewCounterVec("godynamicweb_tenant_requests_total",
+			"Requests served for tenant endpoints, by status class.", append(endpointLabels, "status_class")...),
+		duration: registry.NewHistogramVec("godynamicweb_tenant_request_duration_seconds",
+			"Latency of the requests served for tenant endpoints.", metrics.DefaultBuckets, endpointLabels...),
+		requestBytes: registry.NewCounterVec("godynamicweb_tenant_request_bytes_total",
+			"Request body bytes read for tenant endpoints.", endpointLabels...),
+		responseBytes: registry.NewCounterVec("godynamicweb_tenant_response_bytes_total",
+			"Response body bytes written for tenant endpoints.", endpointLabels...),
+		inFlight: registry.NewGaugeVec("godynamicweb_tenant_inflight_requests",
+			"Requests being served within a concurrency limit of a tenant.", "tenant", "bulkhead"),
+		queued: registry.NewGaugeVec("godynamicweb_tenant_queued_requests",
+			"Requests waiting for a concurrency limit of a tenant.", "tenant", "bulkhead"),
+	}
+}
+
+// bulkheadChange the Bulkhead OnChange hook updating the gauges of a bulkhead of a
+// tenant; bulkhead is "tenant" or an endpoint key.
+func (m *tenantMetrics) bulkheadChange(tenantID string, bulkhead string) func(inFlightDelta, queuedDelta int) {
+	tenantLabel := m.tenants.Value(tenantID)
+	return func(inFlightDelta, queuedDelta int) {
+		if inFlightDelta != 0 {
+			m.inFlight.Add(float64(inFlightDelta), tenantLabel, bulkhead)
+		}
+		if queuedDelta != 0 {
+			m.queued.Add(float64(queuedDelta), tenantLabel, bulkhead)
+		}
+	}
+}
+
+// instrument serves a request for tenantID through serveFn, recording its metrics.
+func (m *tenantMetrics) instrument(connectorName, tenantID string, route multitenancy.TenantRoute, w http.ResponseWriter, r *http.Request, serveFn func(http.ResponseWriter, *http.Request)) {
+	start := time.Now()
+	metricsWriter := &metricsResponseWriter{ResponseWriter: w}
+	var requestBody *countingReadCloser
+	if r.Body != nil && r.Body != http.NoBody {
+		requestBody = &countingReadCloser{ReadCloser: r.Body}
+		r.Body = requestBody
+	}
+
+	defer func() {
+		labelValues := []string{connectorName, m.tenants.Value(tenantID), route.EndpointName(), string(route.Kind())}
+		m.requests.Inc(append(labelValues, metricsWriter.statusClass())...)
+		m.duration.Observe(time.Since(start).Seconds(), labelValues...)
+		if requestBody != nil {
+			m.requestBytes.Add(float64(requestBody.bytes), labelValues...)
+		}
+		m.responseBytes.Add(float64(metricsWriter.bytes), labelValues...)
+	}()
+	serveFn(metricsWriter, r)
+}
+
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int64
+}
+
+func (w *metricsResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *metricsResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *metricsResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *metricsResponseWriter) statusClass() string {
+	statusCode := w.statusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// SetMaxTenantMetricLabels changes how many tenants get their own metric series.
+func (webApp *WebApp) SetMaxTenantMetricLabels(maxTenantLabels int) {
+	webApp.tenantMetrics.tenants.SetMax(maxTenantLabels)
+}
+
+// retain drops the request series of the tenants that are gone and releases their
+// labels to other tenants. The bulkhead gauges are kept: requests still in flight
+// bring them back to zero.
+func (m *tenantMetrics) retain(tenants multitenancy.TenantsConfig) {
+	released := m.tenants.Retain(func(tenantID string) bool {
+		_, found := tenants[tenantID]
+		return found
+	})
+	for _, tenantID := range released {
+		m.requests.DeleteLabelValue("tenant", tenantID)
+		m.duration.DeleteLabelValue("tenant", tenantID)
+		m.requestBytes.DeleteLabelValue("tenant", tenantID)
+		m.responseBytes.DeleteLabelValue("tenant", tenantID)
+	}
+}
@@ -0,0 +1,45 @@
+package webapp
+
+import (
+	"sync"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/rbac"
+	"github.com/riotemergence/godynamicweb/schema"
+	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/util"
+	"github.com/riotemergence/godynamicweb/validation"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+// configSchemas the JSON Schemas of the config documents the management connector
+// accepts, by name.
+type configSchemas map[string]*schema.Schema
+
+func (s configSchemas) String() string {
+	return util.ToJson(validation.SortedKeys(s))
+}
+
+var (
+	schemasOnce sync.Once
+	schemas     configSchemas
+)
+
+// ConfigSchemas the JSON Schemas of the config documents, generated from the config
+// types: "tenant", "tenantState", "template", "connector", "x509", "accessControl",
+// "settings" and "webapp".
+func ConfigSchemas() map[string]*schema.Schema {
+	schemasOnce.Do(func() {
+		schemas = configSchemas{
+			"tenant":        schema.Generate(multitenancy.TenantConfig{}, "TenantConfig"),
+			"tenantState":   schema.Generate(multitenancy.TenantStateConfig{}, "TenantStateConfig"),
+			"template":      schema.Generate(multitenancy.TemplateConfig{}, "TemplateConfig"),
+			"connector":     schema.Generate(server.ConnectorConfig{}, "ConnectorConfig"),
+			"x509":          schema.Generate(x509.X509Config{}, "X509Config"),
+			"accessControl": schema.Generate(rbac.Config{}, "AccessControlConfig"),
+			"settings":      schema.Generate(SettingsConfig{}, "SettingsConfig"),
+			"webapp":        schema.Generate(WebAppConfig{}, "WebAppConfig"),
+		}
+	})
+	return schemas
+}
@@ -0,0 +1,143 @@
+package webapp
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/rbac"
+	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/validation"
+	"github.com/riotemergence/godynamicweb/x509"
+)
+
+// schemaExample a document that Validate and the schema must both accept, or both
+// reject.
+type schemaExample struct {
+	schema   string
+	document string
+	valid    bool
+}
+
+func schemaExamples() []schemaExample {
+	dir, _ := json.Marshal(os.TempDir())
+	return []schemaExample{
+		{"connector", `{"bindAddress":"127.0.0.1","port":8080,"tls":false}`, true},
+		{"connector", `{"bindAddress":"::1","port":443,"tls":true}`, true},
+		{"connector", `{"bindAddress":"localhost","port":8080,"tls":false}`, false},
+		{"connector", `{"bindAddress":"","port":8080,"tls":false}`, false},
+		{"connector", `{"bindAddress":"127.0.0.1","port":0,"tls":false}`, false},
+		{"connector", `{"bindAddress":"127.0.0.1","port":8080}`, false},
+		{"connector", `{"port":8080,"tls":false}`, false},
+		{"connector", `{"bindAddress":"127.0.0.1","port":8080,"tls":false,"tenantResolver":{"kind":"header","params":{"name":"X-Tenant"}}}`, true},
+		{"connector", `{"bindAddress":"127.0.0.1","port":8080,"tls":false,"tenantResolver":{"kind":"subdomain"}}`, false},
+		{"connector", `{"bindAddress":"127.0.0.1","port":8080,"tls":false,"tenantResolver":{"params":{}}}`, false},
+		{"x509", `{"pkey":"a2V5","cert":"Y2VydA=="}`, true},
+		{"x509", `{"pkey":"a2V5"}`, false},
+		{"accessControl", `{"principals":{"root":{"roles":["admin"],"token":{"env":"ROOT_TOKEN"}},"ops":{"roles":["operator","read-only"],"subjects":["ops.example.com"]},"a":{"roles":["tenant-admin:a"],"token":{"file":"/run/a"},"subjects":["a.example.com"]}}}`, true},
+		{"accessControl", `{"principals":{}}`, true},
+		{"accessControl", `{}`, false},
+		{"accessControl", `{"principals":{"root":{"roles":["root"],"token":{"env":"ROOT_TOKEN"}}}}`, false},
+		{"accessControl", `{"principals":{"root":{"roles":["tenant-admin:"],"token":{"env":"ROOT_TOKEN"}}}}`, false},
+		{"accessControl", `{"principals":{"root":{"roles":[],"token":{"env":"ROOT_TOKEN"}}}}`, false},
+		{"accessControl", `{"principals":{"root":{"roles":["admin"]}}}`, false},
+		{"accessControl", `{"principals":{"root":{"roles":["admin"],"subjects":[]}}}`, false},
+		{"accessControl", `{"principals":{"root":{"roles":["admin"],"token":{}}}}`, false},
+		{"x509", `{"pkey":"a2V5","cert":null}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{}}`, true},
+		{"tenant", `{"name":"a","x509":null,"serverEndpoints":{"hello":{"url":"http://a.test/hello","connector":"http"}},"reverseProxyEndpoints":null,"fileServerEndpoints":null}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"//*/hello","connector":"http"}}}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"https://a.test","connector":"http"}}}`, true},
+		{"tenant", `{"serverEndpoints":{}}`, false},
+		{"tenant", `{"name":"a"}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"ftp://a.test/hello","connector":"http"}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"/hello","connector":"http"}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"http:///hello","connector":"http"}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"http://*.a.test/hello","connector":"http"}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"http://a.test/hello"}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"reverseProxyEndpoints":[{"url":"http://a.test/api","connector":"http","methods":["GET"],"targetUrl":"http://backend:8080"}]}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"reverseProxyEndpoints":[{"url":"http://a.test/api","connector":"http","methods":[],"targetUrl":"http://backend:8080"}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"reverseProxyEndpoints":[{"url":"http://a.test/api","connector":"http","methods":["GET"],"targetUrl":"backend:8080"}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"reverseProxyEndpoints":[{"url":"http://a.test/api","connector":"http","methods":["GET"],"targetUrl":"ws://backend"}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"reverseProxyEndpoints":[{"url":"http://a.test/api","connector":"http","methods":["GET"]}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"fileServerEndpoints":[{"url":"http://a.test/static","connector":"http","rootFs":` + string(dir) + `}]}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"fileServerEndpoints":[{"url":"http://a.test/static","connector":"http"}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"redirectEndpoints":[{"url":"http://a.test/old/{id}/*","connector":"http","target":"https://b.test/new/{id}/*","status":301,"preserveQuery":true}]}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"redirectEndpoints":[{"url":"http://a.test/old","connector":"http","target":"/new","methods":["GET","POST"]}]}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"redirectEndpoints":[{"url":"http://a.test/old","connector":"http"}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"redirectEndpoints":[{"url":"http://a.test/old","connector":"http","target":"/new","status":200}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"redirectEndpoints":[{"url":"http://a.test/old","connector":"http","target":"/new","methods":[]}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"endpoints":{"redirect/0":{"requestsPerSecond":1}}}}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"tenant":{"requestsPerSecond":10,"burst":20},"endpoints":{"server/hello":{"requestsPerSecond":0.5}},"clientIp":{"requestsPerSecond":1},"quota":{"daily":1000}}}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"tenant":{"requestsPerSecond":0}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"tenant":{"requestsPerSecond":1,"burst":0}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"tenant":{"burst":1}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"endpoints":{"other/hello":{"requestsPerSecond":1}}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"endpoints":{"server/":{"requestsPerSecond":1}}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"quota":{}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"quota":{"monthly":-1}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"concurrency":{"maxInFlight":10,"maxQueued":20,"queueTimeout":0.5},"endpointConcurrency":{"server/hello":{"maxInFlight":2}}}}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"concurrency":{"maxInFlight":0}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"concurrency":{"maxQueued":1}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"concurrency":{"maxInFlight":1,"queueTimeout":0}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"limits":{"endpointConcurrency":{"other/hello":{"maxInFlight":1}}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"variables":{"flag":true,"limit":10,"upstream":{"url":"http://u"}},"secrets":{"apiKey":{"env":"API_KEY"},"dbPassword":{"file":"/run/secrets/db"}}}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"secrets":{"apiKey":{}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"secrets":{"apiKey":{"env":"API_KEY","file":"/run/secrets/api"}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"secrets":{"apiKey":{"env":""}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{"hello":{"url":"http://a.test/hello","connector":"http","middleware":[{"name":"maxBodySize","params":{"bytes":1024}}]}},"middleware":[{"name":"cors","params":{"allowedOrigins":["https://a.test"]}},{"name":"compress"}]}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"secrets":{"apiKey":{"env":"API_KEY"}},"middleware":[{"name":"auth","params":{"scheme":"bearer","tokens":["apiKey"]}}]}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"resolvableBy":["gateway"]}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"middleware":[{"name":"unknown"}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"middleware":[{"params":{}}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"middleware":[{"name":"compress","params":[]}]}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{"orders":{"url":"http://api.test/t/*","connector":"http","tenantResolver":{"kind":"pathPrefix","params":{"prefix":"/t/"}}}}}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{"orders":{"url":"http://api.test/orders","connector":"http","tenantResolver":{"kind":"header","params":"X-Tenant"}}}}`, false},
+		{"tenant", `{"name":"a","serverEndpoints":{},"template":{"name":"standard","version":2,"parameters":{"host":"a.test"}}}`, true},
+		{"tenant", `{"name":"a","serverEndpoints":{},"template":{"name":"standard"}}`, false},
+		{"template", `{"parameters":{"host":{"type":"string","pattern":"^[a-z.]+$","example":"a.test"},"port":{"type":"integer","default":8080}},"tenant":{"name":"{{ .host }}","serverEndpoints":{"hello":{"url":"http://{{ .host }}:{{ .port }}/hello","connector":"http"}}}}`, true},
+		{"template", `{"tenant":{"name":"fixed","serverEndpoints":{}}}`, true},
+		{"template", `{"parameters":{"host":{"type":"string"}}}`, false},
+		{"template", `{"parameters":{"host":{"type":"uuid"}},"tenant":{"name":"a","serverEndpoints":{}}}`, false},
+		{"template", `{"parameters":{"host-name":{"type":"string"}},"tenant":{"name":"a","serverEndpoints":{}}}`, false},
+		{"tenantState", `{"status":"maintenance","retryAfter":60}`, true},
+		{"tenantState", `{"status":"closed"}`, false},
+		{"tenantState", `{"status":"draining","drainTimeout":-1}`, false},
+		{"settings", `{"trustedProxies":["10.0.0.0/8","192.0.2.1","::1"]}`, true},
+		{"settings", `{}`, true},
+		{"settings", `{"secretDirs":["/run/secrets"],"secretEnvPrefixes":["TENANT_"]}`, true},
+		{"settings", `{"accessControl":{"principals":{"root":{"roles":["admin"],"token":{"env":"ROOT_TOKEN"}}}}}`, true},
+		{"settings", `{"anonymousManagement":true}`, true},
+		{"settings", `{"accessControl":{}}`, false},
+		{"webapp", `{"name":"app","version":"1","connectors":{"http":{"bindAddress":"0.0.0.0","port":80,"tls":false}},"tenants":{"name":"a","serverEndpoints":{}},"x509":{"pkey":"a2V5","cert":"Y2VydA=="}}`, true},
+		{"webapp", `{"name":"app","connectors":{},"tenants":{"name":"a","serverEndpoints":{}}}`, false},
+		{"webapp", `{"name":"app","version":"1","connectors":{"http":{"bindAddress":"0.0.0.0","port":80}},"tenants":{"name":"a","serverEndpoints":{}}}`, false},
+	}
+}
+
+// TestSchemas checks that the schemas accept the same example documents as Validate,
+// so that they can not drift apart when a config type or its Validate changes.
+func TestSchemas(t *testing.T) {
+	configs := map[string]func() validation.Validatable{
+		"tenant":        func() validation.Validatable { return &multitenancy.TenantConfig{} },
+		"tenantState":   func() validation.Validatable { return &multitenancy.TenantStateConfig{} },
+		"template":      func() validation.Validatable { return &multitenancy.TemplateConfig{} },
+		"connector":     func() validation.Validatable { return &server.ConnectorConfig{} },
+		"x509":          func() validation.Validatable { return &x509.X509Config{} },
+		"accessControl": func() validation.Validatable { return &rbac.Config{} },
+		"settings":      func() validation.Validatable { return &SettingsConfig{} },
+		"webapp":        func() validation.Validatable { return &WebAppConfig{} },
+	}
+	for index, example := range schemaExamples() {
+		config := configs[example.schema]()
+		if err := json.Unmarshal([]byte(example.document), config); err != nil {
+			t.Fatalf("example %d: %s", index, err)
+		}
+		validateErr := validation.Validate(config)
+		schemaErr := ConfigSchemas()[example.schema].Check([]byte(example.document))
+		if (validateErr == nil) != example.valid || (schemaErr == nil) != example.valid {
+			t.Errorf("example %d %s (valid %t): Validate %v, schema %v", index, example.schema, example.valid, validateErr, schemaErr)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package webapp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/rbac"
+)
+
+// settings the SettingsConfig of the WebApp, parsed.
+type settings struct {
+	trustedProxies      []*net.IPNet
+	anonymousManagement bool
+}
+
+// ApplySettings replaces the settings of the WebApp. What config leaves out is back
+// to its default. The access control replaces the one set with
+// SetManagementAccessControl.
+func (webApp *WebApp) ApplySettings(config SettingsConfig) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf(TRACE+" WebApp ApplySettings config: %w", err)
+	}
+	var policy *rbac.Policy
+	if config.AccessControl != nil {
+		var err error
+		if policy, err = config.AccessControl.Build(); err != nil {
+			return fmt.Errorf(TRACE+" WebApp ApplySettings config accessControl: %w", err)
+		}
+	}
+	applied := &settings{
+		anonymousManagement: config.AnonymousManagement != nil && *config.AnonymousManagement,
+	}
+	for _, trustedProxy := range config.TrustedProxies {
+		ipNet, _ := parseIpNet(trustedProxy)
+		applied.trustedProxies = append(applied.trustedProxies, ipNet)
+	}
+	webApp.multiTenancySupport.SetSecretSources(multitenancy.SecretSources{Dirs: config.SecretDirs, EnvPrefixes: config.SecretEnvPrefixes})
+	webApp.accessPolicy.Store(policy)
+	webApp.settings.Store(applied)
+	return nil
+}
+
+func (webApp *WebApp) currentSettings() *settings {
+	return webApp.settings.Load().(*settings)
+}
+
+func (s *settings) trusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, trustedProxy := range s.trustedProxies {
+		if trustedProxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp the address of the client of a request. X-Forwarded-For is only believed
+// when the request comes from a trusted proxy, and then only up to the first address
+// that is not one, walking back from the proxy.
+func (s *settings) clientIp(r *http.Request) string {
+	client := remoteIp(r)
+	if !s.trusted(client) {
+		return client
+	}
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		client = hop
+		if !s.trusted(hop) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,62 @@
+package webapp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+)
+
+func TestClientIp(t *testing.T) {
+	w := NewWebApp()
+	if err := w.ApplySettings(SettingsConfig{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor string
+		expectedIp   string
+	}{
+		{"198.51.100.7:1234", "203.0.113.9", "198.51.100.7"},
+		{"192.0.2.1:1234", "203.0.113.9", "203.0.113.9"},
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"10.1.2.3:1234", "203.0.113.66, 203.0.113.9, 10.0.0.1", "203.0.113.9"},
+		{"10.1.2.3:1234", "nonsense, 10.0.0.1", "10.0.0.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://a.test/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if ip := w.currentSettings().clientIp(r); ip != test.expectedIp {
+			t.Errorf("%s forwarded for %q: client %s, expected %s", test.remoteAddr, test.forwardedFor, ip, test.expectedIp)
+		}
+	}
+
+	if err := w.ApplySettings(SettingsConfig{TrustedProxies: []string{"10.0.0.0/33"}}); err == nil {
+		t.Fatal("an invalid range must be rejected")
+	}
+}
+
+func TestClientIpLimitSparesTenantTokens(t *testing.T) {
+	var limits multitenancy.LimitsConfig
+	if err := json.Unmarshal([]byte(`{"tenant":{"requestsPerSecond":0.001,"burst":2},"clientIp":{"requestsPerSecond":0.001,"burst":1}}`), &limits); err != nil {
+		t.Fatal(err)
+	}
+	l := newTenantLimiters()
+	now := time.Now()
+	if _, allowed := l.take("a", &limits, "", "203.0.113.9", now); !allowed {
+		t.Fatal("first request rejected")
+	}
+	for i := 0; i < 3; i++ {
+		if _, allowed := l.take("a", &limits, "", "203.0.113.9", now); allowed {
+			t.Fatal("client over its limit allowed")
+		}
+	}
+	if _, allowed := l.take("a", &limits, "", "203.0.113.10", now); !allowed {
+		t.Fatal("another client rejected: the tenant tokens were spent by a rejected one")
+	}
+}
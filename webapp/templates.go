@@ -0,0 +1,113 @@
+package webapp
+
+import (
+	"fmt"
+
+	"github.com/riotemergence/godynamicweb/configstore"
+	"github.com/riotemergence/godynamicweb/multitenancy"
+)
+
+// Templates the latest version of every tenant template.
+func (webApp *WebApp) Templates() multitenancy.TenantTemplates {
+	webApp.templatesMutex.RLock()
+	defer webApp.templatesMutex.RUnlock()
+	templates := make(multitenancy.TenantTemplates, len(webApp.templates))
+	for name, history := range webApp.templates {
+		if template, found := history.Latest(); found {
+			templates[name] = template
+		}
+	}
+	return templates
+}
+
+// GetTemplate the latest version of a template.
+func (webApp *WebApp) GetTemplate(name string) (multitenancy.TenantTemplate, bool) {
+	webApp.templatesMutex.RLock()
+	defer webApp.templatesMutex.RUnlock()
+	return webApp.templates[name].Latest()
+}
+
+// GetTemplateVersion a version of a template, which its later versions do not replace.
+func (webApp *WebApp) GetTemplateVersion(name string, version int) (multitenancy.TenantTemplate, bool) {
+	webApp.templatesMutex.RLock()
+	defer webApp.templatesMutex.RUnlock()
+	return webApp.templates[name].Version(version)
+}
+
+// TemplateVersions every version of a template, oldest first.
+func (webApp *WebApp) TemplateVersions(name string) (multitenancy.TenantTemplateVersions, bool) {
+	webApp.templatesMutex.RLock()
+	defer webApp.templatesMutex.RUnlock()
+	history := webApp.templates[name]
+	if _, found := history.Latest(); !found {
+		return nil, false
+	}
+	return append(multitenancy.TenantTemplateVersions(nil), history.Versions...), true
+}
+
+// PutTemplate validates config by rendering it with sample parameters, then stores it
+// as the next version of the template name. The versions are numbered from the last
+// one the name had, deleted or not, so that a version number is never reused.
+func (webApp *WebApp) PutTemplate(name string, config multitenancy.TemplateConfig) (multitenancy.TenantTemplate, error) {
+	if name == "" {
+		return multitenancy.TenantTemplate{}, fmt.Errorf(TRACE + " WebApp PutTemplate name: mustNotBeEmpty")
+	}
+	if err := config.Validate(); err != nil {
+		return multitenancy.TenantTemplate{}, fmt.Errorf(TRACE+" WebApp PutTemplate config: %w", err)
+	}
+
+	webApp.templatesMutex.Lock()
+	defer webApp.templatesMutex.Unlock()
+	history := webApp.templates[name].Next(name, config)
+	if err := webApp.storeTemplateHistory(name, history); err != nil {
+		return multitenancy.TenantTemplate{}, fmt.Errorf(TRACE+" WebApp PutTemplate: %s", err)
+	}
+	webApp.templates[name] = history
+	template, _ := history.Latest()
+	return template, nil
+}
+
+// DeleteTemplate removes a template. Its history is kept, for the numbering of its
+// versions, and so are the tenants rendered from it.
+func (webApp *WebApp) DeleteTemplate(name string) error {
+	webApp.templatesMutex.Lock()
+	defer webApp.templatesMutex.Unlock()
+	history := webApp.templates[name]
+	if _, found := history.Latest(); !found {
+		return fmt.Errorf(TRACE+" WebApp DeleteTemplate name: mustExist \"%s\"", name)
+	}
+	history.Deleted = true
+	if err := webApp.storeTemplateHistory(name, history); err != nil {
+		return fmt.Errorf(TRACE+" WebApp DeleteTemplate: %s", err)
+	}
+	webApp.templates[name] = history
+	return nil
+}
+
+func (webApp *WebApp) storeTemplateHistory(name string, history multitenancy.TenantTemplateHistory) error {
+	if webApp.configStore == nil {
+		return nil
+	}
+	change, err := configstore.Put(configstore.Templates, name, history)
+	if err != nil {
+		return err
+	}
+	return webApp.configStore.Apply(change)
+}
+
+// RenderTemplate the TenantConfig of a version of a template for parameters, of its
+// latest version when version is 0.
+func (webApp *WebApp) RenderTemplate(name string, version int, parameters map[string]interface{}) (multitenancy.TenantConfig, error) {
+	template, found := webApp.GetTemplate(name)
+	if version != 0 {
+		template, found = webApp.GetTemplateVersion(name, version)
+	}
+	if !found {
+		return multitenancy.TenantConfig{}, fmt.Errorf(TRACE+" WebApp RenderTemplate name: mustExist \"%s\"", name)
+	}
+	config, err := template.Render(template.Name, template.Version, parameters)
+	if err != nil {
+		return multitenancy.TenantConfig{}, fmt.Errorf(TRACE+" WebApp RenderTemplate \"%s\" parameters: %w", name, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,112 @@
+package webapp
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/configstore"
+	"github.com/riotemergence/godynamicweb/server"
+)
+
+func TestTemplateVersions(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "store.json")
+	w := NewWebApp()
+	allowAnonymousManagement(t, w)
+	w.AddTenantServerEndpointSlot("hello", "GET", func(webApp *WebApp, tenantID string, rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, tenantID)
+	})
+	if err := w.CreateServerConnector("http", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.DeleteServerConnector("http")
+	store, err := configstore.NewFileConfigStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.OpenConfigStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreateServerManagementConnector("management", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.server.RemoveConnector("management")
+	call := func(method, path, body string) (int, string) {
+		t.Helper()
+		request, _ := http.NewRequest(method, "http://"+connectorAddress(w, "management")+path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		read, _ := io.ReadAll(response.Body)
+		return response.StatusCode, strings.Join(strings.Fields(string(read)), "")
+	}
+
+	template := `{"parameters":{"host":{"type":"string"},"rate":{"type":"number","default":%s}},
+		"tenant":{"name":"{{ .host }}","serverEndpoints":{"hello":{"url":"http://{{ .host }}/hello","connector":"http"}},
+		"limits":{"tenant":{"requestsPerSecond":"{{ .rate }}"}}}}`
+	for _, rate := range []string{"10", "20"} {
+		if code, body := call("PUT", "/templates/standard", strings.Replace(template, "%s", rate, 1)); code >= 300 {
+			t.Fatal(code, body)
+		}
+	}
+	if code, body := call("GET", "/templates/standard", ""); !strings.Contains(body, `"version":2`) {
+		t.Fatal(code, body)
+	}
+
+	// A number parameter renders as a number, and an older version still renders.
+	if code, body := call("POST", "/tenants/acme?template=standard&version=1", `{"host":"acme.test","rate":5}`); code != http.StatusCreated {
+		t.Fatal(code, body)
+	}
+	if code, body := call("GET", "/tenants/acme", ""); !strings.Contains(body, `"requestsPerSecond":5`) || !strings.Contains(body, `"template":{"name":"standard","version":1,`) {
+		t.Fatal(code, body)
+	}
+	if code, body := call("GET", "/templates/standard/versions/1", ""); code != http.StatusOK || !strings.Contains(body, `"default":10`) {
+		t.Fatal(code, body)
+	}
+
+	// Only the server sets the template of a tenant.
+	if code, body := call("PUT", "/tenants/acme", `{"name":"acme","serverEndpoints":{"hello":{"url":"http://acme.test/hello","connector":"http"}},"template":{"name":"other","version":7}}`); code >= 300 {
+		t.Fatal(code, body)
+	}
+	if _, body := call("GET", "/tenants/acme", ""); strings.Contains(body, `"template"`) {
+		t.Fatal(body)
+	}
+
+	// The versions of a template put again after it was deleted follow its last one,
+	// also once restored from the store.
+	if code, body := call("DELETE", "/templates/standard", ""); code >= 300 {
+		t.Fatal(code, body)
+	}
+	if code, _ := call("GET", "/templates/standard/versions", ""); code != http.StatusNotFound {
+		t.Fatal(code)
+	}
+	if err := w.DeleteTenant("acme"); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewWebApp()
+	if err := restored.SetServerConfigurationSlot(""); err != nil {
+		t.Fatal(err)
+	}
+	store, err = configstore.NewFileConfigStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.OpenConfigStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := restored.GetTemplate("standard"); found {
+		t.Fatal("the deleted template was restored")
+	}
+	restoredTemplate, err := restored.PutTemplate("standard", w.templates["standard"].Versions[0].TemplateConfig)
+	if err != nil || restoredTemplate.Version != 3 {
+		t.Fatal(restoredTemplate.Version, err)
+	}
+	if versions, _ := restored.TemplateVersions("standard"); len(versions) != 3 {
+		t.Fatal(versions)
+	}
+}
@@ -1,45 +1,214 @@
 package webapp
 
 import (
+	"context"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
+	"time"
 
 	"fmt"
 
 	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/tenantresolver"
 )
 
 type tenantConnectorHandler struct {
 	webApp        *WebApp
 	connectorName string
+	// tenantResolver the resolver of the connector, nil when it has none.
+	tenantResolver tenantresolver.Resolver
 }
 
 func (t tenantConnectorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	tenantId, result, found := t.webApp.multiTenancySupport.GetTenantIdAndEndpointName(t.connectorName, r)
+	if acmeManager := t.webApp.acmeManager(); acmeManager != nil && acmeManager.ServeHTTP01(w, r) {
+		return
+	}
+
+	// The route, the tenants, the handlers and the values of the request all come
+	// from the same commit.
+	snapshot := t.webApp.multiTenancySupport.Snapshot()
+	route, found := snapshot.GetTenantRoute(t.connectorName, r)
+	preflight := false
+	if requestMethod := r.Header.Get("Access-Control-Request-Method"); !found && r.Method == http.MethodOptions && requestMethod != "" {
+		// A CORS preflight is answered by the middleware of the route it asks for.
+		preflightRequest := r.Clone(r.Context())
+		preflightRequest.Method = requestMethod
+		route, found = snapshot.GetTenantRoute(t.connectorName, preflightRequest)
+		preflight = found
+	}
 	if !found {
+		t.webApp.tenantMetrics.unrouted.Inc(t.connectorName)
 		http.NotFound(w, r)
 		return
 	}
+	tenantID, resolved := t.resolveTenant(snapshot, route, preflight, w, r)
+	if !resolved {
+		t.webApp.tenantMetrics.unrouted.Inc(t.connectorName)
+		return
+	}
 
-	if serverEndpoint, ok := result.(multitenancy.TenantServerEndpoint); ok {
-		fmt.Println("serverEndpoint", serverEndpoint)
-		handler := t.webApp.serverEndpointsSlots[serverEndpoint.ServerEndpointName].handler
-		handler.ServeHTTP(t.webApp, tenantId, w, r)
+	t.webApp.tenantMetrics.instrument(t.connectorName, tenantID, route, w, r, func(w http.ResponseWriter, r *http.Request) {
+		t.serveTenantRoute(snapshot, tenantID, route, preflight, w, r)
+	})
+}
+
+// resolveTenant the tenant a request to route is for: the tenant of the route, unless
+// the resolver of its server endpoint, or else of the connector, finds another. The
+// resolver of an endpoint, which its tenant configures, only finds itself and the
+// tenants that are resolvable by it; the one of the connector, which only admins
+// configure, finds any. It answers the request itself when it finds no tenant it may
+// serve, except for a CORS preflight, which is left to the tenant of the route.
+func (t tenantConnectorHandler) resolveTenant(snapshot *multitenancy.Snapshot, route multitenancy.TenantRoute, preflight bool, w http.ResponseWriter, r *http.Request) (string, bool) {
+	serverEndpoint, isServerEndpoint := route.(multitenancy.TenantServerEndpoint)
+	if !isServerEndpoint {
+		return route.TenantID(), true
+	}
+	tenantResolver, ownResolver := serverEndpoint.TenantResolver, true
+	if tenantResolver == nil {
+		tenantResolver, ownResolver = t.tenantResolver, false
+	}
+	if tenantResolver == nil {
+		return route.TenantID(), true
+	}
+
+	tenantID, found := tenantResolver.Resolve(r)
+	if !found {
+		if preflight {
+			return route.TenantID(), true
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return "", false
+	}
+	tenant, exists := snapshot.Tenants()[tenantID]
+	if !exists || ownResolver && tenantID != route.TenantID() && !tenant.IsResolvableBy(route.TenantID()) {
+		http.NotFound(w, r)
+		return "", false
+	}
+	return tenantID, true
+}
+
+// serveTenantRoute serves a request to route for tenantID, whose state, limits and
+// middleware apply.
+func (t tenantConnectorHandler) serveTenantRoute(snapshot *multitenancy.Snapshot, tenantID string, route multitenancy.TenantRoute, preflight bool, w http.ResponseWriter, r *http.Request) {
+	state, release, admitted := t.webApp.multiTenancySupport.AdmitRequest(tenantID)
+	if !admitted {
+		writeTenantStateResponse(w, state)
 		return
 	}
+	defer release()
 
-	if proxyEndpoint, ok := result.(multitenancy.TenantReverseProxyEndpoint); ok {
-		fmt.Println("proxyEndpoint", proxyEndpoint)
-		http.StripPrefix(proxyEndpoint.StripPrefix, httputil.NewSingleHostReverseProxy(proxyEndpoint.TargetUrl)).ServeHTTP(w, r)
+	if limits := snapshot.Tenants()[tenantID].Limits; limits != nil {
+		// The bulkheads go first, so a request they reject does not spend tokens.
+		releaseBulkheads, rejectedBy, acquired := t.webApp.tenantLimiters.acquire(r.Context(), tenantID, limits, multitenancy.EndpointKey(route))
+		if !acquired {
+			writeBulkheadRejection(w, rejectedBy)
+			return
+		}
+		defer releaseBulkheads()
+		status, allowed := t.webApp.tenantLimiters.take(tenantID, limits, multitenancy.EndpointKey(route), t.webApp.currentSettings().clientIp(r), time.Now())
+		writeRateLimitHeaders(w, status)
+		if !allowed {
+			return
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), tenantRouteRequestContextKey{}, tenantRouteRequest{
+		snapshot:  snapshot,
+		route:     route,
+		tenantID:  tenantID,
+		preflight: preflight,
+	})
+	snapshot.RouteHandler(tenantID, route).ServeHTTP(w, r.WithContext(ctx))
+}
+
+type tenantRouteRequestContextKey struct{}
+
+// tenantRouteRequest what serveTenantEndpoint needs of a request that the handler of
+// its route, composed once for every request, does not know.
+type tenantRouteRequest struct {
+	// snapshot the commit the route was found in.
+	snapshot *multitenancy.Snapshot
+	route    multitenancy.TenantRoute
+	// tenantID the tenant the request is for, which a tenant resolver may have found.
+	tenantID  string
+	preflight bool
+}
+
+// serveTenantEndpoint the EndpointHandler of the tenant routes, behind their
+// middleware.
+func (webApp *WebApp) serveTenantEndpoint(w http.ResponseWriter, r *http.Request) {
+	request, found := r.Context().Value(tenantRouteRequestContextKey{}).(tenantRouteRequest)
+	if !found || request.preflight {
+		http.NotFound(w, r)
 		return
 	}
+	request.route.Accept(tenantRouteServer{
+		webApp:   webApp,
+		snapshot: request.snapshot,
+		tenantID: request.tenantID,
+		w:        w,
+		r:        r,
+	})
+}
+
+// tenantRouteServer serves a single request for whatever endpoint kind it matched.
+type tenantRouteServer struct {
+	webApp *WebApp
+	// snapshot the commit the route was found in.
+	snapshot *multitenancy.Snapshot
+	// tenantID the tenant the request is for.
+	tenantID string
+	w        http.ResponseWriter
+	r        *http.Request
+}
+
+func (s tenantRouteServer) VisitServerEndpoint(serverEndpoint multitenancy.TenantServerEndpoint) {
+	fmt.Println("serverEndpoint", serverEndpoint)
+	handler := s.webApp.serverEndpointsSlots[serverEndpoint.ServerEndpointName].handler
+	r := s.r
+	if values, found := s.snapshot.TenantValues(s.tenantID); found {
+		r = r.WithContext(multitenancy.WithTenantValues(r.Context(), values))
+	}
+	handler.ServeHTTP(s.webApp, s.tenantID, s.w, r)
+}
+
+func (s tenantRouteServer) VisitReverseProxyEndpoint(proxyEndpoint multitenancy.TenantReverseProxyEndpoint) {
+	fmt.Println("proxyEndpoint", proxyEndpoint)
+	http.StripPrefix(proxyEndpoint.StripPrefix, httputil.NewSingleHostReverseProxy(proxyEndpoint.TargetUrl)).ServeHTTP(s.w, s.r)
+}
+
+func (s tenantRouteServer) VisitFileServerEndpoint(fileServerEndpoint multitenancy.TenantFileServerEndpoint) {
+	fmt.Println("fileServerEndpoint", fileServerEndpoint)
+	http.StripPrefix(fileServerEndpoint.StripPrefix, http.FileServer(http.Dir(fileServerEndpoint.RootFs))).ServeHTTP(s.w, s.r)
+}
 
-	if fileServerEndpoint, ok := result.(multitenancy.TenantFileServerEndpoint); ok {
-		fmt.Println("fileServerEndpoint", fileServerEndpoint)
-		http.StripPrefix(fileServerEndpoint.StripPrefix, http.FileServer(http.Dir(fileServerEndpoint.RootFs))).ServeHTTP(w, r)
+func (s tenantRouteServer) VisitRedirectEndpoint(redirectEndpoint multitenancy.TenantRedirectEndpoint) {
+	http.Redirect(s.w, s.r, redirectEndpoint.Location(s.r), redirectEndpoint.Status)
+}
+
+// writeTenantStateResponse answers a request for a tenant that is not active.
+func writeTenantStateResponse(w http.ResponseWriter, state multitenancy.TenantStateConfig) {
+	statusCode := http.StatusServiceUnavailable
+	switch state.Status {
+	case multitenancy.TenantSuspended:
+		statusCode = http.StatusForbidden
+	case multitenancy.TenantMaintenance:
+		if state.RetryAfter != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(*state.RetryAfter))
+		}
+	}
+
+	if state.Body == nil || state.Status == multitenancy.TenantDraining {
+		http.Error(w, http.StatusText(statusCode), statusCode)
 		return
 	}
 
-	http.NotFound(w, r)
-	return
+	contentType := "text/html; charset=utf-8"
+	if state.ContentType != nil {
+		contentType = *state.ContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	fmt.Fprint(w, *state.Body)
 }
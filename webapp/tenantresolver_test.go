@@ -0,0 +1,107 @@
+package webapp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/tenantresolver"
+)
+
+// connectorAddress the address a connector of w is bound to.
+func connectorAddress(w *WebApp, connectorName string) string {
+	return w.server.RunningEndpointsConnectors[connectorName].Listener.Addr().String()
+}
+
+func TestTenantResolvers(t *testing.T) {
+	w := NewWebApp()
+	w.AddTenantServerEndpointSlot("who", "GET", func(webApp *WebApp, tenantID string, rw http.ResponseWriter, r *http.Request) {
+		values, _ := TenantValues(r)
+		flag, _ := multitenancy.Variable[string](values, "flag")
+		io.WriteString(rw, tenantID+":"+flag)
+	})
+	kind := "header"
+	shared := *server.NewConnectorConfig("127.0.0.1", freePort(t), false)
+	shared.TenantResolver = &tenantresolver.Config{Kind: &kind}
+	if err := w.CreateServerConnector("shared", shared); err != nil {
+		t.Fatal(err)
+	}
+	defer w.DeleteServerConnector("shared")
+	if err := w.CreateServerConnector("plain", *server.NewConnectorConfig("127.0.0.1", freePort(t), false)); err != nil {
+		t.Fatal(err)
+	}
+	defer w.DeleteServerConnector("plain")
+	for _, configJson := range []string{
+		`{"name":"platform","serverEndpoints":{"who":{"url":"http://api.test/who","connector":"shared"}}}`,
+		`{"name":"acme","serverEndpoints":{},"variables":{"flag":"A"},"resolvableBy":["gateway"],
+			"limits":{"tenant":{"requestsPerSecond":0.001,"burst":1}},
+			"middleware":[{"name":"headers","params":{"set":{"X-Tenant":"acme"}}}]}`,
+		`{"name":"other","serverEndpoints":{},"variables":{"flag":"O"}}`,
+		`{"name":"gateway","serverEndpoints":{"who":{"url":"http://api.test/t/*","connector":"plain","tenantResolver":{"kind":"pathPrefix","params":{"prefix":"/t/"}}}}}`,
+	} {
+		var config multitenancy.TenantConfig
+		if err := json.Unmarshal([]byte(configJson), &config); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.CreateTenant(*config.Name, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sharedAddress, plainAddress := connectorAddress(w, "shared"), connectorAddress(w, "plain")
+	get := func(address, path, tenantID string) *http.Response {
+		t.Helper()
+		request, _ := http.NewRequest("GET", "http://"+address+path, nil)
+		request.Host = "api.test"
+		if tenantID != "" {
+			request.Header.Set("X-Tenant-ID", tenantID)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	expect := func(response *http.Response, statusCode int, body string) {
+		t.Helper()
+		defer response.Body.Close()
+		read, _ := io.ReadAll(response.Body)
+		if response.StatusCode != statusCode || body != "" && string(read) != body {
+			t.Fatalf("%d %q", response.StatusCode, read)
+		}
+	}
+
+	// The resolver of the connector is the admin's: it serves any tenant.
+	expect(get(sharedAddress, "/who", "other"), http.StatusOK, "other:O")
+	expect(get(sharedAddress, "/who", ""), http.StatusBadRequest, "")
+	expect(get(sharedAddress, "/who", "ghost"), http.StatusNotFound, "")
+
+	// The resolver of a tenant serves itself and the tenants resolvable by it only.
+	expect(get(plainAddress, "/t/gateway/x", ""), http.StatusOK, "gateway:")
+	expect(get(plainAddress, "/t/other/x", ""), http.StatusNotFound, "")
+
+	// The middleware and the limits are the ones of the tenant resolved.
+	response := get(plainAddress, "/t/acme/x", "")
+	if response.Header.Get("X-Tenant") != "acme" {
+		t.Fatalf("middleware of acme not applied: %v", response.Header)
+	}
+	expect(response, http.StatusOK, "acme:A")
+	expect(get(plainAddress, "/t/acme/x", ""), http.StatusTooManyRequests, "")
+	expect(get(plainAddress, "/t/gateway/x", ""), http.StatusOK, "gateway:")
+
+	// The keys of the resolvers are read from the secret sources only.
+	t.Setenv("RESOLVER_KEY", "key")
+	var config multitenancy.TenantConfig
+	json.Unmarshal([]byte(`{"name":"jwt","serverEndpoints":{"who":{"url":"http://jwt.test/who","connector":"plain","tenantResolver":{"kind":"jwtClaim","params":{"keyEnv":"RESOLVER_KEY"}}}}}`), &config)
+	if err := w.CreateTenant("jwt", config); err == nil {
+		t.Fatal("a resolver read a key outside of the secret sources")
+	}
+	if err := w.ApplySettings(SettingsConfig{SecretEnvPrefixes: []string{"RESOLVER_"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.CreateTenant("jwt", config); err != nil {
+		t.Fatal(err)
+	}
+}
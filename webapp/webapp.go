@@ -1,14 +1,22 @@
 package webapp
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/riotemergence/godynamicweb/audit"
+	"github.com/riotemergence/godynamicweb/configstore"
 	"github.com/riotemergence/godynamicweb/multitenancy"
+	"github.com/riotemergence/godynamicweb/rbac"
 	"github.com/riotemergence/godynamicweb/server"
+	"github.com/riotemergence/godynamicweb/validation"
+	"github.com/riotemergence/godynamicweb/x509"
 )
 
 const TRACE = "github.com/riotemergence/godynamicweb"
@@ -32,6 +40,12 @@ func (f MultiTenancyHandlerFunc) ServeHTTP(webApp *WebApp, tenantID string, w ht
 	f(webApp, tenantID, w, r)
 }
 
+// TenantValues the variables and secrets of the tenant a MultiTenancyHandler serves a
+// request for. Variables are read with multitenancy.Variable.
+func TenantValues(r *http.Request) (*multitenancy.TenantValues, bool) {
+	return multitenancy.TenantValuesFromContext(r.Context())
+}
+
 type serverEndpointSlot struct {
 	method  string
 	handler MultiTenancyHandler
@@ -56,22 +70,54 @@ type WebApp struct {
 	httpMethodByServerEndpointSlots map[string]string
 	clientEndpointsSlots            clientEndpointsSlots
 	server                          *server.Server
-	//	x509Certificates                []tls.Certificate
-	x509CertificateBySubjectName map[string]tls.Certificate
-	multiTenancySupport          *multitenancy.MultiTenancySupport
+	// x509Certificates the *x509.CertificateIndex of the global certificates, replaced
+	// as a whole under x509CertificatesMutex.
+	x509Certificates      atomic.Value
+	x509CertificatesMutex sync.Mutex
+	// acme the *acme.Manager, once EnableAcme was called.
+	acme atomic.Value
+	// acmeMutex guards acmeCancel, that stops the renewals of the manager.
+	acmeMutex  sync.Mutex
+	acmeCancel context.CancelFunc
+	// auditLog the *audit.Log of the management operations.
+	auditLog atomic.Value
+	// settings the *settings applied with ApplySettings.
+	settings atomic.Value
+	// accessPolicy the *rbac.Policy of the management connectors, nil until
+	// SetManagementAccessControl is called or the settings have an access control.
+	accessPolicy        atomic.Value
+	configStore         configstore.ConfigStore
+	configDir           configDirState
+	multiTenancySupport *multitenancy.MultiTenancySupport
+	tenantLimiters      *tenantLimiters
+	tenantMetrics       *tenantMetrics
+	templatesMutex      sync.RWMutex
+	templates           map[string]multitenancy.TenantTemplateHistory
+	importFilesDir      string
 }
 
 func NewWebApp() *WebApp {
-	return &WebApp{
+	webApp := &WebApp{
 		status:                          StatusUninitialized,
 		serverEndpointsSlots:            make(serverEndpointsSlots),
 		httpMethodByServerEndpointSlots: make(map[string]string),
 		clientEndpointsSlots:            make(clientEndpointsSlots),
 		server:                          server.NewServer(),
-		//		x509Certificates:                make([]tls.Certificate, 0),
-		x509CertificateBySubjectName: make(map[string]tls.Certificate),
-		multiTenancySupport:          multitenancy.NewMultiTenancySupport(),
+		multiTenancySupport:             multitenancy.NewMultiTenancySupport(),
+		tenantLimiters:                  newTenantLimiters(),
+		tenantMetrics:                   newTenantMetrics(DefaultMaxTenantMetricLabels),
+		templates:                       make(map[string]multitenancy.TenantTemplateHistory),
 	}
+	webApp.tenantLimiters.onBulkheadChange = webApp.tenantMetrics.bulkheadChange
+	webApp.multiTenancySupport.AfterCommit = webApp.tenantsCommitted
+	webApp.server.TLSALPN01Certificate = webApp.tlsAlpn01Certificate
+	webApp.multiTenancySupport.CertificateConflicts = webApp.globalCertificateConflicts
+	webApp.multiTenancySupport.EndpointHandler = http.HandlerFunc(webApp.serveTenantEndpoint)
+	webApp.accessPolicy.Store((*rbac.Policy)(nil))
+	webApp.auditLog.Store(audit.NewLog(audit.NewMemorySink(DefaultAuditMemoryEntries)))
+	webApp.x509Certificates.Store(x509.NewCertificateIndex())
+	webApp.settings.Store(&settings{})
+	return webApp
 }
 
 //TODO Swagger
@@ -83,7 +129,7 @@ func (webApp *WebApp) SetServerConfigurationSlot(swagger string) error {
 	return nil
 }
 
-//FIXME Swagger
+// FIXME Swagger
 func (webApp *WebApp) AddTenantServerEndpointSlot(serverEndpointName string, httpMethod string, handler MultiTenancyHandlerFunc) error {
 	if webApp.status != StatusUninitialized && webApp.status != StatusSlotReservation {
 		return fmt.Errorf(TRACE + " WebApp AddServerEndpoint: statusMustBeStatusUninitializedOrStatusSlotReservation")
@@ -109,7 +155,7 @@ func (webApp *WebApp) AddTenantServerEndpointSlot(serverEndpointName string, htt
 	return nil
 }
 
-//FIXME Swagger
+// FIXME Swagger
 func (webApp *WebApp) AddTenantClientEndpointSlot(clientEndpointName string, inputParameters []string, outputParameters []string) error {
 	if webApp.status != StatusUninitialized && webApp.status != StatusSlotReservation {
 		return fmt.Errorf(TRACE + " WebApp AddClientEndpointSlot: statusMustBeStatusUninitializedOrStatusSlotReservation")
@@ -150,12 +196,26 @@ func (webApp *WebApp) CreateServerConnector(connectorName string, connectorConfi
 		webApp:        webApp,
 		connectorName: connectorName,
 	}
-	return webApp.server.AddConnector(
+	if connectorConfig.TenantResolver != nil {
+		tenantResolver, err := connectorConfig.TenantResolver.Build(webApp.multiTenancySupport.SecretSources().ReadKey)
+		if err != nil {
+			return fmt.Errorf(TRACE+" WebApp AddServerConnector connectorConfig tenantResolver: %w", err)
+		}
+		connectorHandler.tenantResolver = tenantResolver
+	}
+	if err := webApp.server.AddConnector(
 		connectorName,
 		connectorConfig,
 		connectorHandler,
-		getCertificate,
-	)
+		webApp.getCertificate,
+	); err != nil {
+		return err
+	}
+	if err := webApp.storeConnector(connectorName, connectorConfig); err != nil {
+		webApp.server.RemoveConnector(connectorName)
+		return fmt.Errorf(TRACE+" WebApp AddServerConnector: %s", err)
+	}
+	return nil
 }
 
 func (webApp *WebApp) DeleteServerConnector(connectorName string) error {
@@ -164,7 +224,54 @@ func (webApp *WebApp) DeleteServerConnector(connectorName string) error {
 	}
 	webApp.status = StatusRunning
 
-	return webApp.server.RemoveConnector(connectorName)
+	if _, found := webApp.server.RunningEndpointsConnectors[connectorName]; !found {
+		return fmt.Errorf(TRACE+" WebApp RemoveServerConnector connectorName: mustExist \"%s\"", connectorName)
+	}
+	// The delete is stored first: a connector that is still running but no longer
+	// stored is only missing until the next restart, the opposite would come back.
+	if err := webApp.deleteStoredConnector(connectorName); err != nil {
+		return fmt.Errorf(TRACE+" WebApp RemoveServerConnector: %s", err)
+	}
+	if err := webApp.server.RemoveConnector(connectorName); err != nil {
+		if storeErr := webApp.storeConnector(connectorName, (*webApp.server.Config.Connectors)[connectorName]); storeErr != nil {
+			return fmt.Errorf(TRACE+" WebApp RemoveServerConnector: %s, storing it back: %s", err, storeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReplaceServerConnector gives a running connector another config. Its listener is
+// closed before the new one is opened, as both may bind the same address; if the new
+// one can not be opened, the connector is created again with its previous config.
+func (webApp *WebApp) ReplaceServerConnector(connectorName string, connectorConfig server.ConnectorConfig) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp ReplaceServerConnector: statusMustBeStatusSlotReservationOrStatusRunning")
+	}
+	previous, found := (*webApp.server.Config.Connectors)[connectorName]
+	if !found {
+		return fmt.Errorf(TRACE+" WebApp ReplaceServerConnector connectorName: mustExist \"%s\"", connectorName)
+	}
+	// What can be checked without the address is checked before the connector stops.
+	if err := connectorConfig.Validate(); err != nil {
+		return fmt.Errorf(TRACE+" WebApp ReplaceServerConnector connectorConfig: %w", err)
+	}
+	if connectorConfig.TenantResolver != nil {
+		if _, err := connectorConfig.TenantResolver.Build(webApp.multiTenancySupport.SecretSources().ReadKey); err != nil {
+			return fmt.Errorf(TRACE+" WebApp ReplaceServerConnector connectorConfig tenantResolver: %w", err)
+		}
+	}
+
+	if err := webApp.DeleteServerConnector(connectorName); err != nil {
+		return err
+	}
+	if err := webApp.CreateServerConnector(connectorName, connectorConfig); err != nil {
+		if restoreErr := webApp.CreateServerConnector(connectorName, previous); restoreErr != nil {
+			return fmt.Errorf(TRACE+" WebApp ReplaceServerConnector: %s, restoring the previous config: %s", err, restoreErr)
+		}
+		return fmt.Errorf(TRACE+" WebApp ReplaceServerConnector: %s", err)
+	}
+	return nil
 }
 
 func (webApp *WebApp) CreateServerManagementConnector(connectorName string, connectorConfig server.ConnectorConfig) error {
@@ -174,26 +281,59 @@ func (webApp *WebApp) CreateServerManagementConnector(connectorName string, conn
 	webApp.status = StatusRunning
 
 	mux := mux.NewRouter()
-	if err := webApp.server.AddConnector(connectorName, connectorConfig, mux, getCertificate); err != nil {
+	if err := webApp.server.AddConnector(connectorName, connectorConfig, mux, webApp.getCertificate); err != nil {
 		return err
 	}
-	mux.HandleFunc("/", webApp.retrieveServerHandler).Methods(http.MethodGet)
-	mux.HandleFunc("/", webApp.deleteServerHandler).Methods(http.MethodDelete)
-	mux.HandleFunc("/connectors", webApp.listServerConnectorsHandler).Methods(http.MethodGet)
-	mux.HandleFunc("/connectors/{connectorName}", webApp.createOrReplaceServerConnectorHandler).Methods(http.MethodPut)
-	mux.HandleFunc("/connectors/{connectorName}", webApp.retrieveServerConnectorHandler).Methods(http.MethodGet)
-	mux.HandleFunc("/connectors/{connectorName}", webApp.deleteServerConnectorHandler).Methods(http.MethodDelete)
-	mux.HandleFunc("/tenants", webApp.listTenantsHandler).Methods(http.MethodGet)
-	mux.HandleFunc("/tenants/{tenantId}", webApp.createOrReplaceTenantHandler).Methods(http.MethodPut)
-	mux.HandleFunc("/tenants/{tenantId}", webApp.retrieveTenantHandler).Methods(http.MethodGet)
-	mux.HandleFunc("/tenants/{tenantId}", webApp.deleteTenantHandler).Methods(http.MethodDelete)
-	mux.HandleFunc("/x509/{x509Cn}", webApp.createOrReplaceTenantHandler).Methods(http.MethodPut)
-	mux.HandleFunc("/x509/{x509Cn}", webApp.retrieveTenantHandler).Methods(http.MethodGet)
-	mux.HandleFunc("/x509/{x509Cn}", webApp.deleteTenantHandler).Methods(http.MethodDelete)
+	mux.Use(webApp.authenticationMiddleware, webApp.auditMiddleware)
+	mux.HandleFunc("/", authorize(readPermission, webApp.retrieveServerHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/", authorize(adminPermission, webApp.deleteServerHandler)).Methods(http.MethodDelete)
+	mux.HandleFunc("/connectors", authorize(readPermission, webApp.listServerConnectorsHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/connectors/{connectorName}", authorize(adminPermission, webApp.createOrReplaceServerConnectorHandler)).Methods(http.MethodPut)
+	mux.HandleFunc("/connectors/{connectorName}", authorize(readPermission, webApp.retrieveServerConnectorHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/connectors/{connectorName}", authorize(adminPermission, webApp.deleteServerConnectorHandler)).Methods(http.MethodDelete)
+	mux.HandleFunc("/tenants", authorize(anyPrincipalPermission, webApp.listTenantsHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/tenants/import", authorize(anyPrincipalPermission, webApp.importTenantHandler)).Methods(http.MethodPost)
+	mux.HandleFunc("/tenants/{tenantId}", authorize(changeTenantPermission, webApp.createOrReplaceTenantHandler)).Methods(http.MethodPut)
+	mux.HandleFunc("/tenants/{tenantId}", authorize(changeTenantPermission, webApp.createOrReplaceTenantFromTemplateHandler)).Methods(http.MethodPost).Queries("template", "{templateName}")
+	mux.HandleFunc("/tenants/{tenantId}", authorize(readTenantPermission, webApp.retrieveTenantHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/tenants/{tenantId}", authorize(changeTenantPermission, webApp.deleteTenantHandler)).Methods(http.MethodDelete)
+	mux.HandleFunc("/tenants/{tenantId}/export", authorize(changeTenantPermission, webApp.exportTenantHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/tenants/{tenantId}/validate", authorize(changeTenantPermission, webApp.validateTenantHandler)).Methods(http.MethodPost)
+	mux.HandleFunc("/tenants/{tenantId}/state", authorize(readTenantPermission, webApp.retrieveTenantStateHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/tenants/{tenantId}/state", authorize(changeTenantPermission, webApp.updateTenantStateHandler)).Methods(http.MethodPut)
+	mux.HandleFunc("/tenants/{tenantId}/usage", authorize(readTenantPermission, webApp.retrieveTenantUsageHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/tenants/{tenantId}/values", authorize(readTenantPermission, webApp.retrieveTenantValuesHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/templates", authorize(anyPrincipalPermission, webApp.listTemplatesHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/templates/{templateName}", authorize(operatePermission, webApp.createOrReplaceTemplateHandler)).Methods(http.MethodPut)
+	mux.HandleFunc("/templates/{templateName}", authorize(anyPrincipalPermission, webApp.retrieveTemplateHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/templates/{templateName}", authorize(operatePermission, webApp.deleteTemplateHandler)).Methods(http.MethodDelete)
+	mux.HandleFunc("/templates/{templateName}/versions", authorize(anyPrincipalPermission, webApp.listTemplateVersionsHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/templates/{templateName}/versions/{version}", authorize(anyPrincipalPermission, webApp.retrieveTemplateVersionHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/templates/{templateName}/parameters", authorize(anyPrincipalPermission, webApp.retrieveTemplateParametersHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/metrics", authorize(readPermission, webApp.metricsHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/generations", authorize(readPermission, webApp.listGenerationsHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/generations/{from}/diff/{to}", authorize(readPermission, webApp.diffGenerationsHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/generations/{generation}/rollback", authorize(operatePermission, webApp.rollbackGenerationHandler)).Methods(http.MethodPost)
+	mux.HandleFunc("/audit", authorize(anyPrincipalPermission, webApp.listAuditEntriesHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/acme", authorize(readPermission, webApp.retrieveAcmeHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/configdir", authorize(readPermission, webApp.retrieveConfigDirHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/schemas", authorize(anyPrincipalPermission, webApp.listSchemasHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/schemas/{schemaName}", authorize(anyPrincipalPermission, webApp.retrieveSchemaHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/x509", authorize(readPermission, webApp.listX509CertificatesHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/x509/{x509Cn}", authorize(adminPermission, webApp.createOrReplaceX509CertificateHandler)).Methods(http.MethodPut)
+	mux.HandleFunc("/x509/{x509Cn}", authorize(readPermission, webApp.retrieveX509CertificateHandler)).Methods(http.MethodGet)
+	mux.HandleFunc("/x509/{x509Cn}", authorize(adminPermission, webApp.deleteX509CertificateHandler)).Methods(http.MethodDelete)
 
 	return nil
 }
 
+// Stop stops the ACME renewals and every connector, which makes WaitForTheEnd return.
+func (webApp *WebApp) Stop() {
+	webApp.stopAcme()
+	webApp.stopConfigDir()
+	webApp.server.Stop()
+}
+
 func (webApp *WebApp) WaitForTheEnd() error {
 	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
 		return fmt.Errorf(TRACE + " WebApp WaitForTheEnd: statusMustBeStatusSlotReservationOrStatusRunning")
@@ -210,84 +350,304 @@ func (webApp *WebApp) AddX509Certificate(privateKeyBytes, certificateChainBytes
 		return fmt.Errorf(TRACE + " WebApp AddX509Certificate: statusMustBeStatusSlotReservationOrStatusRunning")
 	}
 
-	certificateChainAndPrivateKey, err := tls.X509KeyPair(certificateChainBytes, privateKeyBytes)
+	certificate, err := x509.ParseCertificate(privateKeyBytes, certificateChainBytes)
 	if err != nil {
-		return err
+		return fmt.Errorf(TRACE+" WebApp AddX509Certificate: %s", err)
 	}
+	return webApp.updateX509Certificates(func(certificates *x509.CertificateIndex) error {
+		return webApp.addX509Certificate(certificates, certificate)
+	})
+}
 
-	certificateBytes := certificateChainAndPrivateKey.Certificate[0]
-	x509Certificate, err := x509.ParseCertificate(certificateBytes)
-	if err != nil {
-		return err
+// ReplaceX509Certificate adds the certificate named commonName, replacing the one
+// that is already indexed by that name, if any. The replaced certificate remains the
+// default one if it was.
+func (webApp *WebApp) ReplaceX509Certificate(commonName string, config x509.X509Config) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp ReplaceX509Certificate: statusMustBeStatusSlotReservationOrStatusRunning")
 	}
 
-	commonName := x509Certificate.Subject.CommonName
-
-	if len(commonName) == 0 {
-		return fmt.Errorf(TRACE + " WebApp AddX509Certificate: certificateChainBytes CertificateSubjectCommonNameMustNotBeEmpty")
+	certificate, err := config.Load()
+	if err != nil {
+		return fmt.Errorf(TRACE+" WebApp ReplaceX509Certificate config: %s", err)
+	}
+	if !certificate.HasName(commonName) {
+		return fmt.Errorf(TRACE+" WebApp ReplaceX509Certificate config: CertificateSubjectNameMustMatch \"%s\"", commonName)
 	}
+	return webApp.updateX509Certificates(func(certificates *x509.CertificateIndex) error {
+		if replaced, found := certificates.GetExact(commonName); found {
+			defaultCertificate, _ := certificates.Default()
+			certificates.Remove(replaced)
+			if defaultCertificate == replaced {
+				certificates.SetDefault(certificate)
+			}
+		}
+		return webApp.addX509Certificate(certificates, certificate)
+	})
+}
 
-	if _, ok := webApp.x509CertificateBySubjectName[commonName]; ok {
-		return fmt.Errorf(TRACE + " WebApp AddX509Certificate: CertificateSubjectCommonNameMustBeUnique")
+func (webApp *WebApp) DeleteX509Certificate(commonName string) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp DeleteX509Certificate: statusMustBeStatusSlotReservationOrStatusRunning")
 	}
 
-	if _, ok := webApp.multiTenancySupport.GetCertificateChainAndPrivateKeyBySubjectName(commonName); ok {
-		return fmt.Errorf(TRACE + " WebApp AddX509Certificate: CertificateSubjectCommonNameMustBeUnique")
+	return webApp.updateX509Certificates(func(certificates *x509.CertificateIndex) error {
+		certificate, found := certificates.GetExact(commonName)
+		if !found {
+			return fmt.Errorf(TRACE+" WebApp DeleteX509Certificate commonName: mustExist \"%s\"", commonName)
+		}
+		certificates.Remove(certificate)
+		return nil
+	})
+}
+
+// SetDefaultX509Certificate selects the certificate served to clients whose server
+// name matches no certificate, or that send none.
+func (webApp *WebApp) SetDefaultX509Certificate(commonName string) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp SetDefaultX509Certificate: statusMustBeStatusSlotReservationOrStatusRunning")
 	}
 
-	for _, subjectAlternativeName := range x509Certificate.DNSNames {
-		if len(subjectAlternativeName) == 0 {
-			return fmt.Errorf(TRACE + " WebApp AddX509Certificate: certificateChainBytes CertificateSubjectAlternativeNameMustNotBeEmpty")
+	return webApp.updateX509Certificates(func(certificates *x509.CertificateIndex) error {
+		certificate, found := certificates.GetExact(commonName)
+		if !found {
+			return fmt.Errorf(TRACE+" WebApp SetDefaultX509Certificate commonName: mustExist \"%s\"", commonName)
 		}
-		if _, ok := webApp.x509CertificateBySubjectName[subjectAlternativeName]; ok {
-			return fmt.Errorf(TRACE + " WebApp AddX509Certificate: CertificateSubjectAlternativeNameMustBeUnique")
+		certificates.SetDefault(certificate)
+		return nil
+	})
+}
+
+// X509Certificates the global certificates, not the ones of the tenants.
+func (webApp *WebApp) X509Certificates() *x509.CertificateIndex {
+	return webApp.x509Certificates.Load().(*x509.CertificateIndex)
+}
+
+// updateX509Certificates applies updateFn to a copy of the global certificates and
+// publishes it, unless updateFn fails. The tenants are locked meanwhile, so that a
+// tenant can not take a name that updateFn checked was free.
+func (webApp *WebApp) updateX509Certificates(updateFn func(*x509.CertificateIndex) error) error {
+	webApp.x509CertificatesMutex.Lock()
+	defer webApp.x509CertificatesMutex.Unlock()
+
+	err := webApp.multiTenancySupport.Locked(func() error {
+		certificates := webApp.X509Certificates().Clone()
+		if err := updateFn(certificates); err != nil {
+			return err
 		}
-		if _, ok := webApp.multiTenancySupport.GetCertificateChainAndPrivateKeyBySubjectName(subjectAlternativeName); ok {
-			return fmt.Errorf(TRACE + " WebApp AddX509Certificate: CertificateSubjectCommonNameMustBeUnique")
+		if err := webApp.storeX509Certificates(webApp.X509Certificates(), certificates); err != nil {
+			return fmt.Errorf(TRACE+" WebApp X509Certificates: %s", err)
 		}
+		webApp.x509Certificates.Store(certificates)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	webApp.refreshAcme()
+	return nil
+}
+
+// globalCertificateConflicts the names of a tenant certificate that a global
+// certificate already has, checked again when the tenants are committed.
+func (webApp *WebApp) globalCertificateConflicts(certificate *x509.Certificate) []string {
+	return webApp.X509Certificates().Conflicts(certificate)
+}
 
+func (webApp *WebApp) addX509Certificate(certificates *x509.CertificateIndex, certificate *x509.Certificate) error {
+	if conflicts := webApp.multiTenancySupport.CertificateIndex().Conflicts(certificate); len(conflicts) > 0 {
+		return fmt.Errorf(TRACE+" WebApp AddX509Certificate: CertificateSubjectNameMustNotBeUsedByTenant %q", conflicts)
+	}
+	if err := certificates.Add(certificate); err != nil {
+		return fmt.Errorf(TRACE+" WebApp AddX509Certificate: %s", err)
 	}
+	return nil
+}
+
+// CreateTenant adds a tenant. The template of config is dropped: the server records
+// it only for the tenants it renders from a template.
+func (webApp *WebApp) CreateTenant(tenantID string, config multitenancy.TenantConfig) error {
+	config.Template = nil
+	return webApp.createTenant(tenantID, config)
+}
 
-	//Copy all subject names.
-	newX509CertificateBySubjectName := make(map[string]tls.Certificate)
-	for k, v := range webApp.x509CertificateBySubjectName {
-		newX509CertificateBySubjectName[k] = v
+// createTenant CreateTenant, keeping the template of config, which only the server
+// sets when it renders one.
+func (webApp *WebApp) createTenant(tenantID string, config multitenancy.TenantConfig) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp AddTenant: statusMustBeStatusSlotReservationOrStatusRunning")
 	}
+	webApp.status = StatusRunning
 
-	newX509CertificateBySubjectName[x509Certificate.Subject.CommonName] = certificateChainAndPrivateKey
-	for _, subjectAlternativeName := range x509Certificate.DNSNames {
-		newX509CertificateBySubjectName[subjectAlternativeName] = certificateChainAndPrivateKey
+	if err := webApp.validateTenantConfig(config); err != nil {
+		return err
 	}
 
+	if err := webApp.multiTenancySupport.AddTenant(tenantID, config, webApp.httpMethodByServerEndpointSlots); err != nil {
+		return err
+	}
+	webApp.refreshAcme()
 	return nil
 }
 
-func (webApp *WebApp) CreateTenant(tenantID string, config multitenancy.TenantConfig) error {
+// UpdateTenant replaces the config of an existing tenant without a gap in its routes,
+// and returns the routes that were added and removed. Like CreateTenant, it drops the
+// template of config.
+func (webApp *WebApp) UpdateTenant(tenantID string, config multitenancy.TenantConfig) (multitenancy.RoutesDiff, error) {
+	config.Template = nil
+	return webApp.updateTenant(tenantID, config)
+}
+
+// updateTenant UpdateTenant, keeping the template of config.
+func (webApp *WebApp) updateTenant(tenantID string, config multitenancy.TenantConfig) (multitenancy.RoutesDiff, error) {
 	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
-		return fmt.Errorf(TRACE + " WebApp AddTenant: statusMustBeStatusSlotReservationOrStatusRunning")
+		return multitenancy.RoutesDiff{}, fmt.Errorf(TRACE + " WebApp UpdateTenant: statusMustBeStatusSlotReservationOrStatusRunning")
+	}
+	webApp.status = StatusRunning
+
+	if err := webApp.validateTenantConfig(config); err != nil {
+		return multitenancy.RoutesDiff{}, err
+	}
+
+	diff, err := webApp.multiTenancySupport.ReplaceTenant(tenantID, config, webApp.httpMethodByServerEndpointSlots)
+	if err != nil {
+		return diff, err
+	}
+	webApp.refreshAcme()
+	return diff, nil
+}
+
+// ReplaceTenants creates or replaces all the given tenants at once. If any of them
+// fails, none of them is changed.
+func (webApp *WebApp) ReplaceTenants(configs multitenancy.TenantsConfig) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp ReplaceTenants: statusMustBeStatusSlotReservationOrStatusRunning")
 	}
 	webApp.status = StatusRunning
 
-	if err := config.Validate(); err != nil {
-		return fmt.Errorf(TRACE+" WebApp CreateTenant config: %s", err)
+	for _, config := range configs {
+		if err := webApp.validateTenantConfig(config); err != nil {
+			return err
+		}
+	}
+
+	tenantIDs := make([]string, 0, len(configs))
+	for tenantID := range configs {
+		tenantIDs = append(tenantIDs, tenantID)
+	}
+	tx := webApp.multiTenancySupport.Begin(fmt.Sprintf("replace tenants %q", tenantIDs))
+	defer tx.Rollback()
+	for tenantID, config := range configs {
+		if _, found := webApp.multiTenancySupport.Tenants()[tenantID]; found {
+			if err := tx.ReplaceTenant(tenantID, config, webApp.httpMethodByServerEndpointSlots); err != nil {
+				return err
+			}
+		} else if err := tx.AddTenant(tenantID, config, webApp.httpMethodByServerEndpointSlots); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	webApp.refreshAcme()
+	return nil
+}
+
+// ValidateTenant checks a tenant config and reports every route that would conflict
+// with the live catalog if it was created, or replaced when tenantID already exists.
+func (webApp *WebApp) ValidateTenant(tenantID string, config multitenancy.TenantConfig) ([]multitenancy.RouteConflict, error) {
+	if err := webApp.validateTenantConfig(config); err != nil {
+		return nil, err
+	}
+	return webApp.multiTenancySupport.AnalyzeTenant(tenantID, config, webApp.httpMethodByServerEndpointSlots)
+}
+
+// sharedServerEndpoints the server endpoints some tenant serves for every tenant, with
+// a tenant resolver of its own or of its connector. A tenant does not need to configure
+// them.
+func (webApp *WebApp) sharedServerEndpoints() map[string]bool {
+	shared := make(map[string]bool)
+	for _, tenantConfig := range webApp.multiTenancySupport.Tenants() {
+		if tenantConfig.ServerEndpoints == nil {
+			continue
+		}
+		for serverEndpointName, serverEndpoint := range *tenantConfig.ServerEndpoints {
+			if serverEndpoint.TenantResolver != nil {
+				shared[serverEndpointName] = true
+				continue
+			}
+			if serverEndpoint.Connector == nil {
+				continue
+			}
+			if connector, found := webApp.server.RunningEndpointsConnectors[*serverEndpoint.Connector]; found && connector.Config.TenantResolver != nil {
+				shared[serverEndpointName] = true
+			}
+		}
+	}
+	return shared
+}
+
+// validateTenantConfig reports every invalid value of config, including the server
+// endpoints that do not match the registered slots and the certificates that conflict
+// with the ones of the WebApp.
+func (webApp *WebApp) validateTenantConfig(config multitenancy.TenantConfig) error {
+	v := validation.NewValidator()
+	v.Check(config)
+	if config.ServerEndpoints == nil {
+		return fmt.Errorf(TRACE+" WebApp AddTenant config: %w", v.Err())
 	}
 
-	for serverEndpointName, serverEndpoint := range *config.ServerEndpoints {
+	serverEndpointsValidator := v.Field("serverEndpoints")
+	for _, serverEndpointName := range validation.SortedKeys(*config.ServerEndpoints) {
+		serverEndpoint := (*config.ServerEndpoints)[serverEndpointName]
 		if _, found := webApp.serverEndpointsSlots[serverEndpointName]; !found {
-			return fmt.Errorf(TRACE+" WebApp AddTenant config ServerEndpoints \"%s\": mustMatchRegisteredServerEndpointSlot", serverEndpointName)
+			serverEndpointsValidator.Field(serverEndpointName).Fail("mustMatchRegisteredServerEndpointSlot")
+			continue
+		}
+		if serverEndpoint.Connector == nil {
+			continue
 		}
 		if _, found := webApp.server.RunningEndpointsConnectors[*serverEndpoint.Connector]; !found {
-			return fmt.Errorf(TRACE+" WebApp AddTenant config ServerEndpoints \"%s\" Connector: mustExist", *serverEndpoint.Connector)
+			serverEndpointsValidator.Field(serverEndpointName).Field("connector").Failf("mustExist", "%q", *serverEndpoint.Connector)
 		}
 	}
 
-	for serverEndpointName := range webApp.serverEndpointsSlots {
-		if _, found := (*config.ServerEndpoints)[serverEndpointName]; !found {
-			return fmt.Errorf(TRACE+" WebApp AddTenant config ServerEndpoints: mustConfigureServerEndpoint \"%s\"", serverEndpointName)
+	sharedServerEndpoints := webApp.sharedServerEndpoints()
+	for _, serverEndpointName := range validation.SortedKeys(webApp.serverEndpointsSlots) {
+		if _, found := (*config.ServerEndpoints)[serverEndpointName]; !found && !sharedServerEndpoints[serverEndpointName] {
+			serverEndpointsValidator.Field(serverEndpointName).Fail("mustConfigureServerEndpoint")
 		}
 	}
 
-	return webApp.multiTenancySupport.AddTenant(tenantID, config, webApp.httpMethodByServerEndpointSlots)
+	for index, x509Config := range config.X509 {
+		if x509Config.Validate() != nil {
+			continue
+		}
+		x509Validator := v.Field("x509").Index(index)
+		certificate, err := x509Config.Load()
+		if err != nil {
+			x509Validator.Failf("mustBeValidCertificate", "%s", err)
+			continue
+		}
+		if conflicts := webApp.X509Certificates().Conflicts(certificate); len(conflicts) > 0 {
+			x509Validator.Failf("certificateSubjectNameMustNotBeUsedByWebApp", "%q", conflicts)
+		}
+	}
+
+	for _, name := range validation.SortedKeys(config.Secrets) {
+		secretConfig := config.Secrets[name]
+		if secretConfig.Validate() != nil {
+			continue
+		}
+		if _, err := secretConfig.ResolveFrom(webApp.multiTenancySupport.SecretSources()); err != nil {
+			v.Field("secrets").Field(name).Failf("mustBeResolvable", "%s", err)
+		}
+	}
+
+	if err := v.Err(); err != nil {
+		return fmt.Errorf(TRACE+" WebApp AddTenant config: %w", err)
+	}
+	return nil
 }
 
 func (webApp *WebApp) DeleteTenant(tenantID string) error {
@@ -296,11 +656,99 @@ func (webApp *WebApp) DeleteTenant(tenantID string) error {
 	}
 	webApp.status = StatusRunning
 
-	return webApp.multiTenancySupport.RemoveTenant(tenantID)
+	if err := webApp.multiTenancySupport.RemoveTenant(tenantID); err != nil {
+		return err
+	}
+	webApp.refreshAcme()
+	return nil
+}
+
+// tenantsCommitted drops the limiter state and the metric series of the tenants a
+// commit removed, whether it deleted them, replaced the tenants or rolled back to a
+// generation without them.
+func (webApp *WebApp) tenantsCommitted(tenants multitenancy.TenantsConfig) {
+	webApp.tenantLimiters.retain(tenants)
+	webApp.tenantMetrics.retain(tenants)
+}
+
+// SetTenantState changes the status of a tenant. A draining tenant is deleted in
+// background once its requests in flight are finished.
+func (webApp *WebApp) SetTenantState(tenantID string, config multitenancy.TenantStateConfig) error {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return fmt.Errorf(TRACE + " WebApp SetTenantState: statusMustBeStatusSlotReservationOrStatusRunning")
+	}
+	webApp.status = StatusRunning
+
+	previousStatus, err := webApp.multiTenancySupport.SetTenantState(tenantID, config)
+	if err != nil {
+		return err
+	}
+
+	// Only the update that started draining the tenant waits to delete it.
+	if config.Status == multitenancy.TenantDraining && previousStatus != multitenancy.TenantDraining {
+		var drainTimeout time.Duration
+		if config.DrainTimeout != nil {
+			drainTimeout = time.Duration(*config.DrainTimeout) * time.Second
+		}
+		go webApp.drainTenant(tenantID, drainTimeout)
+	}
+	return nil
+}
+
+func (webApp *WebApp) drainTenant(tenantID string, drainTimeout time.Duration) {
+	if !webApp.multiTenancySupport.WaitForIdleTenant(tenantID, drainTimeout) {
+		fmt.Println(TRACE+" WebApp drainTenant: drainTimeoutExpired", tenantID)
+	}
+	if err := webApp.DeleteTenant(tenantID); err != nil {
+		fmt.Println(TRACE+" WebApp drainTenant:", err)
+	}
+}
+
+// RollbackTenants restores the tenants and routes of a previous generation.
+func (webApp *WebApp) RollbackTenants(generation uint64) (multitenancy.Generation, error) {
+	if webApp.status != StatusSlotReservation && webApp.status != StatusRunning {
+		return multitenancy.Generation{}, fmt.Errorf(TRACE + " WebApp RollbackTenants: statusMustBeStatusSlotReservationOrStatusRunning")
+	}
+	webApp.status = StatusRunning
+
+	current, err := webApp.multiTenancySupport.RollbackToGeneration(generation)
+	if err != nil {
+		return current, err
+	}
+	webApp.refreshAcme()
+	return current, nil
 }
 
-func getCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	//clientHello.ServerName
-	fmt.Println("TLS: ", clientHello.ServerName)
-	return nil, nil
+// getCertificate selects the certificate of the SNI server name: an exact name of a
+// tenant, global or ACME certificate first, then a wildcard one, then the default one.
+func (webApp *WebApp) getCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	tenantCertificates := webApp.multiTenancySupport.CertificateIndex()
+	globalCertificates := webApp.X509Certificates()
+	serverName := clientHello.ServerName
+
+	acmeManager := webApp.acmeManager()
+
+	if serverName != "" {
+		if certificate, found := tenantCertificates.GetExact(serverName); found {
+			return &certificate.TLSCertificate, nil
+		}
+		if certificate, found := globalCertificates.GetExact(serverName); found {
+			return &certificate.TLSCertificate, nil
+		}
+		if acmeManager != nil {
+			if certificate, found := acmeManager.CertificateIndex().GetExact(serverName); found {
+				return &certificate.TLSCertificate, nil
+			}
+		}
+		if certificate, found := tenantCertificates.GetWildcard(serverName); found {
+			return &certificate.TLSCertificate, nil
+		}
+		if certificate, found := globalCertificates.GetWildcard(serverName); found {
+			return &certificate.TLSCertificate, nil
+		}
+	}
+	if certificate, found := globalCertificates.Default(); found {
+		return &certificate.TLSCertificate, nil
+	}
+	return nil, fmt.Errorf(TRACE+" WebApp getCertificate: certificateNotFound \"%s\"", serverName)
 }
@@ -0,0 +1,201 @@
+package x509
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/riotemergence/godynamicweb/util"
+)
+
+// Certificate a certificate chain with its private key, and the names it is valid for.
+type Certificate struct {
+	TLSCertificate tls.Certificate `json:"-"`
+	CommonName     string          `json:"commonName"`
+	Names          []string        `json:"names"`
+	NotAfter       time.Time       `json:"notAfter"`
+	// Config the PEM the certificate was parsed from, to be kept.
+	Config X509Config `json:"-"`
+}
+
+func (c *Certificate) String() string {
+	return util.ToJson(c)
+}
+
+func ParseCertificate(privateKeyBytes, certificateChainBytes []byte) (*Certificate, error) {
+	certificateChainAndPrivateKey, err := tls.X509KeyPair(certificateChainBytes, privateKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" ParseCertificate: %s", err)
+	}
+
+	x509Certificate, err := x509.ParseCertificate(certificateChainAndPrivateKey.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf(TRACE+" ParseCertificate: %s", err)
+	}
+	certificateChainAndPrivateKey.Leaf = x509Certificate
+
+	names := make([]string, 0, len(x509Certificate.DNSNames)+1)
+	if commonName := normalizeName(x509Certificate.Subject.CommonName); commonName != "" {
+		names = append(names, commonName)
+	}
+	for _, subjectAlternativeName := range x509Certificate.DNSNames {
+		subjectAlternativeName = normalizeName(subjectAlternativeName)
+		if len(subjectAlternativeName) == 0 {
+			return nil, fmt.Errorf(TRACE + " ParseCertificate: CertificateSubjectAlternativeNameMustNotBeEmpty")
+		}
+		if subjectAlternativeName != normalizeName(x509Certificate.Subject.CommonName) {
+			names = append(names, subjectAlternativeName)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf(TRACE + " ParseCertificate: CertificateSubjectCommonNameOrAlternativeNameRequired")
+	}
+
+	return &Certificate{
+		TLSCertificate: certificateChainAndPrivateKey,
+		CommonName:     x509Certificate.Subject.CommonName,
+		Names:          names,
+		NotAfter:       x509Certificate.NotAfter,
+		Config:         X509Config{PKey: privateKeyBytes, Cert: certificateChainBytes},
+	}, nil
+}
+
+// HasName reports whether name is the common name or a subject alternative name of
+// the certificate. Wildcards are compared as they are, not matched.
+func (c *Certificate) HasName(name string) bool {
+	name = normalizeName(name)
+	for _, certificateName := range c.Names {
+		if certificateName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (x509Config X509Config) Load() (*Certificate, error) {
+	if err := x509Config.Validate(); err != nil {
+		return nil, err
+	}
+	return ParseCertificate(x509Config.PKey, x509Config.Cert)
+}
+
+// CertificateIndex finds certificates by the SNI server name of a TLS client hello.
+// An index is not modified once in use; changes are made on a Clone.
+type CertificateIndex struct {
+	byName             map[string]*Certificate
+	defaultCertificate *Certificate
+}
+
+func NewCertificateIndex() *CertificateIndex {
+	return &CertificateIndex{
+		byName: make(map[string]*Certificate),
+	}
+}
+
+func (i *CertificateIndex) Clone() *CertificateIndex {
+	clone := &CertificateIndex{
+		byName:             make(map[string]*Certificate, len(i.byName)),
+		defaultCertificate: i.defaultCertificate,
+	}
+	for k, v := range i.byName {
+		clone.byName[k] = v
+	}
+	return clone
+}
+
+// Add indexes a certificate by its common name and subject alternative names, that
+// must not be indexed already.
+func (i *CertificateIndex) Add(certificate *Certificate) error {
+	for _, name := range certificate.Names {
+		if _, found := i.byName[name]; found {
+			return fmt.Errorf(TRACE+" CertificateIndex Add: CertificateSubjectNameMustBeUnique \"%s\"", name)
+		}
+	}
+	for _, name := range certificate.Names {
+		i.byName[name] = certificate
+	}
+	return nil
+}
+
+// Remove drops every name of the certificate.
+func (i *CertificateIndex) Remove(certificate *Certificate) {
+	for _, name := range certificate.Names {
+		if i.byName[name] == certificate {
+			delete(i.byName, name)
+		}
+	}
+	if i.defaultCertificate == certificate {
+		i.defaultCertificate = nil
+	}
+}
+
+// Conflicts reports the names of the certificate that are already indexed.
+func (i *CertificateIndex) Conflicts(certificate *Certificate) []string {
+	conflicts := make([]string, 0)
+	for _, name := range certificate.Names {
+		if _, found := i.byName[name]; found {
+			conflicts = append(conflicts, name)
+		}
+	}
+	return conflicts
+}
+
+func (i *CertificateIndex) SetDefault(certificate *Certificate) {
+	i.defaultCertificate = certificate
+}
+
+func (i *CertificateIndex) Default() (*Certificate, bool) {
+	return i.defaultCertificate, i.defaultCertificate != nil
+}
+
+func (i *CertificateIndex) GetExact(serverName string) (*Certificate, bool) {
+	certificate, found := i.byName[normalizeName(serverName)]
+	return certificate, found
+}
+
+// GetWildcard finds a certificate for "*.example.com" when serverName is
+// "www.example.com". A wildcard only stands for the leftmost label.
+func (i *CertificateIndex) GetWildcard(serverName string) (*Certificate, bool) {
+	serverName = normalizeName(serverName)
+	firstDotIndex := strings.Index(serverName, ".")
+	if firstDotIndex <= 0 {
+		return nil, false
+	}
+	certificate, found := i.byName["*"+serverName[firstDotIndex:]]
+	return certificate, found
+}
+
+// Get finds the certificate for a server name, exact names first, then wildcards,
+// then the default certificate.
+func (i *CertificateIndex) Get(serverName string) (*Certificate, bool) {
+	if certificate, found := i.GetExact(serverName); found {
+		return certificate, true
+	}
+	if certificate, found := i.GetWildcard(serverName); found {
+		return certificate, true
+	}
+	return i.Default()
+}
+
+// Certificates every indexed certificate, once, sorted by their first name.
+func (i *CertificateIndex) Certificates() []*Certificate {
+	seen := make(map[*Certificate]bool)
+	certificates := make([]*Certificate, 0)
+	for _, certificate := range i.byName {
+		if !seen[certificate] {
+			seen[certificate] = true
+			certificates = append(certificates, certificate)
+		}
+	}
+	sort.Slice(certificates, func(a, b int) bool {
+		return certificates[a].Names[0] < certificates[b].Names[0]
+	})
+	return certificates
+}
+
+func normalizeName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
@@ -1,6 +1,6 @@
 package x509
 
-import "fmt"
+import "github.com/riotemergence/godynamicweb/validation"
 
 const TRACE = "github.com/riotemergence/x509"
 
@@ -10,11 +10,10 @@ type X509Config struct {
 }
 
 func (x509Config X509Config) Validate() error {
-	if x509Config.PKey == nil {
-		return fmt.Errorf(TRACE + " X509Config PKey: required")
-	}
-	if x509Config.Cert == nil {
-		return fmt.Errorf(TRACE + " X509Config Cert: required")
-	}
-	return nil
+	return validation.Validate(x509Config)
+}
+
+func (x509Config X509Config) ValidateWith(v *validation.Validator) {
+	v.Required("pkey", x509Config.PKey != nil)
+	v.Required("cert", x509Config.Cert != nil)
 }